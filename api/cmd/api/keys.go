@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"example.com/todos/pkg/models"
+)
+
+const keysUsage = "usage: api keys mint <user-id> <name> <scope>... | revoke <key-id>"
+
+// runKeys implements the `keys` subcommand, which mints the first admin key
+// before there is one to call the admin routes with.
+func runKeys(ctx context.Context, cfg Config, args []string, out io.Writer) error {
+	var in models.APIKeyInput
+	switch {
+	case len(args) >= 4 && args[0] == "mint":
+		in = models.APIKeyInput{UserId: args[1], Name: args[2], Scopes: args[3:]}
+		if err := in.Validate(); err != nil {
+			return err
+		}
+	case len(args) == 2 && args[0] == "revoke":
+	default:
+		return errors.New(keysUsage)
+	}
+
+	database, err := connectDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	if args[0] == "revoke" {
+		if err := database.RevokeKey(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked key %s\n", args[1])
+		return nil
+	}
+
+	key, secret, err := database.MintKey(ctx, in.Key())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "minted key %s for user %s, it is only shown once:\n%s\n", key.Id, key.UserId, secret)
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestRunKeys_RejectsBadArguments(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"mint", "1", "ci"},
+		{"mint", "1", "ci", "superuser"},
+		{"mint", "1", " ", "read"},
+		{"revoke"},
+		{"revoke", "1", "2"},
+		{"rotate", "1"},
+	}
+
+	for _, args := range tests {
+		if err := runKeys(context.Background(), Config{}, args, io.Discard); err == nil {
+			t.Errorf("expected an error for arguments %q", args)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -14,6 +15,7 @@ import (
 
 	"example.com/todos/pkg/db"
 	"example.com/todos/pkg/handlers"
+	"example.com/todos/pkg/jwt"
 	"example.com/todos/pkg/logging"
 	"example.com/todos/pkg/middleware"
 
@@ -27,24 +29,53 @@ func main() {
 		fmt.Println(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), cfg, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Migration error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(context.Background(), cfg, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Keys error: %v", err)
+		}
+		return
+	}
+
 	if err := run(context.Background(), cfg, 3*time.Second); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
 
 func run(ctx context.Context, cfg Config, shutdownTimeout time.Duration) error {
-	// Connect to the Postgres database
-	url := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", cfg.Db.User, cfg.Db.Pass, cfg.Db.Host, cfg.Db.Port, cfg.Db.Name)
-	log.Printf("Connecting to database at url %s..\n", url)
-	db := db.NewDB(context.Background(), url)
+	if err := cfg.Auth.JWT.validate(); err != nil {
+		return err
+	}
+
+	database, err := connectDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		log.Println("Closing DB connection...")
-		_ = db.Close()
+		log.Println("Closing DB connection pool...")
+		database.Close()
 	}()
 
-	handler := handlers.NewRouteHandler(db)
+	if cfg.MigrateOnStart {
+		if err := migrateUp(ctx, database, os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TrashRetention > 0 && cfg.TrashPurgeInterval > 0 {
+		purgeCtx, stopPurger := context.WithCancel(ctx)
+		defer stopPurger()
+		go runPurger(purgeCtx, database, cfg.TrashRetention, cfg.TrashPurgeInterval)
+	}
+
+	handler := handlers.NewRouteHandler(database)
 
-	router := setupRouter(handler)
+	router := setupRouter(handler, cfg.Auth)
 	server := createServer(cfg, router)
 
 	serverErr := make(chan error, 1)
@@ -71,9 +102,6 @@ func run(ctx context.Context, cfg Config, shutdownTimeout time.Duration) error {
 		log.Println("Context cancelled...")
 	}
 
-	log.Println("Closing DB connection...")
-	db.Close()
-
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 	if err := server.Shutdown(shutdownCtx); err != nil {
@@ -87,6 +115,26 @@ func run(ctx context.Context, cfg Config, shutdownTimeout time.Duration) error {
 	return nil
 }
 
+func connectDB(ctx context.Context, cfg Config) (*db.DB, error) {
+	// Connect to the Postgres database, without logging the password
+	dbURL := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.Db.User, cfg.Db.Pass),
+		Host:   cfg.Db.Host + ":" + cfg.Db.Port,
+		Path:   "/" + cfg.Db.Name,
+	}
+	log.Printf("Connecting to database at url %s..\n", dbURL.Redacted())
+	return db.NewDB(ctx, dbURL.String(), db.Config{
+		MaxConns:          cfg.Db.MaxConns,
+		MinConns:          cfg.Db.MinConns,
+		MaxConnLifetime:   cfg.Db.MaxConnLifetime,
+		MaxConnIdleTime:   cfg.Db.MaxConnIdleTime,
+		HealthCheckPeriod: cfg.Db.HealthCheckPeriod,
+
+		AutoCompleteParents: cfg.AutoCompleteParents,
+	})
+}
+
 func createServer(cfg Config, handler http.Handler) *http.Server {
 	addr := fmt.Sprintf(":%d", cfg.Port)
 
@@ -96,7 +144,11 @@ func createServer(cfg Config, handler http.Handler) *http.Server {
 	}
 }
 
-func setupRouter(h *handlers.RouteHandler) http.Handler {
+// localUserId is the user the migrations create to own the todos from before
+// there were users, without authentication every request acts as this user.
+const localUserId = "1"
+
+func setupRouter(h *handlers.RouteHandler, auth AuthConfig) http.Handler {
 	// Initialize the router
 	r := mux.NewRouter()
 
@@ -115,27 +167,181 @@ func setupRouter(h *handlers.RouteHandler) http.Handler {
 		func(next http.Handler) http.Handler {
 			return middleware.RequestIDMiddleware(next)
 		},
+		func(next http.Handler) http.Handler {
+			return middleware.RecoveryMiddleware(func(w http.ResponseWriter, r *http.Request, _ any) {
+				handlers.InternalError(w, r)
+			}, next)
+		},
+		func(next http.Handler) http.Handler {
+			if !auth.Enabled {
+				local := middleware.Principal{
+					UserId: localUserId,
+					Roles:  []string{middleware.RoleAdmin},
+					Scopes: []string{middleware.ScopeAdmin},
+				}
+				return middleware.DefaultPrincipalMiddleware(local, next)
+			}
+			return middleware.AuthMiddleware(auth.JWT.authenticator(h), auth.public, handlers.AuthFailed, next)
+		},
 	)
+	r.NotFoundHandler = http.HandlerFunc(handlers.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(handlers.MethodNotAllowed)
+
+	// Every route but the public ones requires a permission of the roles of
+	// the principal
+	authorize := func(perm middleware.Permission, next http.Handler) http.Handler {
+		return middleware.Authorize(middleware.DefaultPolicy, perm, handlers.AuthzDecided, handlers.AuthFailed, next)
+	}
+	read := func(h http.HandlerFunc) http.Handler { return authorize(middleware.PermRead, h) }
+	write := func(h http.HandlerFunc) http.Handler { return authorize(middleware.PermWrite, h) }
+	purge := func(h http.HandlerFunc) http.Handler { return authorize(middleware.PermPurge, h) }
+	// todos may be in lists shared with the principal, whose role in the list
+	// decides whether it may change them, its own role only its own todos
+	shared := func(h http.HandlerFunc) http.Handler {
+		return authorize(middleware.PermRead, middleware.SharedWrites(middleware.DefaultPolicy, h))
+	}
 
 	// Define API routes and their handlers
 	r.Handle("/metrics", handlers.NewMetricsHandler())
 	r.HandleFunc("/", handlers.Healthy).Methods("GET")
-	r.HandleFunc("/todos", h.GetTodos).Methods("GET")
-	r.HandleFunc("/todos/{id}", h.GetTodo).Methods("GET")
-	r.HandleFunc("/todos/{id}", h.UpdateTodo).Methods("PATCH")
-	r.HandleFunc("/todos", h.CreateTodo).Methods("POST")
-	r.HandleFunc("/todos/{id}", h.DeleteTodo).Methods("DELETE")
-	r.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+	r.Handle("/todos", read(h.GetTodos)).Methods("GET")
+	r.Handle("/todos/search", read(h.SearchTodos)).Methods("GET")
+	r.Handle("/todos/trash", read(h.GetTrash)).Methods("GET")
+	r.Handle("/todos/trash", purge(h.EmptyTrash)).Methods("DELETE")
+	r.Handle("/todos/trash/{id}", purge(h.PurgeTodo)).Methods("DELETE")
+	r.Handle("/todos/{id}", read(h.GetTodo)).Methods("GET")
+	r.Handle("/todos/{id}/series", read(h.GetTodoSeries)).Methods("GET")
+	r.Handle("/todos/{id}", shared(h.UpdateTodo)).Methods("PATCH")
+	r.Handle("/todos/{id}", shared(h.ReplaceTodo)).Methods("PUT")
+	r.Handle("/todos", write(h.CreateTodo)).Methods("POST")
+	r.Handle("/todos:batch", shared(h.BatchTodos)).Methods("POST")
+	r.Handle("/todos/archive-completed", write(h.ArchiveCompleted)).Methods("POST")
+	r.Handle("/todos/{id}", shared(h.DeleteTodo)).Methods("DELETE")
+	r.Handle("/todos/{id}/move", shared(h.MoveTodo)).Methods("POST")
+	r.Handle("/todos/{id}/restore", shared(h.RestoreTodo)).Methods("POST")
+	r.Handle("/todos/{id}/unarchive", shared(h.UnarchiveTodo)).Methods("POST")
+	r.Handle("/tags", read(h.GetTags)).Methods("GET")
+	r.Handle("/tags", write(h.CreateTag)).Methods("POST")
+	r.Handle("/tags/{id}", read(h.GetTag)).Methods("GET")
+	r.Handle("/tags/{id}", write(h.RenameTag)).Methods("PUT")
+	r.Handle("/tags/{id}", write(h.DeleteTag)).Methods("DELETE")
+	r.Handle("/lists", read(h.GetLists)).Methods("GET")
+	r.Handle("/lists", write(h.CreateList)).Methods("POST")
+	r.Handle("/lists/{id}", read(h.GetList)).Methods("GET")
+	r.Handle("/lists/{id}", write(h.RenameList)).Methods("PUT")
+	r.Handle("/lists/{id}", write(h.DeleteList)).Methods("DELETE")
+	r.Handle("/lists/{id}/todos", read(h.GetListTodos)).Methods("GET")
+	r.Handle("/lists/{id}/todos", shared(h.CreateListTodo)).Methods("POST")
+	r.Handle("/lists/{id}/members", read(h.GetMembers)).Methods("GET")
+	r.Handle("/lists/{id}/members/{userId}", write(h.SetMemberRole)).Methods("PUT")
+	r.Handle("/lists/{id}/members/{userId}", write(h.RemoveMember)).Methods("DELETE")
+	r.Handle("/lists/{id}/invitations", write(h.Invite)).Methods("POST")
+	r.Handle("/lists/{id}/transfer", write(h.TransferList)).Methods("POST")
+	r.Handle("/invitations/accept", read(h.AcceptInvitation)).Methods("POST")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(
+		func(next http.Handler) http.Handler {
+			return middleware.RequireScope(middleware.ScopeAdmin, handlers.AuthFailed, next)
+		},
+		func(next http.Handler) http.Handler {
+			return authorize(middleware.PermAdmin, next)
+		},
+	)
+	admin.HandleFunc("/users", h.CreateUser).Methods("POST")
+	admin.HandleFunc("/keys", h.GetKeys).Methods("GET")
+	admin.HandleFunc("/keys", h.MintKey).Methods("POST")
+	admin.HandleFunc("/keys/{id}", h.RevokeKey).Methods("DELETE")
+
+	r.Handle("/slow", read(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Slow request started...")
 		time.Sleep(8 * time.Second)
 		fmt.Fprintf(w, "Slow request completed at %v\n", time.Now())
-	})
+	}))
 	return r
 }
 
 type Config struct {
 	Port int `env:"PORT" envDefault:"8080"`
 	Db   DB  `envPrefix:"DB_"`
+
+	// MigrateOnStart applies pending schema migrations before serving
+	MigrateOnStart bool `env:"MIGRATE_ON_START" envDefault:"false"`
+
+	// AutoCompleteParents marks a todo done once all of its subtasks are
+	AutoCompleteParents bool `env:"AUTO_COMPLETE_PARENTS" envDefault:"false"`
+
+	// TrashRetention is how long deleted todos stay in the trash before they
+	// are purged, a zero retention or interval keeps them until they are
+	// purged by hand
+	TrashRetention     time.Duration `env:"TRASH_RETENTION" envDefault:"720h"`
+	TrashPurgeInterval time.Duration `env:"TRASH_PURGE_INTERVAL" envDefault:"1h"`
+
+	Auth AuthConfig `envPrefix:"AUTH_"`
+}
+
+type AuthConfig struct {
+	// Enabled requires a bearer token on every request but the public ones,
+	// otherwise every request acts as the local user
+	Enabled bool `env:"ENABLED" envDefault:"true"`
+
+	// PublicHealth and PublicMetrics serve / and /metrics without a key
+	PublicHealth  bool `env:"PUBLIC_HEALTH" envDefault:"true"`
+	PublicMetrics bool `env:"PUBLIC_METRICS" envDefault:"true"`
+
+	JWT JWTConfig `envPrefix:"JWT_"`
+}
+
+// JWTConfig accepts the JWTs of an identity provider besides API keys.
+type JWTConfig struct {
+	// JWKS is the path or URL of the provider's key set, without it only API
+	// keys are accepted
+	JWKS     string `env:"JWKS"`
+	Issuer   string `env:"ISSUER"`
+	Audience string `env:"AUDIENCE"`
+
+	// Leeway is the clock skew allowed checking the token's times
+	Leeway time.Duration `env:"LEEWAY" envDefault:"1m"`
+
+	// KeysTTL is how long the key set is cached, a token signed with a key
+	// that isn't in it loads it again at most every KeysMinReload
+	KeysTTL       time.Duration `env:"KEYS_TTL" envDefault:"1h"`
+	KeysMinReload time.Duration `env:"KEYS_MIN_RELOAD" envDefault:"30s"`
+}
+
+// validate checks that tokens can only be accepted from one issuer, for
+// this API.
+func (c JWTConfig) validate() error {
+	if c.JWKS != "" && (c.Issuer == "" || c.Audience == "") {
+		return errors.New("AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE are required with AUTH_JWT_JWKS")
+	}
+	return nil
+}
+
+// authenticator returns the middleware.Authenticator for API keys, and JWTs
+// if a key set is configured.
+func (c JWTConfig) authenticator(h *handlers.RouteHandler) middleware.Authenticator {
+	if c.JWKS == "" {
+		return h.Authenticate
+	}
+	return h.TokenAuthenticator(&jwt.Verifier{
+		Keys:     &jwt.KeySet{Source: c.JWKS, TTL: c.KeysTTL, MinReload: c.KeysMinReload},
+		Issuer:   c.Issuer,
+		Audience: c.Audience,
+		Leeway:   c.Leeway,
+	})
+}
+
+// public reports whether r may be served without credentials.
+func (a AuthConfig) public(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/":
+		return a.PublicHealth
+	case "/metrics":
+		return a.PublicMetrics
+	default:
+		return false
+	}
 }
 
 type DB struct {
@@ -144,4 +350,12 @@ type DB struct {
 	User string `env:"USER"`
 	Pass string `env:"PASS"`
 	Name string `env:"NAME"`
+
+	// Connection pool sizing, setting one to 0 falls back to the pgxpool
+	// default
+	MaxConns          int32         `env:"MAX_CONNS" envDefault:"10"`
+	MinConns          int32         `env:"MIN_CONNS" envDefault:"2"`
+	MaxConnLifetime   time.Duration `env:"MAX_CONN_LIFETIME" envDefault:"1h"`
+	MaxConnIdleTime   time.Duration `env:"MAX_CONN_IDLE_TIME" envDefault:"30m"`
+	HealthCheckPeriod time.Duration `env:"HEALTH_CHECK_PERIOD" envDefault:"1m"`
 }
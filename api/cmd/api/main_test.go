@@ -2,21 +2,30 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"slices"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"example.com/todos/pkg/db"
 	"example.com/todos/pkg/handlers"
+	"example.com/todos/pkg/middleware"
 	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
 )
 
 func TestHandler(t *testing.T) {
-	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()))
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
 
 	// health
 	rr := httptest.NewRecorder()
@@ -33,7 +42,7 @@ func TestHandler(t *testing.T) {
 		Title: "something new",
 	}
 
-	todo1Bytes, err := json.Marshal(todo1)
+	todo1Bytes, err := json.Marshal(models.TodoInput{Title: todo1.Title})
 	if err != nil {
 		panic("ahhh")
 	}
@@ -83,7 +92,7 @@ func TestHandler(t *testing.T) {
 		Title: "something else",
 	}
 
-	todo2Bytes, err := json.Marshal(todo2)
+	todo2Bytes, err := json.Marshal(models.TodoInput{Title: todo2.Title})
 	if err != nil {
 		panic("ahhh")
 	}
@@ -116,16 +125,8 @@ func TestHandler(t *testing.T) {
 	}
 
 	// update Todo
-	todo2Done := models.Todo{
-		Done: true,
-	}
-	todo2Bytes, err = json.Marshal(todo2Done)
-	if err != nil {
-		panic("ahhh")
-	}
-
 	rr = httptest.NewRecorder()
-	patch := httptest.NewRequest(http.MethodPatch, "/todos/2", bytes.NewBuffer(todo2Bytes))
+	patch := httptest.NewRequest(http.MethodPatch, "/todos/2", strings.NewReader(`{"done":true}`))
 	handler.ServeHTTP(rr, patch)
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -198,79 +199,2099 @@ func TestHandler(t *testing.T) {
 	}
 }
 
-type InMemoryDB struct {
-	todos []models.Todo
-	id    int
+func TestHandler_Problems(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"missing todo", http.MethodGet, "/todos/1986", "", http.StatusNotFound},
+		{"unknown route", http.MethodGet, "/nope", "", http.StatusNotFound},
+		{"unsupported method", http.MethodPut, "/todos", "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, "/todos", "{", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.status)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != handlers.ProblemContentType {
+				t.Fatalf("handler returned wrong content type: got %v want %v", ct, handlers.ProblemContentType)
+			}
+
+			var p handlers.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem, %v", err)
+			}
+			if p.Status != tt.status {
+				t.Errorf("problem has wrong status: got %v want %v", p.Status, tt.status)
+			}
+			if p.RequestID == "" || p.RequestID != rr.Header().Get("X-Request-Id") {
+				t.Errorf("problem request id %q does not match header %q", p.RequestID, rr.Header().Get("X-Request-Id"))
+			}
+		})
+	}
+}
+
+func TestHandler_Validation(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	rr := httptest.NewRecorder()
+	create := httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"title":"  padded  "}`))
+	handler.ServeHTTP(rr, create)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var created models.Todo
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode todo, %v", err)
+	}
+	if created.Title != "padded" {
+		t.Errorf("expected title to be trimmed: got %q want %q", created.Title, "padded")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		field  string
+	}{
+		{"missing title", http.MethodPost, "/todos", `{}`, http.StatusUnprocessableEntity, "title"},
+		{"blank title", http.MethodPost, "/todos", `{"title":"   "}`, http.StatusUnprocessableEntity, "title"},
+		{"long title", http.MethodPost, "/todos", `{"title":"` + strings.Repeat("a", models.MaxTitleLength+1) + `"}`, http.StatusUnprocessableEntity, "title"},
+		{"unknown field", http.MethodPost, "/todos", `{"title":"a","colour":"red"}`, http.StatusUnprocessableEntity, "colour"},
+		{"wrong type", http.MethodPost, "/todos", `{"title":5}`, http.StatusUnprocessableEntity, "title"},
+		{"empty body", http.MethodPost, "/todos", ``, http.StatusBadRequest, ""},
+		{"trailing data", http.MethodPost, "/todos", `{"title":"a"} {}`, http.StatusBadRequest, ""},
+		{"too large", http.MethodPost, "/todos", `{"title":"` + strings.Repeat("a", handlers.MaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{"blank title update", http.MethodPatch, "/todos/1", `{"title":""}`, http.StatusUnprocessableEntity, "title"},
+		{"malformed update", http.MethodPatch, "/todos/1", `{"done":`, http.StatusBadRequest, ""},
+		{"wrong type update", http.MethodPatch, "/todos/1", `{"done":"yes"}`, http.StatusUnprocessableEntity, "done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tt.status)
+			}
+
+			var p handlers.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem, %v", err)
+			}
+			if tt.field == "" {
+				return
+			}
+			if len(p.Errors) != 1 || p.Errors[0].Field != tt.field {
+				t.Fatalf("expected a single error for field %q, got %+v", tt.field, p.Errors)
+			}
+		})
+	}
+
+	// nothing invalid should have been written
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos", nil))
+	var allTodos []models.Todo
+	if err := json.NewDecoder(rr.Body).Decode(&allTodos); err != nil {
+		t.Fatalf("failed to decode todos, %v", err)
+	}
+	if len(allTodos) != 1 || allTodos[0].Title != "padded" {
+		t.Fatalf("expected only the valid todo to be stored, got %+v", allTodos)
+	}
 }
 
-func newInMemoryDB() Database {
-	return &InMemoryDB{
-		todos: []models.Todo{},
-		id:    0,
+func TestHandler_PatchAndReplace(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) models.Todo {
+		var todo models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todo); err != nil {
+			t.Fatalf("failed to decode todo, %v", err)
+		}
+		return todo
+	}
+
+	rr := send(http.MethodPost, "/todos", "", `{"title":"original"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	// merge patch only touches the fields present and returns the result
+	rr = send(http.MethodPatch, "/todos/1", "application/merge-patch+json", `{"done":true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if todo := decode(rr); todo.Title != "original" || !todo.Done {
+		t.Fatalf("patch returned wrong todo: got %+v", todo)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", "application/json", `{"title":"renamed"}`)
+	if todo := decode(rr); todo.Title != "renamed" || !todo.Done {
+		t.Fatalf("patch returned wrong todo: got %+v", todo)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", "", `{"title":null}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code for null title: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", "text/plain", `{"done":false}`)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnsupportedMediaType)
+	}
+	if rr.Header().Get("Accept-Patch") == "" {
+		t.Errorf("expected Accept-Patch header on unsupported media type")
+	}
+
+	// put replaces every field, leaving out done resets it
+	rr = send(http.MethodPut, "/todos/1", "", `{"title":"replaced"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if todo := decode(rr); todo.Title != "replaced" || todo.Done {
+		t.Fatalf("put returned wrong todo: got %+v", todo)
+	}
+
+	rr = send(http.MethodPut, "/todos/1", "", `{"done":true}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code for put without title: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	rr = send(http.MethodPut, "/todos/1986", "", `{"title":"missing"}`)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
 	}
 }
 
-// Create implements Database.
-func (db *InMemoryDB) Create(todo models.Todo) (id string, err error) {
-	db.id++
-	todo.Id = strconv.Itoa(db.id)
-	todo.CreatedAt = time.Now()
-	db.todos = append(db.todos, todo)
-	return id, nil
+func TestHandler_JSONPatch(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func() models.Todo {
+		rr := send(http.MethodGet, "/todos/1", "", "")
+		var todo models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todo); err != nil {
+			t.Fatalf("failed to decode todo, %v", err)
+		}
+		return todo
+	}
+
+	send(http.MethodPost, "/todos", "", `{"title":"original"}`)
+
+	rr := send(http.MethodPatch, "/todos/1", handlers.JSONPatchContentType,
+		`[{"op":"test","path":"/title","value":"original"},{"op":"replace","path":"/done","value":true}]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if todo := get(); todo.Title != "original" || !todo.Done {
+		t.Fatalf("patch was not applied: got %+v", todo)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"failing test", `[{"op":"test","path":"/title","value":"stale"},{"op":"replace","path":"/title","value":"lost update"}]`, http.StatusConflict},
+		{"read-only field", `[{"op":"replace","path":"/id","value":"2"}]`, http.StatusUnprocessableEntity},
+		{"unknown field", `[{"op":"add","path":"/colour","value":"red"}]`, http.StatusUnprocessableEntity},
+		{"invalid title", `[{"op":"replace","path":"/title","value":""}]`, http.StatusUnprocessableEntity},
+		{"missing path", `[{"op":"remove","path":"/nope"}]`, http.StatusUnprocessableEntity},
+		{"unknown op", `[{"op":"frobnicate","path":"/title"}]`, http.StatusUnprocessableEntity},
+		{"not a patch", `{"title":"merge patch"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(http.MethodPatch, "/todos/1", handlers.JSONPatchContentType, tt.body)
+			if rr.Code != tt.status {
+				t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, tt.status, rr.Body)
+			}
+			if todo := get(); todo.Title != "original" || !todo.Done {
+				t.Fatalf("failed patch modified the todo: got %+v", todo)
+			}
+		})
+	}
+
+	rr = send(http.MethodPatch, "/todos/1986", handlers.JSONPatchContentType, `[{"op":"replace","path":"/done","value":false}]`)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
 }
 
-// Get implements Database.
-func (db *InMemoryDB) Get(id string) (todo models.Todo, err error) {
-	for _, todo := range db.todos {
-		if todo.Id == id {
-			return todo, nil
+func TestHandler_Pagination(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	for i := range 5 {
+		rr := httptest.NewRecorder()
+		body := strings.NewReader(`{"title":"todo ` + strconv.Itoa(i) + `"}`)
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todos", body))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	}
+
+	// follow the Link header until the last page
+	var ids []string
+	path := "/todos?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages, last link %s", path)
+		}
+		rr := get(path)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var todos []models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+			t.Fatalf("failed to decode todos, %v", err)
+		}
+		for _, todo := range todos {
+			ids = append(ids, todo.Id)
+		}
+
+		path = ""
+		if link := rr.Header().Get("Link"); link != "" {
+			if !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Fatalf("unexpected Link header: %s", link)
+			}
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			if !strings.Contains(path, "limit=2") {
+				t.Errorf("next link dropped the limit: %s", path)
+			}
 		}
 	}
-	return models.Todo{}, fmt.Errorf("not found")
+	if want := []string{"1", "2", "3", "4", "5"}; !slices.Equal(ids, want) {
+		t.Fatalf("pages returned wrong todos: got %v want %v", ids, want)
+	}
+
+	// the envelope carries the cursor in the body
+	rr := get("/todos?limit=3&envelope=true")
+	var page handlers.TodoPage
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode page, %v", err)
+	}
+	if len(page.Items) != 3 || page.NextCursor == "" {
+		t.Fatalf("envelope returned wrong page: got %+v", page)
+	}
+	rr = get("/todos?envelope=true&cursor=" + page.NextCursor)
+	page = handlers.TodoPage{}
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode page, %v", err)
+	}
+	if len(page.Items) != 2 || page.NextCursor != "" || rr.Header().Get("Link") != "" {
+		t.Fatalf("envelope returned wrong last page: got %+v", page)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"zero limit", "limit=0", "limit"},
+		{"limit too large", "limit=501", "limit"},
+		{"limit not a number", "limit=ten", "limit"},
+		{"garbage cursor", "cursor=not-a-cursor", "cursor"},
+		{"bad envelope", "envelope=maybe", "envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := get("/todos?" + tt.query)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+			}
+			var p handlers.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem, %v", err)
+			}
+			if len(p.Errors) != 1 || p.Errors[0].Field != tt.field {
+				t.Fatalf("problem has wrong errors: got %+v want field %q", p.Errors, tt.field)
+			}
+		})
+	}
 }
 
-// Update implements Database.
-func (db *InMemoryDB) Update(id string, todo models.Todo) (count int64, err error) {
-	for i, t := range db.todos {
-		if t.Id == id {
-			title := todo.Title
-			if title == "" {
-				title = t.Title
+func TestHandler_FilterAndSort(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	for _, body := range []string{`{"title":"walk the dog"}`, `{"title":"buy milk","done":true}`, `{"title":"wash the car"}`} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"open", "done=false", []string{"1", "3"}},
+		{"done", "done=true", []string{"2"}},
+		{"title", "title_contains=THE", []string{"1", "3"}},
+		{"created this week", "created_after=" + time.Now().Add(-time.Hour).Format(time.RFC3339), []string{"1", "2", "3"}},
+		{"created before", "created_before=2000-01-01", nil},
+		{"open newest first", "done=false&sort=-createdAt", []string{"3", "1"}},
+		{"by title", "sort=title", []string{"2", "1", "3"}},
+		{"paged by done", "sort=-done,title&limit=1", []string{"2", "1", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			path := "/todos?" + tt.query
+			for path != "" {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+				}
+				var todos []models.Todo
+				if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+					t.Fatalf("failed to decode todos, %v", err)
+				}
+				for _, todo := range todos {
+					ids = append(ids, todo.Id)
+				}
+				path = strings.TrimSuffix(strings.TrimPrefix(rr.Header().Get("Link"), "<"), `>; rel="next"`)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Fatalf("handler returned wrong todos: got %v want %v", ids, tt.want)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name   string
+		query  string
+		fields []string
+	}{
+		{"done", "done=yes", []string{"done"}},
+		{"created after", "created_after=last+week", []string{"created_after"}},
+		{"empty range", "created_after=2024-05-02&created_before=2024-05-01", []string{"created_before"}},
+		{"sort field", "sort=colour", []string{"sort"}},
+		{"sort twice", "sort=title,-title", []string{"sort"}},
+		{"unknown parameter", "status=open", []string{"status"}},
+		{"several", "done=maybe&sort=-", []string{"sort", "done"}},
+	}
+	for _, tt := range invalid {
+		t.Run("invalid "+tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos?"+tt.query, nil))
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
 			}
-			db.todos[i] = models.Todo{
-				Id:        t.Id,
-				Title:     title,
-				Done:      todo.Done,
-				CreatedAt: t.CreatedAt,
+			var p handlers.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem, %v", err)
 			}
-			return 1, nil
+			var fields []string
+			for _, fe := range p.Errors {
+				fields = append(fields, fe.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Fatalf("problem has wrong errors: got %+v want fields %v", p.Errors, tt.fields)
+			}
+		})
+	}
+
+	// a cursor only continues the listing it came from
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos?limit=1", nil))
+	next := strings.TrimSuffix(strings.TrimPrefix(rr.Header().Get("Link"), "<"), `>; rel="next"`)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, next+"&sort=title", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code for a cursor with another sort: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_Search(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	for _, title := range []string{"Buy milk", "Buy bread and milk", "Walk the dog <b>now</b>"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/todos", strings.NewReader(`{"title":"`+title+`"}`)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	}
+
+	search := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/todos/search?"+query, nil))
+		return rr
+	}
+
+	rr := search("q=milk")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var results []models.SearchResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode search results, %v", err)
+	}
+	if len(results) != 2 || results[0].Id != "1" || results[1].Id != "2" {
+		t.Fatalf("search returned wrong todos, best match first: got %+v", results)
+	}
+	if results[0].Snippet != "Buy <mark>milk</mark>" || results[0].Rank <= results[1].Rank {
+		t.Fatalf("search returned wrong snippet or rank: got %+v", results[0])
+	}
+
+	rr = search("q=milk+-bread&limit=5")
+	results = nil
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode search results, %v", err)
+	}
+	if len(results) != 1 || results[0].Id != "1" {
+		t.Fatalf("search returned wrong todos for excluded word: got %+v", results)
+	}
+
+	rr = search("q=dog")
+	results = nil
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("failed to decode search results, %v", err)
+	}
+	if len(results) != 1 || results[0].Snippet != "Walk the <mark>dog</mark> &lt;b&gt;now&lt;/b&gt;" {
+		t.Fatalf("expected the snippet to be escaped: got %+v", results)
+	}
+
+	rr = search("q=cheese")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("search without matches should return an empty list: got %v %s", rr.Code, rr.Body)
+	}
+
+	for _, query := range []string{"", "q=++", "q=milk&limit=0", "q=milk&sort=title", "q=" + strings.Repeat("a", handlers.MaxSearchQueryLength+1)} {
+		if rr := search(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %q: got %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandler_TodoDetails(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) models.Todo {
+		var todo models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todo); err != nil {
+			t.Fatalf("failed to decode todo, %v", err)
+		}
+		return todo
+	}
+
+	past := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	rr := send(http.MethodPost, "/todos", "", `{"title":"file taxes","description":"- receipts\n- forms","priority":"urgent","dueAt":"`+past+`"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	created := decode(rr)
+	if created.Priority != models.PriorityUrgent || created.Description != "- receipts\n- forms" || created.DueAt == nil {
+		t.Fatalf("create returned wrong todo: got %+v", created)
+	}
+	if created.CreatedAt.IsZero() || created.UpdatedAt.IsZero() || created.CompletedAt != nil {
+		t.Fatalf("create returned wrong timestamps: got %+v", created)
+	}
+
+	send(http.MethodPost, "/todos", "", `{"title":"water plants"}`)
+
+	ids := func(query string) []string {
+		rr := send(http.MethodGet, "/todos?"+query, "", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var todos []models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+			t.Fatalf("failed to decode todos, %v", err)
 		}
+		var ids []string
+		for _, todo := range todos {
+			ids = append(ids, todo.Id)
+		}
+		return ids
+	}
+	if got := ids("overdue=true"); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("overdue filter returned wrong todos: got %v", got)
+	}
+	if got := ids("overdue=false"); !slices.Equal(got, []string{"2"}) {
+		t.Fatalf("overdue=false returned wrong todos: got %v", got)
+	}
+	if got := ids("priority=high,urgent"); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("priority filter returned wrong todos: got %v", got)
+	}
+	if got := ids("sort=dueAt"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("todos without a due date must sort last: got %v", got)
+	}
+	if got := ids("sort=-dueAt&limit=1"); !slices.Equal(got, []string{"2"}) {
+		t.Fatalf("todos without a due date must sort first when descending: got %v", got)
+	}
+
+	// completing a todo stamps it and it is no longer overdue
+	rr = send(http.MethodPatch, "/todos/1", "application/merge-patch+json", `{"done":true,"description":null}`)
+	done := decode(rr)
+	if done.CompletedAt == nil || done.Description != "" || done.UpdatedAt.Before(created.UpdatedAt) {
+		t.Fatalf("patch returned wrong todo: got %+v", done)
+	}
+	if got := ids("overdue=true"); len(got) != 0 {
+		t.Fatalf("a completed todo must not be overdue: got %v", got)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", "application/merge-patch+json", `{"dueAt":null,"done":false}`)
+	if reopened := decode(rr); reopened.DueAt != nil || reopened.CompletedAt != nil {
+		t.Fatalf("patch returned wrong todo: got %+v", reopened)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", handlers.JSONPatchContentType, `[{"op":"replace","path":"/priority","value":"low"}]`)
+	if patched := decode(rr); patched.Priority != models.PriorityLow || patched.Title != "file taxes" {
+		t.Fatalf("json patch returned wrong todo: got %+v", patched)
+	}
+
+	invalid := []struct {
+		name, method, contentType, body, field string
+	}{
+		{"bad priority", http.MethodPost, "", `{"title":"a","priority":"asap"}`, "priority"},
+		{"bad due date", http.MethodPost, "", `{"title":"a","dueAt":"next week"}`, "dueAt"},
+		{"due date number", http.MethodPut, "", `{"title":"a","dueAt":5}`, "dueAt"},
+		{"long description", http.MethodPut, "", `{"title":"a","description":"` + strings.Repeat("a", models.MaxDescriptionLength+1) + `"}`, "description"},
+		{"read-only updatedAt", http.MethodPatch, handlers.JSONPatchContentType, `[{"op":"replace","path":"/updatedAt","value":"2000-01-01T00:00:00Z"}]`, "updatedAt"},
+		{"json patch bad due date", http.MethodPatch, handlers.JSONPatchContentType, `[{"op":"replace","path":"/dueAt","value":"soon"}]`, "dueAt"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/todos/1"
+			if tt.method == http.MethodPost {
+				path = "/todos"
+			}
+			rr := send(tt.method, path, tt.contentType, tt.body)
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+			}
+			var p handlers.Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem, %v", err)
+			}
+			if len(p.Errors) != 1 || p.Errors[0].Field != tt.field {
+				t.Fatalf("problem has wrong errors: got %+v want field %q", p.Errors, tt.field)
+			}
+		})
 	}
-	return 0, fmt.Errorf("not found")
 }
 
-// Delete implements Database.
-func (db *InMemoryDB) Delete(id string) (count int64, err error) {
-	for i, todo := range db.todos {
-		if todo.Id == id {
-			db.todos = slices.Delete(db.todos, i, i+1)
-			return 1, nil
+func TestHandler_Tags(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+
+	// tags are created on demand and normalized
+	rr := send(http.MethodPost, "/todos", `{"title":"fix login","tags":["Backend","urgent","backend"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	var todo models.Todo
+	decode(rr, &todo)
+	if !slices.Equal(todo.Tags, []string{"backend", "urgent"}) {
+		t.Fatalf("create returned wrong tags: got %v", todo.Tags)
+	}
+	send(http.MethodPost, "/todos", `{"title":"restyle login","tags":["frontend"]}`)
+	rr = send(http.MethodPost, "/todos", `{"title":"no tags"}`)
+	decode(rr, &todo)
+	if todo.Tags == nil {
+		t.Fatalf("todos without tags must have an empty list, not null")
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", `{"addTags":["frontend"],"removeTags":["urgent"]}`)
+	decode(rr, &todo)
+	if !slices.Equal(todo.Tags, []string{"backend", "frontend"}) {
+		t.Fatalf("patch returned wrong tags: got %v", todo.Tags)
+	}
+
+	ids := func(query string) []string {
+		rr := send(http.MethodGet, "/todos?"+query, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var todos []models.Todo
+		decode(rr, &todos)
+		var ids []string
+		for _, todo := range todos {
+			ids = append(ids, todo.Id)
+		}
+		return ids
+	}
+	if got := ids("tag=backend&tag=frontend"); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("tag filter returned wrong todos: got %v", got)
+	}
+	if got := ids("tag=backend&tag=frontend&tag_match=any"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("tag_match=any returned wrong todos: got %v", got)
+	}
+	if got := ids("tag=Frontend"); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("tag filter must ignore case: got %v", got)
+	}
+
+	var tags []models.Tag
+	decode(send(http.MethodGet, "/tags", ""), &tags)
+	if len(tags) != 3 || tags[0].Name != "backend" || tags[1].Name != "frontend" || tags[1].TodoCount != 2 || tags[2].TodoCount != 0 {
+		t.Fatalf("list returned wrong tags: got %+v", tags)
+	}
+
+	rr = send(http.MethodPost, "/tags", `{"name":"Ops"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var ops models.Tag
+	decode(rr, &ops)
+	if ops.Name != "ops" {
+		t.Fatalf("create returned wrong tag: got %+v", ops)
+	}
+	if rr := send(http.MethodPost, "/tags", `{"name":"ops"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code for a duplicate tag: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// renaming and deleting a tag changes every todo it labels
+	rr = send(http.MethodPut, "/tags/"+tags[1].Id, `{"name":"web"}`)
+	var renamed models.Tag
+	decode(rr, &renamed)
+	if renamed.Name != "web" || renamed.TodoCount != 2 {
+		t.Fatalf("rename returned wrong tag: got %+v", renamed)
+	}
+	if rr := send(http.MethodPut, "/tags/"+tags[1].Id, `{"name":"ops"}`); rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code for a taken name: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	decode(send(http.MethodGet, "/todos/2", ""), &todo)
+	if !slices.Equal(todo.Tags, []string{"web"}) {
+		t.Fatalf("rename did not change the todo: got %v", todo.Tags)
+	}
+
+	if rr := send(http.MethodDelete, "/tags/"+tags[0].Id, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	decode(send(http.MethodGet, "/todos/1", ""), &todo)
+	if !slices.Equal(todo.Tags, []string{"web"}) {
+		t.Fatalf("delete did not remove the tag from the todo: got %v", todo.Tags)
+	}
+	if rr := send(http.MethodGet, "/tags/"+tags[0].Id, ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	invalid := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"blank tag name", http.MethodPost, "/tags", `{"name":" "}`, http.StatusUnprocessableEntity},
+		{"tag with spaces", http.MethodPost, "/todos", `{"title":"a","tags":["two words"]}`, http.StatusUnprocessableEntity},
+		{"add and remove", http.MethodPatch, "/todos/1", `{"addTags":["a"],"removeTags":["a"]}`, http.StatusUnprocessableEntity},
+		{"bad tag filter", http.MethodGet, "/todos?tag=", "", http.StatusBadRequest},
+		{"bad tag match", http.MethodGet, "/todos?tag=a&tag_match=some", "", http.StatusBadRequest},
+	}
+	for _, tt := range invalid {
+		if rr := send(tt.method, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.status)
 		}
 	}
-	return 0, fmt.Errorf("not found")
 }
 
-// GetAll implements Database.
-func (db *InMemoryDB) GetAll() (todos []models.Todo, err error) {
-	return db.todos, nil
+func TestHandler_Lists(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+
+	var lists []models.List
+	decode(send(http.MethodGet, "/lists", ""), &lists)
+	if len(lists) != 1 || !lists[0].Inbox {
+		t.Fatalf("expected only the inbox, got %+v", lists)
+	}
+	inbox := lists[0]
+
+	rr := send(http.MethodPost, "/lists", `{"name":" Work "}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	var work models.List
+	decode(rr, &work)
+	if work.Name != "Work" || work.Inbox {
+		t.Fatalf("create returned wrong list: got %+v", work)
+	}
+
+	// todos go to the inbox unless a list is given
+	var todo models.Todo
+	decode(send(http.MethodPost, "/todos", `{"title":"buy milk"}`), &todo)
+	if todo.ListId != inbox.Id {
+		t.Fatalf("expected the todo in the inbox, got list %q", todo.ListId)
+	}
+	send(http.MethodPost, "/todos", `{"title":"write report","listId":"`+work.Id+`"}`)
+	rr = send(http.MethodPost, "/lists/"+work.Id+"/todos", `{"title":"send invoice","done":true}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	decode(rr, &todo)
+	if todo.ListId != work.Id {
+		t.Fatalf("expected the todo in list %q, got %q", work.Id, todo.ListId)
+	}
+
+	decode(send(http.MethodGet, "/lists/"+work.Id, ""), &work)
+	if work.OpenCount != 1 || work.DoneCount != 1 {
+		t.Fatalf("get returned wrong counts: got %+v", work)
+	}
+
+	var todos []models.Todo
+	decode(send(http.MethodGet, "/lists/"+work.Id+"/todos?sort=title", ""), &todos)
+	if len(todos) != 2 || todos[0].Title != "send invoice" || todos[1].Title != "write report" {
+		t.Fatalf("nested list returned wrong todos: got %+v", todos)
+	}
+
+	// moving a todo between lists with PATCH, leaving it out of PUT keeps it
+	decode(send(http.MethodPatch, "/todos/1", `{"listId":"`+work.Id+`"}`), &todo)
+	if todo.ListId != work.Id {
+		t.Fatalf("patch did not move the todo: got list %q", todo.ListId)
+	}
+	decode(send(http.MethodGet, "/lists/"+work.Id+"/todos?sort=position", ""), &todos)
+	if len(todos) != 3 || todos[2].Id != "1" {
+		t.Fatalf("patch did not put the todo last in its new list: got %+v", todos)
+	}
+	decode(send(http.MethodPut, "/todos/1", `{"title":"buy oat milk"}`), &todo)
+	if todo.ListId != work.Id {
+		t.Fatalf("put without a listId moved the todo: got list %q", todo.ListId)
+	}
+
+	decode(send(http.MethodPut, "/lists/"+work.Id, `{"name":"Office"}`), &work)
+	if work.Name != "Office" || work.OpenCount != 2 {
+		t.Fatalf("rename returned wrong list: got %+v", work)
+	}
+
+	// deleting moves the todos to the inbox by default
+	if rr := send(http.MethodDelete, "/lists/"+work.Id, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	decode(send(http.MethodGet, "/lists/"+inbox.Id, ""), &inbox)
+	if inbox.OpenCount != 2 || inbox.DoneCount != 1 {
+		t.Fatalf("delete did not move the todos to the inbox: got %+v", inbox)
+	}
+
+	var home models.List
+	decode(send(http.MethodPost, "/lists", `{"name":"Home"}`), &home)
+	send(http.MethodPost, "/lists/"+home.Id+"/todos", `{"title":"water plants"}`)
+	if rr := send(http.MethodDelete, "/lists/"+home.Id+"?mode=cascade", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	decode(send(http.MethodGet, "/todos", ""), &todos)
+	if len(todos) != 3 {
+		t.Fatalf("cascade did not delete the todos in the list: got %d todos", len(todos))
+	}
+	// they are in the trash, and are restored to the inbox
+	decode(send(http.MethodGet, "/todos/trash", ""), &todos)
+	if len(todos) != 1 || todos[0].Title != "water plants" {
+		t.Fatalf("cascade did not move the todos to the trash: got %+v", todos)
+	}
+	var restored models.Todo
+	decode(send(http.MethodPost, "/todos/"+todos[0].Id+"/restore", ""), &restored)
+	if restored.ListId != inbox.Id || restored.DeletedAt != nil {
+		t.Fatalf("restore did not put the todo in the inbox: got %+v", restored)
+	}
+
+	invalid := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"blank list name", http.MethodPost, "/lists", `{"name":""}`, http.StatusUnprocessableEntity},
+		{"unknown list", http.MethodGet, "/lists/" + work.Id, "", http.StatusNotFound},
+		{"unknown nested list", http.MethodGet, "/lists/" + work.Id + "/todos", "", http.StatusNotFound},
+		{"create in unknown list", http.MethodPost, "/lists/" + work.Id + "/todos", `{"title":"a"}`, http.StatusNotFound},
+		{"mismatched list", http.MethodPost, "/lists/" + inbox.Id + "/todos", `{"title":"a","listId":"99"}`, http.StatusUnprocessableEntity},
+		{"todo in unknown list", http.MethodPost, "/todos", `{"title":"a","listId":"99"}`, http.StatusUnprocessableEntity},
+		{"null list", http.MethodPatch, "/todos/1", `{"listId":null}`, http.StatusUnprocessableEntity},
+		{"bad delete mode", http.MethodDelete, "/lists/" + inbox.Id + "?mode=archive", "", http.StatusBadRequest},
+		{"delete the inbox", http.MethodDelete, "/lists/" + inbox.Id, "", http.StatusConflict},
+	}
+	for _, tt := range invalid {
+		if rr := send(tt.method, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
 }
 
-var _ Database = (*InMemoryDB)(nil)
+func TestHandler_Subtasks(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
 
-type Database interface {
-	Create(todo models.Todo) (id string, err error)
-	Get(id string) (todo models.Todo, err error)
-	GetAll() (todos []models.Todo, err error)
-	Update(id string, todo models.Todo) (count int64, err error)
-	Delete(id string) (count int64, err error)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+
+	var work models.List
+	decode(send(http.MethodPost, "/lists", `{"name":"Work"}`), &work)
+	var parent models.Todo
+	decode(send(http.MethodPost, "/lists/"+work.Id+"/todos", `{"title":"release"}`), &parent)
+	if parent.ParentId != nil || parent.Progress != nil {
+		t.Fatalf("expected a top level todo without progress, got %+v", parent)
+	}
+
+	// subtasks go to their parent's list unless told otherwise
+	var child models.Todo
+	rr := send(http.MethodPost, "/todos", `{"title":"write changelog","parentId":"1"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	decode(rr, &child)
+	if child.ParentId == nil || *child.ParentId != parent.Id || child.ListId != work.Id {
+		t.Fatalf("create returned wrong subtask: got %+v", child)
+	}
+	send(http.MethodPost, "/todos", `{"title":"tag release","parentId":"1","done":true}`)
+	send(http.MethodPost, "/todos", `{"title":"update notes","parentId":"2"}`)
+
+	decode(send(http.MethodGet, "/todos/1", ""), &parent)
+	if parent.Progress == nil || *parent.Progress != 50 || parent.Children != nil {
+		t.Fatalf("get returned wrong progress or children: got %+v", parent)
+	}
+
+	rr = send(http.MethodGet, "/todos/1?expand=children", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	decode(rr, &parent)
+	if len(parent.Children) != 2 || parent.Children[0].Id != "2" || len(parent.Children[0].Children) != 1 {
+		t.Fatalf("expand returned wrong children: got %+v", parent.Children)
+	}
+
+	// moving to the top level with a null parentId
+	decode(send(http.MethodPatch, "/todos/4", `{"parentId":null}`), &child)
+	if child.ParentId != nil {
+		t.Fatalf("patch did not move the subtask to the top level: got %v", *child.ParentId)
+	}
+
+	// deleting a todo deletes its subtasks
+	send(http.MethodDelete, "/todos/1", "")
+	if rr := send(http.MethodGet, "/todos/2", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// a chain of todos as deep as allowed
+	send(http.MethodPost, "/todos", `{"title":"level 1"}`)
+	for i := 2; i <= db.MaxDepth; i++ {
+		rr := send(http.MethodPost, "/todos", fmt.Sprintf(`{"title":"level %d","parentId":"%d"}`, i, 3+i))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code for level %d: got %v want %v, %s", i, rr.Code, http.StatusCreated, rr.Body)
+		}
+	}
+	deepest := strconv.Itoa(4 + db.MaxDepth)
+
+	invalid := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"unknown parent", http.MethodPost, "/todos", `{"title":"a","parentId":"99"}`, http.StatusUnprocessableEntity},
+		{"empty parent", http.MethodPost, "/todos", `{"title":"a","parentId":""}`, http.StatusUnprocessableEntity},
+		{"own parent", http.MethodPatch, "/todos/5", `{"parentId":"5"}`, http.StatusUnprocessableEntity},
+		{"cycle", http.MethodPatch, "/todos/5", `{"parentId":"` + deepest + `"}`, http.StatusUnprocessableEntity},
+		{"too deep", http.MethodPost, "/todos", `{"title":"a","parentId":"` + deepest + `"}`, http.StatusUnprocessableEntity},
+		{"too deep subtree", http.MethodPatch, "/todos/4", `{"parentId":"` + deepest + `"}`, http.StatusUnprocessableEntity},
+		{"bad expand", http.MethodGet, "/todos/5?expand=parent", "", http.StatusBadRequest},
+	}
+	for _, tt := range invalid {
+		if rr := send(tt.method, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
+}
+
+func TestHandler_Recurrence(t *testing.T) {
+	now := time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+	database := newInMemoryDB().(*InMemoryDB)
+	database.now = func() time.Time { return now }
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+
+	var todo models.Todo
+	rr := send(http.MethodPost, "/todos", `{"title":"water plants","dueAt":"2025-03-08T10:00:00Z","recurrence":"freq=weekly;byday=sa;count=3","tags":["home"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	decode(rr, &todo)
+	if todo.Recurrence != "FREQ=WEEKLY;COUNT=3;BYDAY=SA" || todo.SeriesId != nil {
+		t.Fatalf("create returned wrong recurrence: got %q, %v", todo.Recurrence, todo.SeriesId)
+	}
+
+	// completing the todo creates the next one, a week later
+	decode(send(http.MethodPatch, "/todos/1", `{"done":true}`), &todo)
+	if todo.SeriesId == nil || *todo.SeriesId != "1" {
+		t.Fatalf("expected the todo to start a series, got %v", todo.SeriesId)
+	}
+	var next models.Todo
+	decode(send(http.MethodGet, "/todos/2", ""), &next)
+	want := time.Date(2025, time.March, 15, 10, 0, 0, 0, time.UTC)
+	if next.Done || next.DueAt == nil || !next.DueAt.Equal(want) || next.Recurrence != "FREQ=WEEKLY;COUNT=2;BYDAY=SA" ||
+		!slices.Equal(next.Tags, []string{"home"}) || next.SeriesId == nil || *next.SeriesId != "1" {
+		t.Fatalf("wrong next occurrence: got %+v", next)
+	}
+
+	// patching a done todo again doesn't repeat it twice
+	send(http.MethodPatch, "/todos/1", `{"title":"water all plants"}`)
+
+	// completing late skips the occurrences that have passed
+	now = time.Date(2025, time.March, 20, 12, 0, 0, 0, time.UTC)
+	send(http.MethodPatch, "/todos/2", `{"done":true}`)
+
+	var series handlers.TodoSeries
+	rr = send(http.MethodGet, "/todos/1/series?upcoming=2", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	decode(rr, &series)
+	if len(series.Todos) != 3 || series.Todos[2].Id != "3" || !series.Todos[2].DueAt.Equal(want.AddDate(0, 0, 7)) {
+		t.Fatalf("series returned wrong todos: got %+v", series.Todos)
+	}
+	if series.Recurrence != "FREQ=WEEKLY;COUNT=1;BYDAY=SA" || len(series.Upcoming) != 0 {
+		t.Fatalf("series returned wrong recurrence: got %q, %v", series.Recurrence, series.Upcoming)
+	}
+
+	// the last occurrence doesn't repeat
+	send(http.MethodPatch, "/todos/3", `{"done":true}`)
+	if rr := send(http.MethodGet, "/todos/4", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected the series to have ended, got %v", rr.Code)
+	}
+
+	send(http.MethodPost, "/todos", `{"title":"stand-up","dueAt":"2025-03-20T09:00:00Z","recurrence":"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR"}`)
+	decode(send(http.MethodGet, "/todos/4/series", ""), &series)
+	if len(series.Todos) != 1 || len(series.Upcoming) != handlers.DefaultUpcoming || series.Upcoming[1].Weekday() != time.Monday {
+		t.Fatalf("series returned wrong upcoming occurrences: got %v", series.Upcoming)
+	}
+
+	invalid := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"bad rule", http.MethodPost, "/todos", `{"title":"a","recurrence":"FREQ=SECONDLY"}`, http.StatusUnprocessableEntity},
+		{"bad patch", http.MethodPatch, "/todos/4", `{"recurrence":"FREQ=DAILY;COUNT=1;UNTIL=20250101"}`, http.StatusUnprocessableEntity},
+		{"bad upcoming", http.MethodGet, "/todos/4/series?upcoming=100", "", http.StatusBadRequest},
+		{"unknown todo", http.MethodGet, "/todos/99/series", "", http.StatusNotFound},
+	}
+	for _, tt := range invalid {
+		if rr := send(tt.method, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
+}
+
+func TestHandler_Ordering(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	order := func() []string {
+		var todos []models.Todo
+		rr := send(http.MethodGet, "/todos?sort=position", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+		var titles []string
+		for _, todo := range todos {
+			titles = append(titles, todo.Title)
+		}
+		return titles
+	}
+
+	for _, title := range []string{"a", "b", "c", "d"} {
+		send(http.MethodPost, "/todos", `{"title":"`+title+`"}`)
+	}
+	if got := order(); !slices.Equal(got, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("new todos should go last: got %v", got)
+	}
+
+	rr := send(http.MethodPost, "/todos/4/move", `{"before":"1"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var moved models.Todo
+	if err := json.NewDecoder(rr.Body).Decode(&moved); err != nil {
+		t.Fatalf("failed to decode response, %v", err)
+	}
+	if moved.Id != "4" || moved.Position == "" {
+		t.Fatalf("move returned wrong todo: got %+v", moved)
+	}
+	send(http.MethodPost, "/todos/1/move", `{"after":"2"}`)
+	send(http.MethodPost, "/todos/3/move", `{"after":"4"}`)
+	if got := order(); !slices.Equal(got, []string{"d", "c", "b", "a"}) {
+		t.Fatalf("wrong order after moving: got %v", got)
+	}
+
+	// the position can only be changed by moving
+	if rr := send(http.MethodPatch, "/todos/1", `{"position":"0"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if got := order(); got[0] != "d" {
+		t.Fatalf("patch changed the order: got %v", got)
+	}
+
+	var other models.List
+	if err := json.NewDecoder(send(http.MethodPost, "/lists", `{"name":"Other"}`).Body).Decode(&other); err != nil {
+		t.Fatalf("failed to decode response, %v", err)
+	}
+	send(http.MethodPost, "/lists/"+other.Id+"/todos", `{"title":"e"}`)
+
+	invalid := []struct {
+		name, path, body string
+		status           int
+	}{
+		{"missing todo", "/todos/99/move", `{"before":"1"}`, http.StatusNotFound},
+		{"missing sibling", "/todos/1/move", `{"before":"99"}`, http.StatusUnprocessableEntity},
+		{"itself", "/todos/1/move", `{"after":"1"}`, http.StatusUnprocessableEntity},
+		{"other list", "/todos/1/move", `{"after":"5"}`, http.StatusUnprocessableEntity},
+		{"neither", "/todos/1/move", `{}`, http.StatusUnprocessableEntity},
+		{"both", "/todos/1/move", `{"before":"2","after":"3"}`, http.StatusUnprocessableEntity},
+		{"unknown field", "/todos/1/move", `{"first":true}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range invalid {
+		if rr := send(http.MethodPost, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
+}
+
+func TestHandler_Trash(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	ids := func(path string) []string {
+		rr := send(http.MethodGet, path, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var todos []models.Todo
+		if err := json.NewDecoder(rr.Body).Decode(&todos); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+		ids := []string{}
+		for _, todo := range todos {
+			ids = append(ids, todo.Id)
+		}
+		return ids
+	}
+
+	send(http.MethodPost, "/todos", `{"title":"release"}`)
+	send(http.MethodPost, "/todos", `{"title":"write changelog","parentId":"1"}`)
+	send(http.MethodPost, "/todos", `{"title":"tag release","parentId":"1"}`)
+	send(http.MethodPost, "/todos", `{"title":"water plants"}`)
+
+	// a subtask deleted on its own stays in the trash when its parent is
+	// restored
+	if rr := send(http.MethodDelete, "/todos/3", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	send(http.MethodDelete, "/todos/1", "")
+	if got := ids("/todos"); !slices.Equal(got, []string{"4"}) {
+		t.Fatalf("deleted todos should not be listed: got %v", got)
+	}
+	if got := ids("/todos/trash"); !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Fatalf("trash returned wrong todos: got %v", got)
+	}
+	if rr := send(http.MethodGet, "/todos/1", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := send(http.MethodPost, "/todos/2/restore", ""); rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	rr := send(http.MethodPost, "/todos/1/restore", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var restored models.Todo
+	if err := json.NewDecoder(rr.Body).Decode(&restored); err != nil {
+		t.Fatalf("failed to decode response, %v", err)
+	}
+	if restored.Id != "1" || restored.DeletedAt != nil || restored.Progress == nil || *restored.Progress != 0 {
+		t.Fatalf("restore returned wrong todo: got %+v", restored)
+	}
+	if got := ids("/todos?sort=createdAt"); !slices.Equal(got, []string{"1", "2", "4"}) {
+		t.Fatalf("restored todos should be listed: got %v", got)
+	}
+	if got := ids("/todos/trash"); !slices.Equal(got, []string{"3"}) {
+		t.Fatalf("trash returned wrong todos: got %v", got)
+	}
+
+	if rr := send(http.MethodDelete, "/todos/trash/4", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("purging a todo outside the trash: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send(http.MethodDelete, "/todos/trash/3", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := send(http.MethodPost, "/todos/3/restore", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("purged todo was restored: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	send(http.MethodDelete, "/todos/4", "")
+	if rr := send(http.MethodDelete, "/todos/trash", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if got := ids("/todos/trash"); len(got) != 0 {
+		t.Fatalf("expected the trash to be empty: got %v", got)
+	}
+	if rr := send(http.MethodGet, "/todos/trash?colour=red", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_Archive(t *testing.T) {
+	now := time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+	database := newInMemoryDB().(*InMemoryDB)
+	database.now = func() time.Time { return now }
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+	ids := func(path string) []string {
+		var todos []models.Todo
+		decode(send(http.MethodGet, path, ""), &todos)
+		ids := []string{}
+		for _, todo := range todos {
+			ids = append(ids, todo.Id)
+		}
+		return ids
+	}
+
+	send(http.MethodPost, "/todos", `{"title":"file taxes","done":true}`)
+	now = now.AddDate(0, 0, 10)
+	send(http.MethodPost, "/todos", `{"title":"book flights","done":true}`)
+	send(http.MethodPost, "/todos", `{"title":"pack"}`)
+
+	var result handlers.ArchiveResult
+	rr := send(http.MethodPost, "/todos/archive-completed?older_than_days=7", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	decode(rr, &result)
+	if result.Archived != 1 {
+		t.Fatalf("expected only the older todo to be archived: got %d", result.Archived)
+	}
+	if got := ids("/todos"); !slices.Equal(got, []string{"2", "3"}) {
+		t.Fatalf("archived todos should not be listed: got %v", got)
+	}
+	if got := ids("/todos?archived=true"); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("archived=true returned wrong todos: got %v", got)
+	}
+	var inbox models.List
+	decode(send(http.MethodGet, "/lists/1", ""), &inbox)
+	if inbox.DoneCount != 1 || inbox.OpenCount != 1 {
+		t.Fatalf("archived todos should not be counted: got %+v", inbox)
+	}
+
+	// archived todos can still be read and changed by id
+	var todo models.Todo
+	decode(send(http.MethodPatch, "/todos/1", `{"title":"file 2024 taxes"}`), &todo)
+	if todo.ArchivedAt == nil || todo.Title != "file 2024 taxes" {
+		t.Fatalf("patch returned wrong todo: got %+v", todo)
+	}
+	if rr := send(http.MethodPatch, "/todos/1", `{"archivedAt":null}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	decode(send(http.MethodPost, "/todos/archive-completed", ""), &result)
+	if result.Archived != 1 {
+		t.Fatalf("expected the other done todo to be archived: got %d", result.Archived)
+	}
+
+	rr = send(http.MethodPost, "/todos/1/unarchive", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	decode(rr, &todo)
+	if todo.ArchivedAt != nil {
+		t.Fatalf("unarchive returned an archived todo: got %+v", todo)
+	}
+	if got := ids("/todos"); !slices.Equal(got, []string{"1", "3"}) {
+		t.Fatalf("unarchived todos should be listed: got %v", got)
+	}
+
+	invalid := []struct {
+		name, method, path string
+		status             int
+	}{
+		{"negative days", http.MethodPost, "/todos/archive-completed?older_than_days=-1", http.StatusBadRequest},
+		{"days not a number", http.MethodPost, "/todos/archive-completed?older_than_days=week", http.StatusBadRequest},
+		{"days overflowing", http.MethodPost, "/todos/archive-completed?older_than_days=106752", http.StatusBadRequest},
+		{"days too many", http.MethodPost, "/todos/archive-completed?older_than_days=36501", http.StatusBadRequest},
+		{"unknown param", http.MethodPost, "/todos/archive-completed?before=2025-01-01", http.StatusBadRequest},
+		{"bad archived", http.MethodGet, "/todos?archived=maybe", http.StatusBadRequest},
+		{"unarchive missing", http.MethodPost, "/todos/99/unarchive", http.StatusNotFound},
+	}
+	for _, tt := range invalid {
+		if rr := send(tt.method, tt.path, ""); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
+}
+
+func TestHandler_Batch(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	batch := func(body string) []int {
+		rr := send(http.MethodPost, "/todos:batch", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var response struct {
+			Results []struct {
+				Status int             `json:"status"`
+				Body   json.RawMessage `json:"body"`
+			} `json:"results"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+		var statuses []int
+		for _, result := range response.Results {
+			statuses = append(statuses, result.Status)
+			if result.Status == http.StatusNoContent && result.Body != nil {
+				t.Errorf("expected no body for a 204, got %s", result.Body)
+			}
+		}
+		return statuses
+	}
+	count := func() int {
+		var todos []models.Todo
+		if err := json.NewDecoder(send(http.MethodGet, "/todos", "").Body).Decode(&todos); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+		return len(todos)
+	}
+
+	got := batch(`{"operations":[
+		{"op":"create","body":{"title":"a"}},
+		{"op":"create","body":{"title":"b","tags":["x"]}},
+		{"op":"update","id":"1","body":{"done":true}},
+		{"op":"delete","id":"2"}
+	]}`)
+	if want := []int{201, 201, 200, 204}; !slices.Equal(got, want) {
+		t.Fatalf("batch returned wrong statuses: got %v want %v", got, want)
+	}
+	if count() != 1 {
+		t.Fatalf("expected one todo to be left, got %d", count())
+	}
+
+	// all or nothing by default, the failing operation gets its own status
+	got = batch(`{"operations":[
+		{"op":"create","body":{"title":"c"}},
+		{"op":"update","id":"99","body":{"done":true}},
+		{"op":"delete","id":"1"}
+	]}`)
+	if want := []int{424, 404, 424}; !slices.Equal(got, want) {
+		t.Fatalf("atomic batch returned wrong statuses: got %v want %v", got, want)
+	}
+	if count() != 1 {
+		t.Fatalf("expected the atomic batch to be rolled back, got %d todos", count())
+	}
+
+	got = batch(`{"atomic":false,"operations":[
+		{"op":"create","body":{"title":"c"}},
+		{"op":"update","id":"99","body":{"done":true}},
+		{"op":"update","id":"1","body":{"parentId":"1"}}
+	]}`)
+	if want := []int{201, 404, 422}; !slices.Equal(got, want) {
+		t.Fatalf("batch returned wrong statuses: got %v want %v", got, want)
+	}
+	if count() != 2 {
+		t.Fatalf("expected the successful operation to be applied, got %d todos", count())
+	}
+
+	rr := send(http.MethodPost, "/todos:batch", `{"operations":[{"op":"create","body":{"title":""}},{"op":"move","id":"1"}]}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	var problem handlers.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("failed to decode response, %v", err)
+	}
+	var fields []string
+	for _, fe := range problem.Errors {
+		fields = append(fields, fe.Field)
+	}
+	if want := []string{"operations[0].body.title", "operations[1].op"}; !slices.Equal(fields, want) {
+		t.Fatalf("wrong fields in problem: got %v want %v", fields, want)
+	}
+
+	tooMany := `{"operations":[` + strings.Repeat(`{"op":"delete","id":"1"},`, handlers.MaxBatchOperations) + `{"op":"delete","id":"1"}]}`
+	invalid := []struct {
+		name, body string
+		status     int
+	}{
+		{"no operations", `{"operations":[]}`, http.StatusUnprocessableEntity},
+		{"too many operations", tooMany, http.StatusUnprocessableEntity},
+		{"create with id", `{"operations":[{"op":"create","id":"1","body":{"title":"a"}}]}`, http.StatusUnprocessableEntity},
+		{"update without id", `{"operations":[{"op":"update","body":{"done":true}}]}`, http.StatusUnprocessableEntity},
+		{"update without body", `{"operations":[{"op":"update","id":"1"}]}`, http.StatusUnprocessableEntity},
+		{"delete with body", `{"operations":[{"op":"delete","id":"1","body":{}}]}`, http.StatusUnprocessableEntity},
+		{"body not an object", `{"operations":[{"op":"create","body":[]}]}`, http.StatusUnprocessableEntity},
+		{"unknown body field", `{"operations":[{"op":"update","id":"1","body":{"colour":"red"}}]}`, http.StatusUnprocessableEntity},
+		{"unknown field", `{"operations":[],"dryRun":true}`, http.StatusUnprocessableEntity},
+		{"not json", `operations`, http.StatusBadRequest},
+	}
+	for _, tt := range invalid {
+		if rr := send(http.MethodPost, "/todos:batch", tt.body); rr.Code != tt.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.status, rr.Body)
+		}
+	}
+}
+
+func TestHandler_ETags(t *testing.T) {
+	handler := setupRouter(handlers.NewRouteHandler(newInMemoryDB()), AuthConfig{})
+
+	send := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, status, rr.Body)
+		}
+	}
+
+	rr := send(http.MethodPost, "/todos", `{"title":"write report"}`)
+	expect(rr, http.StatusCreated)
+	created := rr.Header().Get("ETag")
+	if created != `"1"` {
+		t.Fatalf("wrong ETag for a new todo: got %q", created)
+	}
+
+	rr = send(http.MethodGet, "/todos/1", "")
+	expect(rr, http.StatusOK)
+	if etag := rr.Header().Get("ETag"); etag != created {
+		t.Fatalf("wrong ETag: got %q want %q", etag, created)
+	}
+	rr = send(http.MethodGet, "/todos/1", "", "If-None-Match", `W/"1"`)
+	expect(rr, http.StatusNotModified)
+	if rr.Body.Len() != 0 || rr.Header().Get("ETag") != created {
+		t.Fatalf("not modified response should have the ETag and no body: got %q, %s", rr.Header().Get("ETag"), rr.Body)
+	}
+
+	rr = send(http.MethodPatch, "/todos/1", `{"done":true}`, "If-Match", created)
+	expect(rr, http.StatusOK)
+	patched := rr.Header().Get("ETag")
+	if patched == created {
+		t.Fatalf("expected the ETag to change with the todo: got %q", patched)
+	}
+	expect(send(http.MethodGet, "/todos/1", "", "If-None-Match", created), http.StatusOK)
+
+	// the todo changed since it was read with the first ETag
+	stale := []struct {
+		method, body string
+		header       []string
+	}{
+		{http.MethodPatch, `{"done":false}`, nil},
+		{http.MethodPatch, `[{"op":"replace","path":"/done","value":false}]`, []string{"Content-Type", "application/json-patch+json"}},
+		{http.MethodPut, `{"title":"write report"}`, nil},
+		{http.MethodDelete, "", nil},
+	}
+	for _, tt := range stale {
+		rr := send(tt.method, "/todos/1", tt.body, append([]string{"If-Match", created}, tt.header...)...)
+		expect(rr, http.StatusPreconditionFailed)
+	}
+	var todo models.Todo
+	json.NewDecoder(send(http.MethodGet, "/todos/1", "").Body).Decode(&todo)
+	if !todo.Done || todo.Version != 2 {
+		t.Fatalf("failed preconditions should not change the todo: got %+v", todo)
+	}
+
+	// a weak ETag never matches If-Match, "*" matches any todo
+	expect(send(http.MethodPut, "/todos/1", `{"title":"write report"}`, "If-Match", "W/"+patched), http.StatusPreconditionFailed)
+	rr = send(http.MethodPut, "/todos/1", `{"title":"write report"}`, "If-Match", `"7", `+patched)
+	expect(rr, http.StatusOK)
+	expect(send(http.MethodDelete, "/todos/1", "", "If-Match", rr.Header().Get("ETag")), http.StatusNoContent)
+	expect(send(http.MethodDelete, "/todos/1", "", "If-Match", "*"), http.StatusNotFound)
+
+	// the tags and progress of a todo are part of it too
+	rr = send(http.MethodPost, "/todos", `{"title":"ship release","tags":["release"]}`)
+	expect(rr, http.StatusCreated)
+	etag := rr.Header().Get("ETag")
+	changes := []struct {
+		name       string
+		method     string
+		path, body string
+	}{
+		{"subtask added", http.MethodPost, "/todos", `{"title":"tag release","parentId":"2"}`},
+		{"subtask done", http.MethodPatch, "/todos/3", `{"done":true}`},
+		{"subtask trashed", http.MethodDelete, "/todos/3", ""},
+		{"subtask restored", http.MethodPost, "/todos/3/restore", ""},
+		{"tag renamed", http.MethodPut, "/tags/1", `{"name":"launch"}`},
+		{"tag deleted", http.MethodDelete, "/tags/1", ""},
+	}
+	for _, tt := range changes {
+		if rr := send(tt.method, tt.path, tt.body); rr.Code >= 300 {
+			t.Fatalf("%s: handler returned wrong status code: got %v, %s", tt.name, rr.Code, rr.Body)
+		}
+		rr := send(http.MethodGet, "/todos/2", "", "If-None-Match", etag)
+		expect(rr, http.StatusOK)
+		etag = rr.Header().Get("ETag")
+	}
+}
+
+func TestHandler_Users(t *testing.T) {
+	database := newInMemoryDB().(*InMemoryDB)
+	database.CreateUser(context.Background(), models.User{Name: "bob"})
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+
+	// requests without a principal act as the local user
+	send := func(user, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			req = req.WithContext(middleware.WithPrincipal(req.Context(), middleware.Principal{UserId: user, Roles: []string{middleware.RoleAdmin}}))
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+
+	var mine, theirs models.Todo
+	decode(send("", http.MethodPost, "/todos", `{"title":"mine","tags":["home"]}`), &mine)
+	decode(send("2", http.MethodPost, "/todos", `{"title":"theirs","tags":["home"]}`), &theirs)
+	if mine.OwnerId != localUserId || theirs.OwnerId != "2" || mine.ListId == theirs.ListId {
+		t.Fatalf("todos should go to their owner's inbox: got %+v and %+v", mine, theirs)
+	}
+
+	var todos []models.Todo
+	decode(send("2", http.MethodGet, "/todos", ""), &todos)
+	if len(todos) != 1 || todos[0].Id != theirs.Id {
+		t.Fatalf("expected only the user's own todos to be listed: got %+v", todos)
+	}
+	var tags []models.Tag
+	decode(send("2", http.MethodGet, "/tags", ""), &tags)
+	if len(tags) != 1 || tags[0].TodoCount != 1 {
+		t.Fatalf("expected tags to be per user: got %+v", tags)
+	}
+	var lists []models.List
+	decode(send("2", http.MethodGet, "/lists", ""), &lists)
+	if len(lists) != 1 || lists[0].Id != theirs.ListId {
+		t.Fatalf("expected only the user's own lists: got %+v", lists)
+	}
+
+	// another user's todos and lists don't exist as far as the caller knows
+	notFound := []struct {
+		name, method, path, body string
+	}{
+		{"get", http.MethodGet, "/todos/" + mine.Id, ""},
+		{"patch", http.MethodPatch, "/todos/" + mine.Id, `{"done":true}`},
+		{"put", http.MethodPut, "/todos/" + mine.Id, `{"title":"taken"}`},
+		{"delete", http.MethodDelete, "/todos/" + mine.Id, ""},
+		{"restore", http.MethodPost, "/todos/" + mine.Id + "/restore", ""},
+		{"list", http.MethodGet, "/lists/" + mine.ListId, ""},
+		{"list todos", http.MethodGet, "/lists/" + mine.ListId + "/todos", ""},
+		{"delete list", http.MethodDelete, "/lists/" + mine.ListId, ""},
+	}
+	for _, tt := range notFound {
+		if rr := send("2", tt.method, tt.path, tt.body); rr.Code != http.StatusNotFound {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, http.StatusNotFound)
+		}
+	}
+	if rr := send("", http.MethodGet, "/tags/"+tags[0].Id, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("tag: handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// nor can they be referred to
+	rr := send("2", http.MethodPost, "/todos", `{"title":"sneaky","parentId":"`+mine.Id+`"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	rr = send("2", http.MethodPatch, "/todos/"+theirs.Id, `{"listId":"`+mine.ListId+`"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	var todo models.Todo
+	decode(send("", http.MethodGet, "/todos/"+mine.Id, ""), &todo)
+	if todo.Done || todo.Title != "mine" || todo.DeletedAt != nil {
+		t.Fatalf("another user should not have changed the todo: got %+v", todo)
+	}
+}
+
+func TestHandler_Auth(t *testing.T) {
+	database := newInMemoryDB().(*InMemoryDB)
+	now := time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+	database.now = func() time.Time { return now }
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{Enabled: true, PublicHealth: true})
+
+	send := func(key, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+	expect := func(rr *httptest.ResponseRecorder, status int) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, status, rr.Body)
+		}
+	}
+
+	_, admin, err := database.MintKey(context.Background(), models.APIKey{UserId: localUserId, Name: "bootstrap", Scopes: []string{"admin"}})
+	if err != nil {
+		t.Fatalf("failed to mint key, %v", err)
+	}
+
+	expect(send("", http.MethodGet, "/", ""), http.StatusOK)
+	expect(send("", http.MethodGet, "/metrics", ""), http.StatusUnauthorized)
+	rr := send("", http.MethodGet, "/todos", "")
+	expect(rr, http.StatusUnauthorized)
+	if rr.Header().Get("WWW-Authenticate") == "" || rr.Header().Get("Content-Type") != handlers.ProblemContentType {
+		t.Fatalf("expected a problem asking for a bearer token, got headers %v", rr.Header())
+	}
+	expect(send("todo_unknown", http.MethodGet, "/todos", ""), http.StatusUnauthorized)
+	expect(send(admin, http.MethodGet, "/todos", ""), http.StatusOK)
+
+	var user models.User
+	rr = send(admin, http.MethodPost, "/admin/users", `{"name":"ci"}`)
+	expect(rr, http.StatusCreated)
+	decode(rr, &user)
+
+	var minted handlers.MintedAPIKey
+	rr = send(admin, http.MethodPost, "/admin/keys", `{"userId":"`+user.Id+`","name":"ci","scopes":["read"],"expiresAt":"2025-03-06T12:00:00Z"}`)
+	expect(rr, http.StatusCreated)
+	decode(rr, &minted)
+	if minted.Secret == "" || !strings.HasPrefix(minted.Secret, minted.Prefix) || minted.UserId != user.Id {
+		t.Fatalf("mint returned wrong key: got %+v", minted)
+	}
+
+	// a read key can read the user's own todos, but not change them or
+	// call admin routes
+	expect(send(minted.Secret, http.MethodGet, "/todos", ""), http.StatusOK)
+	expect(send(minted.Secret, http.MethodPost, "/todos", `{"title":"nope"}`), http.StatusForbidden)
+	expect(send(minted.Secret, http.MethodGet, "/admin/keys", ""), http.StatusForbidden)
+
+	var keys []models.APIKey
+	rr = send(admin, http.MethodGet, "/admin/keys?user_id="+user.Id, "")
+	expect(rr, http.StatusOK)
+	decode(rr, &keys)
+	if len(keys) != 1 || keys[0].Id != minted.Id || keys[0].LastUsedAt == nil {
+		t.Fatalf("expected the key with its last use: got %+v", keys)
+	}
+
+	now = now.AddDate(0, 0, 2)
+	expect(send(minted.Secret, http.MethodGet, "/todos", ""), http.StatusUnauthorized)
+
+	expect(send(admin, http.MethodDelete, "/admin/keys/"+minted.Id, ""), http.StatusNoContent)
+	expect(send(admin, http.MethodDelete, "/admin/keys/99", ""), http.StatusNotFound)
+	decode(send(admin, http.MethodGet, "/admin/keys?user_id="+localUserId, ""), &keys)
+	expect(send(admin, http.MethodDelete, "/admin/keys/"+keys[0].Id, ""), http.StatusNoContent)
+	expect(send(admin, http.MethodGet, "/todos", ""), http.StatusUnauthorized)
+
+	invalid := []struct {
+		name, path, body string
+	}{
+		{"no scopes", "/admin/keys", `{"userId":"1","name":"ci","scopes":[]}`},
+		{"unknown scope", "/admin/keys", `{"userId":"1","name":"ci","scopes":["root"]}`},
+		{"unknown user", "/admin/keys", `{"userId":"42","name":"ci","scopes":["read"]}`},
+		{"expired", "/admin/keys", `{"userId":"1","name":"ci","scopes":["read"],"expiresAt":"2020-01-01T00:00:00Z"}`},
+		{"unknown field", "/admin/keys", `{"userId":"1","name":"ci","scopes":["read"],"secret":"mine"}`},
+		{"empty user name", "/admin/users", `{"name":" "}`},
+		{"unknown role", "/admin/users", `{"name":"root","role":"owner"}`},
+	}
+	unauthenticated := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+	for _, tt := range invalid {
+		rr := httptest.NewRecorder()
+		unauthenticated.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, http.StatusUnprocessableEntity, rr.Body)
+		}
+	}
+}
+
+func TestHandler_JWT(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key, %v", err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "OKP", "crv": "Ed25519", "kid": "1", "x": base64.RawURLEncoding.EncodeToString(pub)},
+		}})
+	}))
+	defer jwks.Close()
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": "1"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, []byte(signed)))
+	}
+	token := func(sub, scope string) string {
+		return sign(map[string]any{
+			"iss": "https://id.example.com/", "aud": "todos", "sub": sub, "scope": scope,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+	}
+
+	database := newInMemoryDB().(*InMemoryDB)
+	alice, err := database.CreateUser(context.Background(), models.User{Name: "alice", Subject: "auth0|alice"})
+	if err != nil {
+		t.Fatalf("failed to create user, %v", err)
+	}
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{
+		Enabled: true,
+		JWT:     JWTConfig{JWKS: jwks.URL, Issuer: "https://id.example.com/", Audience: "todos", KeysTTL: time.Hour},
+	})
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(token("auth0|alice", "read write"), http.MethodPost, "/todos", `{"title":"from a JWT"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	var todo models.Todo
+	if err := json.NewDecoder(rr.Body).Decode(&todo); err != nil {
+		t.Fatalf("failed to decode response, %v", err)
+	}
+	if todo.OwnerId != alice.Id {
+		t.Fatalf("expected the todo to belong to the token's user, got: %+v", todo)
+	}
+
+	// API keys are still accepted next to JWTs
+	_, secret, err := database.MintKey(context.Background(), models.APIKey{UserId: alice.Id, Name: "ci", Scopes: []string{"read"}})
+	if err != nil {
+		t.Fatalf("failed to mint key, %v", err)
+	}
+
+	tests := []struct {
+		name, token, method string
+		want                int
+	}{
+		{"api key", secret, http.MethodGet, http.StatusOK},
+		{"read scope", token("auth0|alice", "openid read"), http.MethodGet, http.StatusOK},
+		{"no scope", token("auth0|alice", "openid profile"), http.MethodGet, http.StatusForbidden},
+		{"read scope writing", token("auth0|alice", "read"), http.MethodPost, http.StatusForbidden},
+		{"unknown subject", token("auth0|mallory", "read write"), http.MethodGet, http.StatusUnauthorized},
+		{"expired", sign(map[string]any{"iss": "https://id.example.com/", "aud": "todos", "sub": "auth0|alice", "scope": "read", "exp": time.Now().Add(-time.Hour).Unix()}), http.MethodGet, http.StatusUnauthorized},
+		{"other audience", sign(map[string]any{"iss": "https://id.example.com/", "aud": "billing", "sub": "auth0|alice", "scope": "read", "exp": time.Now().Add(time.Hour).Unix()}), http.MethodGet, http.StatusUnauthorized},
+		{"garbage", "not.a.token", http.MethodGet, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		body := ""
+		if tt.method == http.MethodPost {
+			body = `{"title":"nope"}`
+		}
+		rr := send(tt.token, tt.method, "/todos", body)
+		if rr.Code != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v, %s", tt.name, rr.Code, tt.want, rr.Body)
+		}
+		if tt.want == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate header", tt.name)
+		}
+	}
+
+	if err := (JWTConfig{JWKS: jwks.URL}).validate(); err == nil {
+		t.Errorf("expected a key set without issuer and audience to be rejected")
+	}
+}
+
+func TestHandler_Roles(t *testing.T) {
+	database := newInMemoryDB().(*InMemoryDB)
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{Enabled: true, PublicHealth: true, PublicMetrics: true})
+
+	secrets := map[string]string{}
+	for _, role := range models.UserRoles {
+		user, err := database.CreateUser(context.Background(), models.User{Name: role, Role: role})
+		if err != nil {
+			t.Fatalf("failed to create user, %v", err)
+		}
+		_, secrets[role], err = database.MintKey(context.Background(), models.APIKey{UserId: user.Id, Name: role, Scopes: []string{"admin"}})
+		if err != nil {
+			t.Fatalf("failed to mint key, %v", err)
+		}
+	}
+	send := func(role, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+secrets[role])
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		role, method, path, body string
+		want                     int
+	}{
+		{"viewer", http.MethodGet, "/todos", "", http.StatusOK},
+		{"viewer", http.MethodGet, "/lists", "", http.StatusOK},
+		{"viewer", http.MethodPost, "/todos", `{"title":"nope"}`, http.StatusForbidden},
+		{"viewer", http.MethodPost, "/tags", `{"name":"nope"}`, http.StatusForbidden},
+		{"editor", http.MethodPost, "/todos", `{"title":"write"}`, http.StatusCreated},
+		{"editor", http.MethodDelete, "/todos/1", "", http.StatusNoContent},
+		{"editor", http.MethodDelete, "/todos/trash", "", http.StatusForbidden},
+		{"editor", http.MethodDelete, "/todos/trash/1", "", http.StatusForbidden},
+		{"editor", http.MethodGet, "/admin/keys", "", http.StatusForbidden},
+		{"admin", http.MethodPost, "/todos", `{"title":"purge"}`, http.StatusCreated},
+		{"admin", http.MethodDelete, "/todos/2", "", http.StatusNoContent},
+		{"admin", http.MethodDelete, "/todos/trash/2", "", http.StatusNoContent},
+		{"admin", http.MethodGet, "/admin/keys", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := send(tt.role, tt.method, tt.path, tt.body)
+		if rr.Code != tt.want {
+			t.Errorf("%s %s %s: handler returned wrong status code: got %v want %v, %s", tt.role, tt.method, tt.path, rr.Code, tt.want, rr.Body)
+		}
+		if rr.Code == http.StatusForbidden && rr.Header().Get("Content-Type") != handlers.ProblemContentType {
+			t.Errorf("%s %s %s: expected a problem response, got %q", tt.role, tt.method, tt.path, rr.Header().Get("Content-Type"))
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, metric := range []string{
+		`authz_decisions_total{decision="allowed",permission="purge"}`,
+		`authz_decisions_total{decision="denied",permission="purge"}`,
+		`authz_decisions_total{decision="denied",permission="write"}`,
+	} {
+		if !strings.Contains(rr.Body.String(), metric) {
+			t.Errorf("expected metric %s to be exposed", metric)
+		}
+	}
+
+	// every route but the public ones requires a permission, so a principal
+	// without roles is turned away from all of them
+	none := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+	checked := 0
+	err := handler.(*mux.Router).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path == "/" || path == "/metrics" || path == "/admin" {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		for _, method := range methods {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(method, strings.ReplaceAll(path, "{id}", "1"), nil)
+			req = req.WithContext(middleware.WithPrincipal(req.Context(), middleware.Principal{UserId: localUserId}))
+			none.ServeHTTP(rr, req)
+			checked++
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s %s: expected a principal without roles to be forbidden, got %v", method, path, rr.Code)
+			}
+		}
+		return nil
+	})
+	if err != nil || checked < 30 {
+		t.Fatalf("failed to walk routes, checked %d, %v", checked, err)
+	}
+}
+
+func TestHandler_Sharing(t *testing.T) {
+	database := newInMemoryDB().(*InMemoryDB)
+	database.CreateUser(context.Background(), models.User{Name: "bob"})
+	database.CreateUser(context.Background(), models.User{Name: "carol"})
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+
+	send := func(user, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), middleware.Principal{UserId: user, Roles: []string{middleware.RoleEditor}}))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+	expect := func(rr *httptest.ResponseRecorder, want int) {
+		t.Helper()
+		if rr.Code != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, want, rr.Body)
+		}
+	}
+
+	var list models.List
+	decode(send(localUserId, http.MethodPost, "/lists", `{"name":"Team"}`), &list)
+	var todo models.Todo
+	decode(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/todos", `{"title":"plan","tags":["work"]}`), &todo)
+	expect(send("2", http.MethodGet, "/lists/"+list.Id, ""), http.StatusNotFound)
+	expect(send("2", http.MethodGet, "/todos/"+todo.Id, ""), http.StatusNotFound)
+
+	// only the owner invites, and the inbox is never shared
+	expect(send("2", http.MethodPost, "/lists/"+list.Id+"/invitations", `{"role":"viewer"}`), http.StatusNotFound)
+	expect(send(localUserId, http.MethodPost, "/lists/"+database.lists[0].Id+"/invitations", `{"role":"viewer"}`), http.StatusConflict)
+	expect(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/invitations", `{"role":"owner"}`), http.StatusUnprocessableEntity)
+
+	rr := send(localUserId, http.MethodPost, "/lists/"+list.Id+"/invitations", `{"role":"viewer"}`)
+	expect(rr, http.StatusCreated)
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the invitation token not to be cached, got Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+	var inv handlers.CreatedInvitation
+	decode(rr, &inv)
+	if !strings.HasPrefix(inv.Token, db.InvitationPrefix) || inv.Role != "viewer" || !inv.ExpiresAt.After(time.Now()) {
+		t.Fatalf("unexpected invitation %+v", inv)
+	}
+
+	var member models.Member
+	decode(send("2", http.MethodPost, "/invitations/accept", `{"token":"`+inv.Token+`"}`), &member)
+	if member.UserId != "2" || member.Name != "bob" || member.Role != "viewer" || member.ListId != list.Id {
+		t.Fatalf("unexpected member %+v", member)
+	}
+	expect(send("3", http.MethodPost, "/invitations/accept", `{"token":"`+inv.Token+`"}`), http.StatusNotFound)
+
+	// viewers read the list and its todos but can't change them
+	var todos []models.Todo
+	decode(send("2", http.MethodGet, "/lists/"+list.Id+"/todos", ""), &todos)
+	if len(todos) != 1 || todos[0].Id != todo.Id {
+		t.Fatalf("expected the shared todos to be listed: got %+v", todos)
+	}
+	var lists []models.List
+	decode(send("2", http.MethodGet, "/lists", ""), &lists)
+	if len(lists) != 2 {
+		t.Fatalf("expected the shared list along with the inbox: got %+v", lists)
+	}
+	expect(send("2", http.MethodPatch, "/todos/"+todo.Id, `{"done":true}`), http.StatusNotFound)
+	expect(send("2", http.MethodPost, "/lists/"+list.Id+"/todos", `{"title":"nope"}`), http.StatusUnprocessableEntity)
+	expect(send("2", http.MethodPut, "/lists/"+list.Id+"/members/2", `{"role":"editor"}`), http.StatusNotFound)
+
+	// editors change them, the todos they create belong to the owner
+	decode(send(localUserId, http.MethodPut, "/lists/"+list.Id+"/members/2", `{"role":"editor"}`), &member)
+	if member.Role != "editor" {
+		t.Fatalf("expected the member to be an editor: got %+v", member)
+	}
+	expect(send("2", http.MethodPatch, "/todos/"+todo.Id, `{"done":true}`), http.StatusOK)
+	var created models.Todo
+	decode(send("2", http.MethodPost, "/lists/"+list.Id+"/todos", `{"title":"shared"}`), &created)
+	if created.OwnerId != localUserId || created.ListId != list.Id {
+		t.Fatalf("expected the todo to belong to the owner of the list: got %+v", created)
+	}
+	expect(send("2", http.MethodPut, "/lists/"+list.Id, `{"name":"Mine"}`), http.StatusNotFound)
+
+	var members []models.Member
+	decode(send("2", http.MethodGet, "/lists/"+list.Id+"/members", ""), &members)
+	if len(members) != 2 || members[0].Role != models.OwnerRole || members[0].UserId != localUserId || members[1].UserId != "2" {
+		t.Fatalf("unexpected members %+v", members)
+	}
+	expect(send("3", http.MethodGet, "/lists/"+list.Id+"/members", ""), http.StatusNotFound)
+
+	// the owner hands the list over to a member and stays on as an editor
+	expect(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/transfer", `{"userId":"3"}`), http.StatusUnprocessableEntity)
+	expect(send("2", http.MethodPost, "/lists/"+list.Id+"/transfer", `{"userId":"2"}`), http.StatusNotFound)
+	decode(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/transfer", `{"userId":"2"}`), &list)
+	if list.OwnerId != "2" {
+		t.Fatalf("expected the list to be transferred: got %+v", list)
+	}
+	decode(send("2", http.MethodGet, "/todos/"+todo.Id, ""), &todo)
+	if todo.OwnerId != "2" {
+		t.Fatalf("expected the todos to follow the list: got %+v", todo)
+	}
+	var tags []models.Tag
+	decode(send("2", http.MethodGet, "/tags", ""), &tags)
+	if len(tags) != 1 || tags[0].Name != "work" || tags[0].TodoCount != 1 {
+		t.Fatalf("expected the new owner to get the tags of the todos: got %+v", tags)
+	}
+	decode(send(localUserId, http.MethodGet, "/lists/"+list.Id+"/members", ""), &members)
+	if len(members) != 2 || members[0].UserId != "2" || members[1].UserId != localUserId || members[1].Role != "editor" {
+		t.Fatalf("unexpected members after the transfer %+v", members)
+	}
+
+	// members can leave
+	expect(send("3", http.MethodDelete, "/lists/"+list.Id+"/members/"+localUserId, ""), http.StatusNotFound)
+	expect(send(localUserId, http.MethodDelete, "/lists/"+list.Id+"/members/"+localUserId, ""), http.StatusNoContent)
+	expect(send(localUserId, http.MethodGet, "/lists/"+list.Id, ""), http.StatusNotFound)
+}
+
+// TestHandler_SharingWithViewers verifies that the role of a member in a
+// shared list decides what they may change there, not their own role.
+func TestHandler_SharingWithViewers(t *testing.T) {
+	database := newInMemoryDB().(*InMemoryDB)
+	database.CreateUser(context.Background(), models.User{Name: "bob", Role: middleware.RoleViewer})
+	handler := setupRouter(handlers.NewRouteHandler(database), AuthConfig{})
+
+	roles := map[string]string{localUserId: middleware.RoleEditor, "2": middleware.RoleViewer}
+	send := func(user, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(middleware.WithPrincipal(req.Context(), middleware.Principal{UserId: user, Roles: []string{roles[user]}}))
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v any) {
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response, %v", err)
+		}
+	}
+	expect := func(rr *httptest.ResponseRecorder, want int) {
+		t.Helper()
+		if rr.Code != want {
+			t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, want, rr.Body)
+		}
+	}
+
+	var list models.List
+	decode(send(localUserId, http.MethodPost, "/lists", `{"name":"Team"}`), &list)
+	var todo models.Todo
+	decode(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/todos", `{"title":"plan"}`), &todo)
+	var inv handlers.CreatedInvitation
+	decode(send(localUserId, http.MethodPost, "/lists/"+list.Id+"/invitations", `{"role":"editor"}`), &inv)
+
+	rr := send("2", http.MethodPost, "/invitations/accept", `{"token":"`+inv.Token+`"}`)
+	expect(rr, http.StatusOK)
+	var member models.Member
+	decode(rr, &member)
+	if member.UserId != "2" || member.Role != "editor" {
+		t.Fatalf("unexpected member %+v", member)
+	}
+
+	// the viewer edits the shared list's todos
+	expect(send("2", http.MethodPatch, "/todos/"+todo.Id, `{"done":true}`), http.StatusOK)
+	expect(send("2", http.MethodPost, "/lists/"+list.Id+"/todos", `{"title":"shared"}`), http.StatusCreated)
+	expect(send("2", http.MethodDelete, "/todos/"+todo.Id, ""), http.StatusNoContent)
+	expect(send("2", http.MethodPost, "/todos/"+todo.Id+"/restore", ""), http.StatusOK)
+
+	// but not their own todos, nor the list itself
+	asBob := middleware.WithPrincipal(context.Background(), middleware.Principal{UserId: "2"})
+	own, err := database.Create(asBob, models.Todo{Title: "mine"})
+	if err != nil {
+		t.Fatalf("failed to create todo, %v", err)
+	}
+	expect(send("2", http.MethodGet, "/todos/"+own.Id, ""), http.StatusOK)
+	expect(send("2", http.MethodPatch, "/todos/"+own.Id, `{"done":true}`), http.StatusNotFound)
+	expect(send("2", http.MethodPatch, "/todos/"+todo.Id, `{"listId":"`+own.ListId+`"}`), http.StatusUnprocessableEntity)
+	expect(send("2", http.MethodPost, "/todos", `{"title":"nope"}`), http.StatusForbidden)
+	expect(send("2", http.MethodPut, "/lists/"+list.Id, `{"name":"Mine"}`), http.StatusForbidden)
+
+	// nor unarchive them
+	database.UpdateFunc(asBob, own.Id, func(todo models.Todo) (models.Todo, error) { todo.Done = true; return todo, nil })
+	if count, err := database.ArchiveCompleted(asBob, 0); err != nil || count != 1 {
+		t.Fatalf("failed to archive todo, %d, %v", count, err)
+	}
+	expect(send("2", http.MethodPost, "/todos/"+own.Id+"/unarchive", ""), http.StatusNotFound)
+	var archived models.Todo
+	decode(send("2", http.MethodGet, "/todos/"+own.Id, ""), &archived)
+	if archived.ArchivedAt == nil {
+		t.Fatalf("expected the todo to stay archived: got %+v", archived)
+	}
 }
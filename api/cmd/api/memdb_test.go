@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/handlers"
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+)
+
+type InMemoryDB struct {
+	todos  []models.Todo
+	trash  []models.Todo
+	id     int
+	tags   []models.Tag
+	tagID  int
+	lists  []models.List
+	listID int
+	users  []models.User
+	keys   []memKey
+	// members are the users lists are shared with, in the order they joined.
+	members     []models.Member
+	invitations []memInvitation
+	now         func() time.Time
+}
+
+// memKey is an API key along with its secret, which the database only keeps
+// a hash of.
+type memKey struct {
+	models.APIKey
+	secret string
+}
+
+// memInvitation is an invitation along with its token.
+type memInvitation struct {
+	models.Invitation
+	token string
+}
+
+func newInMemoryDB() handlers.Database {
+	return &InMemoryDB{
+		todos:  []models.Todo{},
+		id:     0,
+		lists:  []models.List{{Id: "1", OwnerId: localUserId, Name: "Inbox", Inbox: true, CreatedAt: time.Now()}},
+		listID: 1,
+		users:  []models.User{{Id: localUserId, Name: "local", Role: "admin", CreatedAt: time.Now()}},
+		now:    time.Now,
+	}
+}
+
+// owner mimics the owner scope of the database, it returns the caller's user,
+// empty for the system principal, which sees the rows of every user, and
+// nobody without a principal.
+func owner(ctx context.Context) string {
+	p, ok := middleware.PrincipalFromContext(ctx)
+	switch {
+	case !ok:
+		return nobody
+	case p.System:
+		return ""
+	default:
+		return p.UserId
+	}
+}
+
+// nobody is the owner of callers without a principal, no row belongs to it.
+const nobody = "0"
+
+// owns reports whether the caller may see a row owned by ownerId.
+func owns(ctx context.Context, ownerId string) bool {
+	user := owner(ctx)
+	return user == "" || user == ownerId
+}
+
+// shares mimics the membership scope of the database, it reports whether the
+// caller may see the rows of the list with listId owned by ownerId, or change
+// them if write is set. Callers limited to shared writes can't change their
+// own.
+func (m *InMemoryDB) shares(ctx context.Context, listId, ownerId string, write bool) bool {
+	user := owner(ctx)
+	owned := owns(ctx, ownerId) && (!write || !middleware.SharedWritesOnly(ctx))
+	return owned || slices.ContainsFunc(m.members, func(member models.Member) bool {
+		return member.ListId == listId && member.UserId == user && (!write || member.Role == "editor")
+	})
+}
+
+// ownerOrDefault is the owner of a new list or tag, the first user for the
+// system principal.
+func ownerOrDefault(ctx context.Context) string {
+	if user := owner(ctx); user != "" {
+		return user
+	}
+	return localUserId
+}
+
+// CreateUser implements handlers.Database.
+func (m *InMemoryDB) CreateUser(ctx context.Context, user models.User) (created models.User, err error) {
+	if slices.ContainsFunc(m.users, func(u models.User) bool {
+		return u.Name == user.Name || (user.Subject != "" && u.Subject == user.Subject)
+	}) {
+		return models.User{}, db.ErrConflict
+	}
+	if user.Role == "" {
+		user.Role = models.DefaultUserRole
+	}
+	created = models.User{Id: strconv.Itoa(len(m.users) + 1), Name: user.Name, Subject: user.Subject, Role: user.Role, CreatedAt: m.now()}
+	m.users = append(m.users, created)
+	m.listID++
+	m.lists = append(m.lists, models.List{Id: strconv.Itoa(m.listID), OwnerId: created.Id, Name: "Inbox", Inbox: true, CreatedAt: m.now()})
+	return created, nil
+}
+
+// UserBySubject implements handlers.Database.
+func (m *InMemoryDB) UserBySubject(ctx context.Context, subject string) (models.User, error) {
+	for _, user := range m.users {
+		if subject != "" && user.Subject == subject {
+			return user, nil
+		}
+	}
+	return models.User{}, db.ErrNotFound
+}
+
+// MintKey implements handlers.Database.
+func (m *InMemoryDB) MintKey(ctx context.Context, key models.APIKey) (minted models.APIKey, secret string, err error) {
+	var v models.ValidationError
+	if !slices.ContainsFunc(m.users, func(user models.User) bool { return user.Id == key.UserId }) {
+		v.Add("userId", "does not exist")
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(m.now()) {
+		v.Add("expiresAt", "must be in the future")
+	}
+	if err := v.Err(); err != nil {
+		return models.APIKey{}, "", err
+	}
+	secret = fmt.Sprintf("%ssecret%d", db.KeyPrefix, len(m.keys)+1)
+	minted = key
+	minted.Id, minted.Prefix, minted.CreatedAt = strconv.Itoa(len(m.keys)+1), secret[:len(db.KeyPrefix)+4], m.now()
+	m.keys = append(m.keys, memKey{APIKey: minted, secret: secret})
+	return minted, secret, nil
+}
+
+// ListKeys implements handlers.Database.
+func (m *InMemoryDB) ListKeys(ctx context.Context, userId string) (keys []models.APIKey, err error) {
+	keys = []models.APIKey{}
+	for _, key := range slices.Backward(m.keys) {
+		if userId == "" || key.UserId == userId {
+			keys = append(keys, key.APIKey)
+		}
+	}
+	return keys, nil
+}
+
+// RevokeKey implements handlers.Database.
+func (m *InMemoryDB) RevokeKey(ctx context.Context, id string) error {
+	i := slices.IndexFunc(m.keys, func(key memKey) bool { return key.Id == id })
+	if i < 0 {
+		return db.ErrNotFound
+	}
+	if m.keys[i].RevokedAt == nil {
+		now := m.now()
+		m.keys[i].RevokedAt = &now
+	}
+	return nil
+}
+
+// Authenticate implements handlers.Database.
+func (m *InMemoryDB) Authenticate(ctx context.Context, secret string) (p middleware.Principal, err error) {
+	now := m.now()
+	for i, key := range m.keys {
+		if key.secret == secret && key.RevokedAt == nil && (key.ExpiresAt == nil || key.ExpiresAt.After(now)) {
+			m.keys[i].LastUsedAt = &now
+			user := m.users[slices.IndexFunc(m.users, func(user models.User) bool { return user.Id == key.UserId })]
+			return middleware.Principal{UserId: key.UserId, Roles: []string{user.Role}, Scopes: key.Scopes}, nil
+		}
+	}
+	return middleware.Principal{}, db.ErrNotFound
+}
+
+// inbox returns the caller's inbox.
+func (m *InMemoryDB) inbox(ctx context.Context) models.List {
+	i := slices.IndexFunc(m.lists, func(list models.List) bool { return list.Inbox && owns(ctx, list.OwnerId) })
+	return m.lists[i]
+}
+
+// Create implements handlers.Database.
+func (m *InMemoryDB) Create(ctx context.Context, todo models.Todo) (created models.Todo, err error) {
+	listId := todo.ListId
+	if listId == "" && todo.ParentId != nil {
+		parent, _ := m.Get(ctx, *todo.ParentId)
+		listId = parent.ListId
+	}
+	if listId == "" {
+		listId = m.inbox(ctx).Id
+	}
+	if err := m.checkList(ctx, listId, ""); err != nil {
+		return models.Todo{}, err
+	}
+	if err := m.checkParent(ctx, "", todo.ParentId); err != nil {
+		return models.Todo{}, err
+	}
+	m.id++
+	now := m.now()
+	list, _ := m.GetList(ctx, listId)
+	created = m.write(models.Todo{
+		Id: strconv.Itoa(m.id), ListId: listId, OwnerId: list.OwnerId, SeriesId: todo.SeriesId,
+		Position: m.lastPosition(listId, todo.ParentId), CreatedAt: now,
+	}, todo, now)
+	m.todos = append(m.todos, created)
+	m.touch(created.ParentId)
+	return m.withProgress(created), nil
+}
+
+// touch bumps the version of the todo with id, if any, the way the database
+// does for a todo whose subtasks or tags change.
+func (m *InMemoryDB) touch(id *string) {
+	if i := slices.IndexFunc(m.todos, func(todo models.Todo) bool { return id != nil && todo.Id == *id }); i >= 0 {
+		m.todos[i].UpdatedAt = m.now()
+		m.todos[i].Version++
+	}
+}
+
+// write returns current with the writable fields of todo, keeping the
+// timestamps up to date the way the database trigger does.
+func (m *InMemoryDB) write(current, todo models.Todo, now time.Time) models.Todo {
+	updated := current
+	if todo.ListId != "" {
+		updated.ListId = todo.ListId
+	}
+	updated.ParentId = nil
+	if todo.ParentId != nil {
+		parentId := *todo.ParentId
+		updated.ParentId = &parentId
+	}
+	updated.Title = todo.Title
+	updated.Description = todo.Description
+	updated.Done = todo.Done
+	updated.Priority = models.PriorityFromRank(todo.Priority.Rank())
+	updated.DueAt = todo.DueAt
+	updated.Recurrence = todo.Recurrence
+	if !siblings(updated, current) {
+		updated.Position = m.lastPosition(updated.ListId, updated.ParentId)
+	}
+	updated.Tags = slices.Clone(todo.Tags)
+	if updated.Tags == nil {
+		updated.Tags = []string{}
+	}
+	for _, name := range updated.Tags {
+		if !slices.ContainsFunc(m.tags, func(tag models.Tag) bool { return tag.OwnerId == updated.OwnerId && tag.Name == name }) {
+			m.CreateTag(middleware.WithPrincipal(context.Background(), middleware.Principal{UserId: updated.OwnerId}), name)
+		}
+	}
+
+	updated.UpdatedAt = now
+	updated.Version = current.Version + 1
+	switch {
+	case !updated.Done:
+		updated.CompletedAt = nil
+	case updated.CompletedAt == nil:
+		updated.CompletedAt = &now
+	}
+	return updated
+}
+
+// Get implements handlers.Database.
+func (m *InMemoryDB) Get(ctx context.Context, id string) (todo models.Todo, err error) {
+	for _, todo := range m.todos {
+		if todo.Id == id && m.shares(ctx, todo.ListId, todo.OwnerId, false) {
+			return m.withProgress(todo), nil
+		}
+	}
+	return models.Todo{}, db.ErrNotFound
+}
+
+// withProgress fills in the todo's progress the way the database computes it.
+func (m *InMemoryDB) withProgress(todo models.Todo) models.Todo {
+	var count, done int
+	for _, subtask := range m.todos {
+		if subtask.ParentId != nil && *subtask.ParentId == todo.Id {
+			count++
+			if subtask.Done {
+				done++
+			}
+		}
+	}
+	todo.Progress = nil
+	if count > 0 {
+		progress := 100 * done / count
+		todo.Progress = &progress
+	}
+	return todo
+}
+
+// checkParent mimics the hierarchy checks of the database for the todo with
+// id, which is empty for a new todo, being moved under parentId.
+func (m *InMemoryDB) checkParent(ctx context.Context, id string, parentId *string) error {
+	if parentId == nil {
+		return nil
+	}
+	var v models.ValidationError
+	depth := 1
+	for ancestor := parentId; ancestor != nil; depth++ {
+		parent, err := m.Get(ctx, *ancestor)
+		switch {
+		case err != nil, ancestor == parentId && !m.shares(ctx, parent.ListId, parent.OwnerId, true):
+			v.Add("parentId", "does not exist")
+			return v.Err()
+		case parent.Id == id:
+			v.Add("parentId", "must not be the todo itself or one of its subtasks")
+			return v.Err()
+		}
+		ancestor = parent.ParentId
+	}
+	if depth+m.height(id)-1 > db.MaxDepth {
+		v.Add("parentId", "must not nest subtasks more than %d levels deep", db.MaxDepth)
+	}
+	return v.Err()
+}
+
+// height returns how many levels the subtree of the todo with id spans.
+func (m *InMemoryDB) height(id string) int {
+	height := 1
+	for _, todo := range m.todos {
+		if id != "" && todo.ParentId != nil && *todo.ParentId == id {
+			height = max(height, m.height(todo.Id)+1)
+		}
+	}
+	return height
+}
+
+// ArchiveCompleted implements handlers.Database.
+func (m *InMemoryDB) ArchiveCompleted(ctx context.Context, olderThan time.Duration) (count int64, err error) {
+	now := m.now()
+	for i, todo := range m.todos {
+		if owns(ctx, todo.OwnerId) && todo.Done && todo.ArchivedAt == nil && !todo.CompletedAt.After(now.Add(-olderThan)) {
+			m.todos[i].ArchivedAt = &now
+			m.todos[i].Version++
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Unarchive implements handlers.Database.
+func (m *InMemoryDB) Unarchive(ctx context.Context, id string) (todo models.Todo, err error) {
+	for i := range m.todos {
+		if m.todos[i].Id == id && m.shares(ctx, m.todos[i].ListId, m.todos[i].OwnerId, true) && m.todos[i].ArchivedAt != nil {
+			m.todos[i].ArchivedAt = nil
+			m.todos[i].Version++
+		}
+	}
+	todo, err = m.Get(ctx, id)
+	if err == nil && todo.ArchivedAt != nil {
+		return models.Todo{}, db.ErrNotFound
+	}
+	return todo, err
+}
+
+// Batch implements handlers.Database, an atomic batch that fails puts back a
+// copy of the state from before it.
+func (m *InMemoryDB) Batch(ctx context.Context, ops []db.BatchOp, atomic bool) (results []db.BatchResult, err error) {
+	before := *m
+	before.todos, before.trash = slices.Clone(m.todos), slices.Clone(m.trash)
+	before.tags, before.lists = slices.Clone(m.tags), slices.Clone(m.lists)
+
+	results = make([]db.BatchResult, len(ops))
+	for i, op := range ops {
+		var result db.BatchResult
+		switch op.Kind {
+		case db.BatchCreate:
+			result.Todo, result.Err = m.Create(ctx, op.Todo)
+		case db.BatchUpdate:
+			result.Todo, result.Err = m.UpdateFunc(ctx, op.Id, func(todo models.Todo) (models.Todo, error) {
+				return op.Patch.Apply(todo), nil
+			})
+		case db.BatchDelete:
+			_, result.Err = m.trashTodo(ctx, op.Id)
+		}
+		results[i] = result
+
+		if result.Err != nil && atomic {
+			*m = before
+			for j := range results {
+				if j != i {
+					results[j] = db.BatchResult{Err: db.ErrBatchAborted}
+				}
+			}
+			return results, nil
+		}
+	}
+	return results, nil
+}
+
+// Move implements handlers.Database.
+func (m *InMemoryDB) Move(ctx context.Context, id string, move models.TodoMove) (moved models.Todo, err error) {
+	i := slices.IndexFunc(m.todos, func(todo models.Todo) bool {
+		return todo.Id == id && m.shares(ctx, todo.ListId, todo.OwnerId, true)
+	})
+	if i < 0 {
+		return models.Todo{}, db.ErrNotFound
+	}
+	field, anchorId := "after", move.After
+	if move.Before != "" {
+		field, anchorId = "before", move.Before
+	}
+
+	var v models.ValidationError
+	anchor, err := m.Get(ctx, anchorId)
+	todo := m.todos[i]
+	switch {
+	case err != nil:
+		v.Add(field, "does not exist")
+	case anchor.Id == id:
+		v.Add(field, "must not be the todo itself")
+	case !siblings(anchor, todo):
+		v.Add(field, "must be in the same list and under the same parent")
+	}
+	if err := v.Err(); err != nil {
+		return models.Todo{}, err
+	}
+
+	sorted := slices.DeleteFunc(slices.Clone(m.todos), func(t models.Todo) bool { return t.Id == id || !siblings(t, todo) })
+	byPosition := db.Sort{{Field: "position"}}
+	slices.SortFunc(sorted, byPosition.Compare)
+	at := slices.IndexFunc(sorted, func(t models.Todo) bool { return t.Id == anchor.Id })
+	lo, hi := anchor.Position, ""
+	if at+1 < len(sorted) {
+		hi = sorted[at+1].Position
+	}
+	if field == "before" {
+		lo, hi = "", anchor.Position
+		if at > 0 {
+			lo = sorted[at-1].Position
+		}
+	}
+	m.todos[i].Position = db.KeyBetween(lo, hi)
+	m.todos[i].UpdatedAt = m.now()
+	m.todos[i].Version++
+	return m.withProgress(m.todos[i]), nil
+}
+
+// lastPosition returns the position after every todo of the list under
+// parentId, in the trash or not.
+func (m *InMemoryDB) lastPosition(listId string, parentId *string) string {
+	var last string
+	for _, t := range slices.Concat(m.todos, m.trash) {
+		if siblings(t, models.Todo{ListId: listId, ParentId: parentId}) {
+			last = max(last, t.Position)
+		}
+	}
+	return db.KeyBetween(last, "")
+}
+
+// siblings reports whether a and b are in the same list under the same
+// parent, which positions are only compared between.
+func siblings(a, b models.Todo) bool {
+	return a.ListId == b.ListId && reflect.DeepEqual(a.ParentId, b.ParentId)
+}
+
+// Series implements handlers.Database.
+func (m *InMemoryDB) Series(ctx context.Context, id string) (todos []models.Todo, err error) {
+	todo, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if todo.SeriesId == nil {
+		return []models.Todo{todo}, nil
+	}
+	for _, t := range m.todos {
+		if t.SeriesId != nil && *t.SeriesId == *todo.SeriesId && m.shares(ctx, t.ListId, t.OwnerId, false) {
+			todos = append(todos, m.withProgress(t))
+		}
+	}
+	slices.SortStableFunc(todos, func(a, b models.Todo) int {
+		return db.Sort{{Field: "dueAt"}}.Compare(a, b)
+	})
+	return todos, nil
+}
+
+// Descendants implements handlers.Database.
+func (m *InMemoryDB) Descendants(ctx context.Context, id string) (todos []models.Todo, err error) {
+	todos = []models.Todo{}
+	for _, todo := range m.todos {
+		if todo.ParentId != nil && *todo.ParentId == id && m.shares(ctx, todo.ListId, todo.OwnerId, false) {
+			todos = append(todos, m.withProgress(todo))
+			children, _ := m.Descendants(ctx, todo.Id)
+			todos = append(todos, children...)
+		}
+	}
+	return todos, nil
+}
+
+// UpdateFunc implements handlers.Database.
+func (m *InMemoryDB) UpdateFunc(ctx context.Context, id string, fn func(todo models.Todo) (models.Todo, error)) (updated models.Todo, err error) {
+	for i, t := range m.todos {
+		if t.Id == id && m.shares(ctx, t.ListId, t.OwnerId, true) {
+			todo, err := fn(t)
+			if err != nil {
+				return models.Todo{}, err
+			}
+			if err := m.checkList(ctx, todo.ListId, t.OwnerId); err != nil {
+				return models.Todo{}, err
+			}
+			if err := m.checkParent(ctx, id, todo.ParentId); err != nil {
+				return models.Todo{}, err
+			}
+			m.todos[i] = m.write(t, todo, m.now())
+			if moved := !reflect.DeepEqual(todo.ParentId, t.ParentId); moved || todo.Done != t.Done {
+				m.touch(t.ParentId)
+				if moved {
+					m.touch(todo.ParentId)
+				}
+			}
+
+			// completing a recurring todo creates the next one in its series
+			if next, ok := m.todos[i].NextOccurrence(m.now()); ok && m.todos[i].Done && !t.Done {
+				if m.todos[i].SeriesId == nil {
+					m.todos[i].SeriesId = &m.todos[i].Id
+					next.SeriesId = &m.todos[i].Id
+				}
+				m.Create(ctx, next)
+			}
+			return m.withProgress(m.todos[i]), nil
+		}
+	}
+	return models.Todo{}, db.ErrNotFound
+}
+
+// trashTodo moves the todo and its subtasks from todos to trash.
+func (m *InMemoryDB) trashTodo(ctx context.Context, id string) (count int64, err error) {
+	if !slices.ContainsFunc(m.todos, func(todo models.Todo) bool {
+		return todo.Id == id && m.shares(ctx, todo.ListId, todo.OwnerId, true)
+	}) {
+		return 0, db.ErrNotFound
+	}
+	now := m.now()
+	trashed := moveSubtree(&m.todos, id, func(todo models.Todo) bool { return true })
+	m.trash = append(m.trash, trashed...)
+	m.touch(trashed[0].ParentId)
+	for i := range m.trash {
+		if m.trash[i].DeletedAt == nil {
+			m.trash[i].DeletedAt = &now
+		}
+	}
+	return 1, nil
+}
+
+// DeleteFunc implements handlers.Database.
+func (m *InMemoryDB) DeleteFunc(ctx context.Context, id string, check func(todo models.Todo) error) (count int64, err error) {
+	todo, err := m.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if err := check(todo); err != nil {
+		return 0, err
+	}
+	return m.trashTodo(ctx, id)
+}
+
+// moveSubtree removes the todo with id and those of its subtasks that match
+// from todos, and returns them.
+func moveSubtree(todos *[]models.Todo, id string, match func(todo models.Todo) bool) []models.Todo {
+	i := slices.IndexFunc(*todos, func(todo models.Todo) bool { return todo.Id == id })
+	if i < 0 {
+		return nil
+	}
+	moved := []models.Todo{(*todos)[i]}
+	*todos = slices.Delete(*todos, i, i+1)
+	for _, subtask := range slices.Clone(*todos) {
+		if subtask.ParentId != nil && *subtask.ParentId == id && match(subtask) {
+			moved = append(moved, moveSubtree(todos, subtask.Id, match)...)
+		}
+	}
+	return moved
+}
+
+// Restore implements handlers.Database.
+func (m *InMemoryDB) Restore(ctx context.Context, id string) (restored models.Todo, err error) {
+	i := slices.IndexFunc(m.trash, func(todo models.Todo) bool {
+		return todo.Id == id && m.shares(ctx, todo.ListId, todo.OwnerId, true)
+	})
+	if i < 0 {
+		return models.Todo{}, db.ErrNotFound
+	}
+	todo := m.trash[i]
+	if todo.ParentId != nil && slices.ContainsFunc(m.trash, func(t models.Todo) bool { return t.Id == *todo.ParentId }) {
+		return models.Todo{}, fmt.Errorf("%w: %w", db.ErrConflict, db.ErrParentDeleted)
+	}
+	for _, t := range moveSubtree(&m.trash, id, func(t models.Todo) bool { return t.DeletedAt.Equal(*todo.DeletedAt) }) {
+		t.DeletedAt = nil
+		m.todos = append(m.todos, t)
+	}
+	m.touch(todo.ParentId)
+	return m.Get(ctx, id)
+}
+
+// Purge implements handlers.Database.
+func (m *InMemoryDB) Purge(ctx context.Context, id string) error {
+	if !slices.ContainsFunc(m.trash, func(todo models.Todo) bool { return todo.Id == id && owns(ctx, todo.OwnerId) }) {
+		return db.ErrNotFound
+	}
+	if moveSubtree(&m.trash, id, func(models.Todo) bool { return true }) == nil {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+// PurgeTrash implements handlers.Database.
+func (m *InMemoryDB) PurgeTrash(ctx context.Context, olderThan time.Duration) (count int64, err error) {
+	cutoff := m.now().Add(-olderThan)
+	for _, todo := range slices.Clone(m.trash) {
+		if owns(ctx, todo.OwnerId) && !todo.DeletedAt.After(cutoff) {
+			count += int64(len(moveSubtree(&m.trash, todo.Id, func(models.Todo) bool { return true })))
+		}
+	}
+	return count, nil
+}
+
+// List implements handlers.Database.
+func (m *InMemoryDB) List(ctx context.Context, opts db.ListOptions) (page db.Page, err error) {
+	todos := []models.Todo{}
+	source := m.todos
+	if opts.Filter.Trashed {
+		source = m.trash
+	}
+	for _, todo := range source {
+		if m.shares(ctx, todo.ListId, todo.OwnerId, false) && opts.Filter.Matches(todo, m.now()) {
+			todos = append(todos, m.withProgress(todo))
+		}
+	}
+	slices.SortFunc(todos, opts.Sort.Compare)
+
+	if opts.Cursor != "" {
+		cursor, err := db.DecodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, err
+		}
+		todos = slices.DeleteFunc(todos, func(todo models.Todo) bool {
+			return opts.Sort.Compare(todo, cursor.Last) <= 0
+		})
+	}
+
+	limit := opts.PageLimit()
+	if len(todos) > limit {
+		todos = todos[:limit]
+		page.NextCursor = db.CursorAfter(todos[limit-1], opts.Sort).Encode()
+	}
+	page.Todos = todos
+	return page, nil
+}
+
+// Search implements handlers.Database with a naive stand-in for Postgres
+// full-text search: every word must appear in the title, ignoring case, and
+// no word prefixed with "-" may.
+func (m *InMemoryDB) Search(ctx context.Context, opts db.SearchOptions) (results []models.SearchResult, err error) {
+	var include, exclude []string
+	for _, word := range strings.Fields(strings.ToLower(opts.Query)) {
+		word = strings.Trim(word, `"`)
+		if excluded, ok := strings.CutPrefix(word, "-"); ok {
+			exclude = append(exclude, excluded)
+		} else if word != "" && word != "or" {
+			include = append(include, word)
+		}
+	}
+
+	results = []models.SearchResult{}
+	for _, todo := range m.todos {
+		if !m.shares(ctx, todo.ListId, todo.OwnerId, false) {
+			continue
+		}
+		title := strings.ToLower(todo.Title)
+		contained := func(word string) bool { return strings.Contains(title, word) }
+		missing := func(word string) bool { return !contained(word) }
+		if len(include) == 0 || slices.ContainsFunc(include, missing) || slices.ContainsFunc(exclude, contained) {
+			continue
+		}
+
+		// the title is escaped around the marked words, like the database does
+		quoted := make([]string, len(include))
+		for i, word := range include {
+			quoted[i] = regexp.QuoteMeta(word)
+		}
+		var snippet strings.Builder
+		at := 0
+		for _, match := range regexp.MustCompile("(?i)"+strings.Join(quoted, "|")).FindAllStringIndex(todo.Title, -1) {
+			snippet.WriteString(html.EscapeString(todo.Title[at:match[0]]))
+			snippet.WriteString("<mark>" + html.EscapeString(todo.Title[match[0]:match[1]]) + "</mark>")
+			at = match[1]
+		}
+		snippet.WriteString(html.EscapeString(todo.Title[at:]))
+		results = append(results, models.SearchResult{
+			Todo:    todo,
+			Rank:    float32(len(include)) / float32(len(strings.Fields(title))),
+			Snippet: snippet.String(),
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b models.SearchResult) int {
+		return cmp.Compare(b.Rank, a.Rank)
+	})
+	if limit := opts.PageLimit(); len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// ListTags implements handlers.Database.
+func (m *InMemoryDB) ListTags(ctx context.Context) (tags []models.Tag, err error) {
+	tags = []models.Tag{}
+	for _, tag := range m.tags {
+		if owns(ctx, tag.OwnerId) {
+			tags = append(tags, m.countTodos(tag))
+		}
+	}
+	slices.SortFunc(tags, func(a, b models.Tag) int { return cmp.Compare(a.Name, b.Name) })
+	return tags, nil
+}
+
+func (m *InMemoryDB) countTodos(tag models.Tag) models.Tag {
+	tag.TodoCount = 0
+	for _, todo := range m.todos {
+		if todo.OwnerId == tag.OwnerId && slices.Contains(todo.Tags, tag.Name) {
+			tag.TodoCount++
+		}
+	}
+	return tag
+}
+
+// CreateTag implements handlers.Database.
+func (m *InMemoryDB) CreateTag(ctx context.Context, name string) (tag models.Tag, err error) {
+	ownerId := ownerOrDefault(ctx)
+	if slices.ContainsFunc(m.tags, func(tag models.Tag) bool { return tag.OwnerId == ownerId && tag.Name == name }) {
+		return models.Tag{}, db.ErrConflict
+	}
+	m.tagID++
+	tag = models.Tag{Id: strconv.Itoa(m.tagID), OwnerId: ownerId, Name: name, CreatedAt: time.Now()}
+	m.tags = append(m.tags, tag)
+	return tag, nil
+}
+
+// GetTag implements handlers.Database.
+func (m *InMemoryDB) GetTag(ctx context.Context, id string) (tag models.Tag, err error) {
+	for _, tag := range m.tags {
+		if tag.Id == id && owns(ctx, tag.OwnerId) {
+			return m.countTodos(tag), nil
+		}
+	}
+	return models.Tag{}, db.ErrNotFound
+}
+
+// RenameTag implements handlers.Database.
+func (m *InMemoryDB) RenameTag(ctx context.Context, id string, name string) (tag models.Tag, err error) {
+	i := slices.IndexFunc(m.tags, func(tag models.Tag) bool { return tag.Id == id && owns(ctx, tag.OwnerId) })
+	if i < 0 {
+		return models.Tag{}, db.ErrNotFound
+	}
+	old, ownerId := m.tags[i].Name, m.tags[i].OwnerId
+	if old != name && slices.ContainsFunc(m.tags, func(tag models.Tag) bool { return tag.OwnerId == ownerId && tag.Name == name }) {
+		return models.Tag{}, db.ErrConflict
+	}
+	m.tags[i].Name = name
+	for j, todo := range m.todos {
+		if k := slices.Index(todo.Tags, old); k >= 0 && todo.OwnerId == ownerId {
+			m.todos[j].Tags = slices.Clone(todo.Tags)
+			m.todos[j].Tags[k] = name
+			slices.Sort(m.todos[j].Tags)
+			m.touch(&todo.Id)
+		}
+	}
+	return m.countTodos(m.tags[i]), nil
+}
+
+// DeleteTag implements handlers.Database.
+func (m *InMemoryDB) DeleteTag(ctx context.Context, id string) error {
+	i := slices.IndexFunc(m.tags, func(tag models.Tag) bool { return tag.Id == id && owns(ctx, tag.OwnerId) })
+	if i < 0 {
+		return db.ErrNotFound
+	}
+	name, ownerId := m.tags[i].Name, m.tags[i].OwnerId
+	m.tags = slices.Delete(m.tags, i, i+1)
+	for j, todo := range m.todos {
+		if todo.OwnerId != ownerId {
+			continue
+		}
+		if slices.Contains(todo.Tags, name) {
+			m.todos[j].Tags = slices.DeleteFunc(slices.Clone(todo.Tags), func(tag string) bool { return tag == name })
+			m.touch(&todo.Id)
+		}
+	}
+	return nil
+}
+
+// checkList mimics the foreign key on list_id, an empty id is the inbox or
+// the current list. Other lists must be ones the caller can change and, for
+// an existing todo, belong to its owner.
+func (m *InMemoryDB) checkList(ctx context.Context, id, ownerId string) error {
+	if id == "" || slices.ContainsFunc(m.lists, func(list models.List) bool {
+		return list.Id == id && m.shares(ctx, list.Id, list.OwnerId, true) && (ownerId == "" || list.OwnerId == ownerId)
+	}) {
+		return nil
+	}
+	var v models.ValidationError
+	v.Add("listId", "does not exist")
+	return v.Err()
+}
+
+// GetLists implements handlers.Database.
+func (m *InMemoryDB) GetLists(ctx context.Context) (lists []models.List, err error) {
+	lists = []models.List{}
+	for _, list := range m.lists {
+		if m.shares(ctx, list.Id, list.OwnerId, false) {
+			lists = append(lists, m.countListTodos(list))
+		}
+	}
+	slices.SortStableFunc(lists, func(a, b models.List) int {
+		if a.Inbox != b.Inbox {
+			return boolCompare(b.Inbox, a.Inbox)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return lists, nil
+}
+
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+func (m *InMemoryDB) countListTodos(list models.List) models.List {
+	list.OpenCount, list.DoneCount = 0, 0
+	for _, todo := range m.todos {
+		switch {
+		case todo.ListId != list.Id, todo.ArchivedAt != nil:
+		case todo.Done:
+			list.DoneCount++
+		default:
+			list.OpenCount++
+		}
+	}
+	return list
+}
+
+// CreateList implements handlers.Database.
+func (m *InMemoryDB) CreateList(ctx context.Context, name string) (list models.List, err error) {
+	m.listID++
+	list = models.List{Id: strconv.Itoa(m.listID), OwnerId: ownerOrDefault(ctx), Name: name, CreatedAt: time.Now()}
+	m.lists = append(m.lists, list)
+	return list, nil
+}
+
+// GetList implements handlers.Database.
+func (m *InMemoryDB) GetList(ctx context.Context, id string) (list models.List, err error) {
+	for _, list := range m.lists {
+		if list.Id == id && m.shares(ctx, list.Id, list.OwnerId, false) {
+			return m.countListTodos(list), nil
+		}
+	}
+	return models.List{}, db.ErrNotFound
+}
+
+// RenameList implements handlers.Database.
+func (m *InMemoryDB) RenameList(ctx context.Context, id string, name string) (list models.List, err error) {
+	i := slices.IndexFunc(m.lists, func(list models.List) bool { return list.Id == id && owns(ctx, list.OwnerId) })
+	if i < 0 {
+		return models.List{}, db.ErrNotFound
+	}
+	m.lists[i].Name = name
+	return m.countListTodos(m.lists[i]), nil
+}
+
+// DeleteList implements handlers.Database.
+func (m *InMemoryDB) DeleteList(ctx context.Context, id string, cascade bool) error {
+	i := slices.IndexFunc(m.lists, func(list models.List) bool { return list.Id == id && owns(ctx, list.OwnerId) })
+	if i < 0 {
+		return db.ErrNotFound
+	}
+	if m.lists[i].Inbox {
+		return fmt.Errorf("%w: %w", db.ErrConflict, db.ErrInboxDeleted)
+	}
+	inbox := m.inbox(middleware.WithPrincipal(ctx, middleware.Principal{UserId: m.lists[i].OwnerId}))
+	m.lists = slices.Delete(m.lists, i, i+1)
+	m.members = slices.DeleteFunc(m.members, func(member models.Member) bool { return member.ListId == id })
+	if cascade {
+		now := m.now()
+		for _, todo := range slices.Clone(m.todos) {
+			if todo.ListId != id {
+				continue
+			}
+			for _, t := range moveSubtree(&m.todos, todo.Id, func(models.Todo) bool { return true }) {
+				t.DeletedAt = &now
+				m.trash = append(m.trash, t)
+			}
+		}
+	}
+	// the todos go after those in the inbox, in the same order as before
+	byPosition := db.Sort{{Field: "position"}}
+	for _, todos := range []*[]models.Todo{&m.todos, &m.trash} {
+		moved := slices.Clone(*todos)
+		slices.SortFunc(moved, byPosition.Compare)
+		for _, todo := range moved {
+			if todo.ListId == id {
+				j := slices.IndexFunc(*todos, func(t models.Todo) bool { return t.Id == todo.Id })
+				(*todos)[j].ListId, (*todos)[j].Position = inbox.Id, m.lastPosition(inbox.Id, todo.ParentId)
+			}
+		}
+	}
+	return nil
+}
+
+// ListMembers implements handlers.Database.
+func (m *InMemoryDB) ListMembers(ctx context.Context, listId string) (members []models.Member, err error) {
+	i := slices.IndexFunc(m.lists, func(list models.List) bool {
+		return list.Id == listId && m.shares(ctx, list.Id, list.OwnerId, false)
+	})
+	if i < 0 {
+		return nil, db.ErrNotFound
+	}
+	list := m.lists[i]
+	members = []models.Member{{
+		ListId: list.Id, UserId: list.OwnerId, Name: m.user(list.OwnerId).Name, Role: models.OwnerRole, CreatedAt: list.CreatedAt,
+	}}
+	for _, member := range m.members {
+		if member.ListId == listId {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+// user returns the user with id.
+func (m *InMemoryDB) user(id string) models.User {
+	return m.users[slices.IndexFunc(m.users, func(user models.User) bool { return user.Id == id })]
+}
+
+// member returns the index of the membership of the user in the list, or -1.
+func (m *InMemoryDB) member(listId, userId string) int {
+	return slices.IndexFunc(m.members, func(member models.Member) bool {
+		return member.ListId == listId && member.UserId == userId
+	})
+}
+
+// SetMemberRole implements handlers.Database.
+func (m *InMemoryDB) SetMemberRole(ctx context.Context, listId, userId, role string) (member models.Member, err error) {
+	j := m.member(listId, userId)
+	if j < 0 || !slices.ContainsFunc(m.lists, func(list models.List) bool { return list.Id == listId && owns(ctx, list.OwnerId) }) {
+		return models.Member{}, db.ErrNotFound
+	}
+	m.members[j].Role = role
+	return m.members[j], nil
+}
+
+// RemoveMember implements handlers.Database.
+func (m *InMemoryDB) RemoveMember(ctx context.Context, listId, userId string) error {
+	j := m.member(listId, userId)
+	if j < 0 || (owner(ctx) != userId &&
+		!slices.ContainsFunc(m.lists, func(list models.List) bool { return list.Id == listId && owns(ctx, list.OwnerId) })) {
+		return db.ErrNotFound
+	}
+	m.members = slices.Delete(m.members, j, j+1)
+	return nil
+}
+
+// Invite implements handlers.Database.
+func (m *InMemoryDB) Invite(ctx context.Context, listId string, inv models.Invitation) (created models.Invitation, token string, err error) {
+	now := m.now()
+	switch {
+	case inv.ExpiresAt.IsZero():
+		inv.ExpiresAt = now.Add(db.DefaultInvitationTTL)
+	case !inv.ExpiresAt.After(now):
+		var v models.ValidationError
+		v.Add("expiresAt", "must be in the future")
+		return models.Invitation{}, "", v.Err()
+	}
+	i := slices.IndexFunc(m.lists, func(list models.List) bool { return list.Id == listId && owns(ctx, list.OwnerId) })
+	if i < 0 {
+		return models.Invitation{}, "", db.ErrNotFound
+	}
+	if m.lists[i].Inbox {
+		return models.Invitation{}, "", fmt.Errorf("%w: %w", db.ErrConflict, db.ErrInboxShared)
+	}
+	token = fmt.Sprintf("%stoken%d", db.InvitationPrefix, len(m.invitations)+1)
+	created = models.Invitation{Id: strconv.Itoa(len(m.invitations) + 1), ListId: listId, Role: inv.Role, ExpiresAt: inv.ExpiresAt, CreatedAt: now}
+	m.invitations = append(m.invitations, memInvitation{Invitation: created, token: token})
+	return created, token, nil
+}
+
+// AcceptInvitation implements handlers.Database.
+func (m *InMemoryDB) AcceptInvitation(ctx context.Context, token string) (member models.Member, err error) {
+	user := owner(ctx)
+	if user == "" {
+		return models.Member{}, fmt.Errorf("%w: accepting an invitation needs a user", db.ErrInvalid)
+	}
+	now := m.now()
+	k := slices.IndexFunc(m.invitations, func(inv memInvitation) bool {
+		return inv.token == token && inv.AcceptedAt == nil && inv.ExpiresAt.After(now)
+	})
+	if k < 0 {
+		return models.Member{}, db.ErrNotFound
+	}
+	inv := m.invitations[k]
+	if list, _ := m.GetList(context.Background(), inv.ListId); list.OwnerId == user {
+		var v models.ValidationError
+		v.Add("token", "invites to a list of your own")
+		return models.Member{}, v.Err()
+	}
+	m.invitations[k].AcceptedBy, m.invitations[k].AcceptedAt = &user, &now
+
+	if j := m.member(inv.ListId, user); j >= 0 {
+		m.members[j].Role = inv.Role
+		return m.members[j], nil
+	}
+	member = models.Member{ListId: inv.ListId, UserId: user, Name: m.user(user).Name, Role: inv.Role, CreatedAt: now}
+	m.members = append(m.members, member)
+	return member, nil
+}
+
+// TransferList implements handlers.Database, the tags of the todos are
+// names, so the new owner just gets tags of those names.
+func (m *InMemoryDB) TransferList(ctx context.Context, listId, userId string) (list models.List, err error) {
+	i := slices.IndexFunc(m.lists, func(list models.List) bool { return list.Id == listId && owns(ctx, list.OwnerId) })
+	if i < 0 {
+		return models.List{}, db.ErrNotFound
+	}
+	if m.lists[i].Inbox {
+		return models.List{}, fmt.Errorf("%w: %w", db.ErrConflict, db.ErrInboxShared)
+	}
+	j := m.member(listId, userId)
+	if j < 0 {
+		var v models.ValidationError
+		v.Add("userId", "must be a member of the list")
+		return models.List{}, v.Err()
+	}
+
+	all := slices.Concat(m.todos, m.trash)
+	if slices.ContainsFunc(all, func(child models.Todo) bool {
+		if child.ParentId == nil {
+			return false
+		}
+		parent := all[slices.IndexFunc(all, func(t models.Todo) bool { return t.Id == *child.ParentId })]
+		return (child.ListId == listId) != (parent.ListId == listId)
+	}) {
+		return models.List{}, fmt.Errorf("%w: %w", db.ErrConflict, db.ErrSubtasksElsewhere)
+	}
+
+	previous := m.lists[i].OwnerId
+	m.lists[i].OwnerId = userId
+	newOwner := middleware.WithPrincipal(context.Background(), middleware.Principal{UserId: userId})
+	for _, todos := range []*[]models.Todo{&m.todos, &m.trash} {
+		for k, todo := range *todos {
+			if todo.ListId != listId {
+				continue
+			}
+			(*todos)[k].OwnerId = userId
+			for _, name := range todo.Tags {
+				m.CreateTag(newOwner, name)
+			}
+		}
+	}
+	m.members = slices.Delete(m.members, j, j+1)
+	m.members = append(m.members, models.Member{
+		ListId: listId, UserId: previous, Name: m.user(previous).Name, Role: "editor", CreatedAt: m.now(),
+	})
+	return m.countListTodos(m.lists[i]), nil
+}
+
+var _ handlers.Database = (*InMemoryDB)(nil)
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"example.com/todos/pkg/db"
+)
+
+const migrateUsage = "usage: api migrate up | down [steps] | status"
+
+// runMigrate implements the `migrate` subcommand.
+func runMigrate(ctx context.Context, cfg Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+	case "down":
+		if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	database, err := connectDB(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "up":
+		return migrateUp(ctx, database, out)
+	case "down":
+		return migrateDown(ctx, database, steps, out)
+	default:
+		return migrateStatus(ctx, database, out)
+	}
+}
+
+func migrateUp(ctx context.Context, database *db.DB, out io.Writer) error {
+	migrator, err := db.NewMigrator(database)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(ctx)
+	for _, m := range applied {
+		fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintln(out, "schema is up to date")
+	}
+	return nil
+}
+
+func migrateDown(ctx context.Context, database *db.DB, steps int, out io.Writer) error {
+	migrator, err := db.NewMigrator(database)
+	if err != nil {
+		return err
+	}
+
+	reverted, err := migrator.Down(ctx, steps)
+	for _, m := range reverted {
+		fmt.Fprintf(out, "reverted %04d_%s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(reverted) == 0 {
+		fmt.Fprintln(out, "no migrations to revert")
+	}
+	return nil
+}
+
+func migrateStatus(ctx context.Context, database *db.DB, out io.Writer) error {
+	migrator, err := db.NewMigrator(database)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range statuses {
+		status, appliedAt := "pending", "-"
+		if s.Applied {
+			status = "applied"
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		if s.Modified {
+			status = "modified"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, status, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestRunMigrate_RejectsBadArguments(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"sideways"},
+		{"up", "2"},
+		{"down", "zero"},
+		{"down", "0"},
+		{"down", "1", "2"},
+		{"status", "now"},
+	}
+
+	for _, args := range tests {
+		// arguments are validated before connecting, so an empty config is fine
+		if err := runMigrate(context.Background(), Config{}, args, io.Discard); err == nil {
+			t.Errorf("expected an error for arguments %q", args)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"example.com/todos/pkg/middleware"
+)
+
+// trashPurger is the part of the database the purger needs.
+type trashPurger interface {
+	PurgeTrash(ctx context.Context, olderThan time.Duration) (count int64, err error)
+}
+
+// runPurger permanently deletes the todos that have been in the trash for
+// longer than retention, once right away and then every interval, until the
+// context is cancelled. It acts for every user as the system principal.
+func runPurger(ctx context.Context, db trashPurger, retention, interval time.Duration) {
+	ctx = middleware.WithPrincipal(ctx, middleware.SystemPrincipal)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := db.PurgeTrash(ctx, retention)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Printf("Purging the trash failed: %v", err)
+		case count > 0:
+			log.Printf("Purged %d todos from the trash", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
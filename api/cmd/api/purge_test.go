@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+)
+
+func TestRunPurger(t *testing.T) {
+	now := time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+	database := newInMemoryDB().(*InMemoryDB)
+	database.now = func() time.Time { return now }
+
+	// the todos are the local user's, the purger reaches them as the system
+	// principal
+	ctx := middleware.WithPrincipal(context.Background(), middleware.Principal{UserId: localUserId})
+	for _, title := range []string{"old", "new"} {
+		todo, _ := database.Create(ctx, models.Todo{Title: title})
+		database.DeleteFunc(ctx, todo.Id, func(models.Todo) error { return nil })
+		now = now.Add(24 * time.Hour)
+	}
+
+	// the purger runs once before waiting, so it returns right away once
+	// the context is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	runPurger(ctx, database, 36*time.Hour, time.Hour)
+
+	if len(database.trash) != 1 || database.trash[0].Title != "new" {
+		t.Fatalf("expected only the newer todo to be left in the trash, got: %+v", database.trash)
+	}
+}
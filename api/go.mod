@@ -7,7 +7,9 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
 	github.com/jackc/pgx/v5 v5.7.6
+	github.com/jackc/puddle/v2 v2.2.2
 	github.com/prometheus/client_golang v1.23.2
+	golang.org/x/sync v0.16.0
 )
 
 require (
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"example.com/todos/pkg/models"
+)
+
+// ArchiveCompleted archives, in a single statement, every done todo that was
+// completed more than olderThan ago, or all of them if it is 0, and returns
+// how many.
+func (db *DB) ArchiveCompleted(ctx context.Context, olderThan time.Duration) (count int64, err error) {
+	now := db.now()
+	// todos completed before completed_at was tracked fall back to when they
+	// were last changed
+	commandTag, err := db.pool.Exec(ctx, `UPDATE todos SET archived_at = $1
+  WHERE done AND archived_at IS NULL AND deleted_at IS NULL AND COALESCE(completed_at, updated_at) <= $2
+    AND `+ownerScope("todos", 3),
+		now, now.Add(-olderThan), owner(ctx))
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return commandTag.RowsAffected(), nil
+}
+
+// Unarchive puts the todo with id back in the listings, and returns it. A
+// todo that isn't archived is returned as is, an archived one the caller
+// can't change, e.g. in a list shared with them as a viewer, isn't found.
+func (db *DB) Unarchive(ctx context.Context, id string) (todo models.Todo, err error) {
+	commandTag, err := db.pool.Exec(ctx,
+		"UPDATE todos SET archived_at = NULL WHERE id = $1 AND archived_at IS NOT NULL AND "+todoScope(2, writes(ctx)), id, owner(ctx))
+	if err != nil {
+		return models.Todo{}, translateError(err)
+	}
+	todo, err = db.Get(ctx, id)
+	if err == nil && commandTag.RowsAffected() == 0 && todo.ArchivedAt != nil {
+		return models.Todo{}, ErrNotFound
+	}
+	return todo, err
+}
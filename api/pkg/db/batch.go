@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrBatchAborted is the result of every operation in an atomic batch that
+// wasn't applied because another one failed.
+var ErrBatchAborted = errors.New("not applied, another operation in the batch failed")
+
+// BatchKind is what an operation in a batch does.
+type BatchKind string
+
+const (
+	BatchCreate BatchKind = "create"
+	BatchUpdate BatchKind = "update"
+	BatchDelete BatchKind = "delete"
+)
+
+// BatchOp is one operation of a batch. Todo is only used to create and Patch
+// to update, Id is the todo to update or delete.
+type BatchOp struct {
+	Kind  BatchKind
+	Id    string
+	Todo  models.Todo
+	Patch models.TodoPatch
+}
+
+// BatchResult is the outcome of a BatchOp, the created or updated todo, or
+// the error the operation failed with.
+type BatchResult struct {
+	Todo models.Todo
+	Err  error
+}
+
+// Batch runs the operations in order within a single transaction and returns
+// the result of each. If atomic is set the first failure rolls back all of
+// them, and the other operations fail with ErrBatchAborted. Otherwise each
+// operation runs in a savepoint of its own, so the ones that succeed are
+// committed regardless. The error is only set if the transaction as a whole
+// failed.
+func (db *DB) Batch(ctx context.Context, ops []BatchOp, atomic bool) (results []BatchResult, err error) {
+	results = make([]BatchResult, len(ops))
+	failed := -1
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		for i, op := range ops {
+			if atomic {
+				results[i].Todo, results[i].Err = db.runBatchOp(ctx, tx, op)
+				if results[i].Err != nil {
+					failed = i
+					return results[i].Err
+				}
+				continue
+			}
+
+			err := pgx.BeginFunc(ctx, tx, func(savepoint pgx.Tx) error {
+				results[i].Todo, results[i].Err = db.runBatchOp(ctx, savepoint, op)
+				return results[i].Err
+			})
+			// the operation's own failure is its result, anything else,
+			// e.g. a lost connection, ends the whole batch
+			if err != nil && results[i].Err == nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case failed >= 0:
+		for i := range results {
+			if i != failed {
+				results[i] = BatchResult{Err: ErrBatchAborted}
+			}
+		}
+		return results, nil
+	case err != nil:
+		return nil, translateError(err)
+	}
+	return results, nil
+}
+
+// runBatchOp runs a single operation of a batch within tx, returning
+// translated errors.
+func (db *DB) runBatchOp(ctx context.Context, tx pgx.Tx, op BatchOp) (models.Todo, error) {
+	switch op.Kind {
+	case BatchCreate:
+		created, err := db.insertTodo(ctx, tx, op.Todo)
+		return created, translateError(err)
+	case BatchUpdate:
+		return db.updateTodo(ctx, tx, op.Id, func(todo models.Todo) (models.Todo, error) {
+			return op.Patch.Apply(todo), nil
+		})
+	case BatchDelete:
+		_, err := db.trashTodo(ctx, tx, op.Id)
+		return models.Todo{}, err
+	default:
+		return models.Todo{}, fmt.Errorf("%w: unknown batch operation %q", ErrInvalid, op.Kind)
+	}
+}
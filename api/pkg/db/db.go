@@ -1,77 +1,334 @@
 package db
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"time"
 
 	"example.com/todos/pkg/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewDB(ctx context.Context, url string) *DB {
-	conn, err := pgx.Connect(ctx, url)
+// Config controls the sizing and lifetime of the connection pool. Zero values
+// fall back to the pgxpool defaults.
+type Config struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// AutoCompleteParents marks a todo done once all of its subtasks are.
+	AutoCompleteParents bool
+
+	// Now returns the current time, it defaults to time.Now.
+	Now func() time.Time
+}
+
+func NewDB(ctx context.Context, url string, cfg Config) (*DB, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("parsing database url: %w", err)
 	}
-	return &DB{
-		conn: conn,
+
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = cfg.MaxConns
+	}
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = cfg.MinConns
+	}
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating connection pool: %w", err)
+	}
+
+	// pgxpool connects lazily, so ping once to fail fast on bad credentials
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
 	}
+
+	return &DB{
+		pool:                pool,
+		autoCompleteParents: cfg.AutoCompleteParents,
+		now:                 now,
+	}, nil
 }
 
 type DB struct {
-	conn *pgx.Conn
+	pool                *pgxpool.Pool
+	autoCompleteParents bool
+	now                 func() time.Time
+}
+
+// Close waits for in-flight queries to finish and closes all connections.
+// It is safe to call more than once.
+func (db *DB) Close() {
+	db.pool.Close()
+}
+
+// todoColumns are the columns scanTodo reads, in order. They must be selected
+// from the todos table without an alias. Subtasks in the trash don't count
+// towards the progress.
+const todoColumns = "id, list_id, owner_id, parent_id, title, description, done, priority, due_at, created_at, updated_at, completed_at, version, " +
+	"ARRAY(SELECT tags.name FROM todo_tags JOIN tags ON tags.id = todo_tags.tag_id WHERE todo_tags.todo_id = todos.id ORDER BY tags.name), " +
+	"recurrence, series_id, position, archived_at, deleted_at, " +
+	"(SELECT (100 * count(*) FILTER (WHERE subtasks.done) / NULLIF(count(*), 0))::integer FROM todos subtasks " +
+	"WHERE subtasks.parent_id = todos.id AND subtasks.deleted_at IS NULL)"
+
+// scanTodo scans a row selected with todoColumns, followed by extra.
+func scanTodo(row pgx.Row, extra ...any) (todo models.Todo, err error) {
+	var priority int16
+	dest := append([]any{
+		&todo.Id, &todo.ListId, &todo.OwnerId, &todo.ParentId, &todo.Title, &todo.Description, &todo.Done, &priority,
+		&todo.DueAt, &todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &todo.Version, &todo.Tags,
+		&todo.Recurrence, &todo.SeriesId, &todo.Position, &todo.ArchivedAt, &todo.DeletedAt, &todo.Progress,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.Todo{}, err
+	}
+	todo.Priority = models.PriorityFromRank(int(priority))
+	if todo.Tags == nil {
+		todo.Tags = []string{}
+	}
+	return todo, nil
 }
 
-func (db *DB) Close() error {
-	return db.conn.Close(context.Background())
+// querier is implemented by both the pool and transactions.
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-func (db *DB) Create(todo models.Todo) (id string, err error) {
-	err = db.conn.QueryRow(context.Background(), "INSERT INTO todos (title) VALUES ($1) RETURNING id", todo.Title).Scan(&id)
-	return id, err
+// getTodo reads a todo the caller can see that isn't in the trash.
+func getTodo(ctx context.Context, q querier, id string) (models.Todo, error) {
+	return scanTodo(q.QueryRow(ctx,
+		"SELECT "+todoColumns+" FROM todos WHERE id = $1 AND deleted_at IS NULL AND "+todoScope(2, readAccess),
+		id, owner(ctx)))
+}
+
+// lockTodo reads and locks a todo the caller can change that isn't in the
+// trash, the todos of lists shared with the caller as a viewer aren't found.
+func lockTodo(ctx context.Context, q querier, id string) (models.Todo, error) {
+	return scanTodo(q.QueryRow(ctx,
+		"SELECT "+todoColumns+" FROM todos WHERE id = $1 AND deleted_at IS NULL AND "+todoScope(2, writes(ctx))+" FOR UPDATE OF todos",
+		id, owner(ctx)))
+}
+
+// Create inserts the todo and returns it as stored. Todos without a list go
+// to their parent's list, or the caller's inbox.
+func (db *DB) Create(ctx context.Context, todo models.Todo) (created models.Todo, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		created, err = db.insertTodo(ctx, tx, todo)
+		return err
+	})
+	return created, translateError(err)
+}
+
+// insertTodo inserts the todo after its siblings, including its tags, and
+// returns it as stored.
+func (db *DB) insertTodo(ctx context.Context, tx pgx.Tx, todo models.Todo) (models.Todo, error) {
+	// the todo belongs to the owner of its list, which may have been shared
+	// with the caller, a parent out of reach leaves it in the inbox and fails
+	// the parent's foreign key or checkParent
+	var listId, ownerId string
+	err := tx.QueryRow(ctx, `SELECT id, owner_id FROM lists
+  WHERE id = COALESCE(NULLIF($1, '')::integer,
+      (SELECT list_id FROM todos WHERE id = $2 AND `+todoScope(3, writes(ctx))+`),
+      (SELECT min(id) FROM lists WHERE inbox AND `+ownerScope("lists", 3)+`))
+    AND `+listScope(3, writes(ctx)),
+		todo.ListId, todo.ParentId, owner(ctx),
+	).Scan(&listId, &ownerId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var v models.ValidationError
+		v.Add("listId", "does not exist")
+		return models.Todo{}, v.Err()
+	}
+	if err != nil {
+		return models.Todo{}, err
+	}
+	position, err := lastPosition(ctx, tx, listId, todo.ParentId)
+	if err != nil {
+		return models.Todo{}, err
+	}
+
+	var id string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO todos (owner_id, list_id, parent_id, title, description, done, priority, due_at, recurrence, series_id, position)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+  RETURNING id`,
+		ownerId, listId, todo.ParentId, todo.Title, todo.Description, todo.Done, todo.Priority.Rank(), todo.DueAt,
+		todo.Recurrence, todo.SeriesId, position,
+	).Scan(&id)
+	if err != nil {
+		return models.Todo{}, err
+	}
+	if err := db.writeSubtask(ctx, tx, id, todo); err != nil {
+		return models.Todo{}, err
+	}
+	if err := setTags(ctx, tx, id, todo.Tags); err != nil {
+		return models.Todo{}, err
+	}
+	return getTodo(ctx, tx, id)
 }
 
-func (db *DB) Get(id string) (todo models.Todo, err error) {
-	err = db.conn.QueryRow(context.Background(), "SELECT * from todos WHERE id = $1", id).Scan(&todo.Id, &todo.Title, &todo.Done, &todo.CreatedAt)
-	return todo, err
+func (db *DB) Get(ctx context.Context, id string) (todo models.Todo, err error) {
+	todo, err = getTodo(ctx, db.pool, id)
+	return todo, translateError(err)
 }
 
-func (db *DB) Update(id string, todo models.Todo) (count int64, err error) {
-	commandTag, err := db.conn.Exec(context.Background(), "UPDATE todos SET title = $1, done = $2 WHERE id = $3", todo.Title, todo.Done, id)
+// replaceTodo writes every writable field of todo, including its tags, to
+// the current row. Without a list the todo stays in its current one, another
+// one must belong to the todo's owner and be one the caller can change.
+func (db *DB) replaceTodo(ctx context.Context, tx pgx.Tx, current, todo models.Todo) (models.Todo, error) {
+	if todo.ListId != "" {
+		var found bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM lists WHERE id = $1 AND "+listScope(2, writes(ctx))+")",
+			todo.ListId, owner(ctx)).Scan(&found)
+		if err != nil {
+			return models.Todo{}, err
+		}
+		if !found {
+			var v models.ValidationError
+			v.Add("listId", "does not exist")
+			return models.Todo{}, v.Err()
+		}
+	}
+
+	// the position key was only ordered among the todo's siblings, so a
+	// todo that gets new ones goes after them
+	position, listId := current.Position, cmp.Or(todo.ListId, current.ListId)
+	if listId != current.ListId || !equalIds(todo.ParentId, current.ParentId) {
+		var err error
+		if position, err = lastPosition(ctx, tx, listId, todo.ParentId); err != nil {
+			return models.Todo{}, err
+		}
+	}
+
+	id := current.Id
+	err := tx.QueryRow(ctx,
+		`UPDATE todos SET list_id = $1, parent_id = $2,
+  title = $3, description = $4, done = $5, priority = $6, due_at = $7, recurrence = $8, position = $9
+  WHERE id = $10 RETURNING id`,
+		listId, todo.ParentId, todo.Title, todo.Description, todo.Done, todo.Priority.Rank(), todo.DueAt,
+		todo.Recurrence, position, id,
+	).Scan(&id)
 	if err != nil {
-		return -1, err
+		return models.Todo{}, err
+	}
+	if err := db.writeSubtask(ctx, tx, id, todo); err != nil {
+		return models.Todo{}, err
+	}
+	if err := setTags(ctx, tx, id, todo.Tags); err != nil {
+		return models.Todo{}, err
 	}
+	return getTodo(ctx, tx, id)
+}
 
-	return commandTag.RowsAffected(), err
+// UpdateFunc locks the todo for the duration of a transaction, replaces its
+// writable fields with the result of fn and returns the updated todo. If fn
+// returns an error nothing is written and the error is returned as is.
+// Completing a recurring todo creates the next one in its series, and adding
+// or removing tags depends on the current ones, which is why changes are
+// made to the locked row rather than compiled to a single UPDATE.
+func (db *DB) UpdateFunc(ctx context.Context, id string, fn func(todo models.Todo) (models.Todo, error)) (updated models.Todo, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		updated, err = db.updateTodo(ctx, tx, id, fn)
+		return err
+	})
+	if err != nil {
+		return models.Todo{}, translateError(err)
+	}
+	return updated, nil
 }
 
-func (db *DB) GetAll() (todos []models.Todo, err error) {
-	rows, err := db.conn.Query(context.Background(), "SELECT * from todos")
+// updateTodo is UpdateFunc within tx. Errors other than those from fn are
+// already translated.
+func (db *DB) updateTodo(ctx context.Context, tx pgx.Tx, id string, fn func(todo models.Todo) (models.Todo, error)) (models.Todo, error) {
+	current, err := lockTodo(ctx, tx, id)
 	if err != nil {
-		return nil, fmt.Errorf("Error executing get all query: %v", err)
+		return models.Todo{}, translateError(err)
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var todo models.Todo
-		if err := rows.Scan(&todo.Id, &todo.Title, &todo.Done, &todo.CreatedAt); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue // Or handle the error as appropriate
-		}
-		todos = append(todos, todo)
+	todo, err := fn(current)
+	if err != nil {
+		return models.Todo{}, err
 	}
 
-	return todos, err
+	updated, err := db.replaceTodo(ctx, tx, current, todo)
+	if err != nil {
+		return models.Todo{}, translateError(err)
+	}
+
+	if !current.Done && updated.Done {
+		updated, err = db.scheduleNext(ctx, tx, updated)
+	}
+	return updated, translateError(err)
 }
 
-func (db *DB) Delete(id string) (count int64, err error) {
-	commandTag, err := db.conn.Exec(context.Background(), "DELETE from todos WHERE id = $1", id)
+// DeleteFunc moves the todo to the trash along with its subtasks, and returns
+// how many todos were moved, unless check returns an error for the todo,
+// which is locked for the duration of a transaction. The error is returned
+// as is. See Restore and Purge.
+func (db *DB) DeleteFunc(ctx context.Context, id string, check func(todo models.Todo) error) (count int64, err error) {
+	var checkErr error
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		current, err := lockTodo(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if checkErr = check(current); checkErr != nil {
+			return checkErr
+		}
+		count, err = db.trashTodo(ctx, tx, id)
+		return err
+	})
+	if checkErr != nil {
+		return 0, checkErr
+	}
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return count, nil
+}
+
+// trashTodo moves the todo and its subtasks to the trash using q, and
+// returns how many todos were moved.
+func (db *DB) trashTodo(ctx context.Context, q querier, id string) (int64, error) {
+	commandTag, err := q.Exec(ctx, `WITH RECURSIVE subtree AS (
+    SELECT id, 1 AS depth FROM todos WHERE id = $1 AND deleted_at IS NULL AND `+todoScope(4, writes(ctx))+`
+    UNION ALL
+    SELECT todos.id, subtree.depth + 1
+    FROM todos JOIN subtree ON todos.parent_id = subtree.id
+    WHERE todos.deleted_at IS NULL AND subtree.depth < $2
+  )
+  UPDATE todos SET deleted_at = $3 WHERE id IN (SELECT id FROM subtree)`,
+		id, MaxDepth, db.now(), owner(ctx))
 	if err != nil {
-		return -1, err
+		return -1, translateError(err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return 0, ErrNotFound
 	}
 
-	return commandTag.RowsAffected(), err
+	return commandTag.RowsAffected(), nil
 }
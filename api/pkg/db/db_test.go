@@ -2,12 +2,19 @@ package db_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"example.com/todos/internal/docker"
 	. "example.com/todos/pkg/db"
+	"example.com/todos/pkg/middleware"
 	"example.com/todos/pkg/models"
 )
 
@@ -31,21 +38,71 @@ func TestDB(t *testing.T) {
 		t.Fatalf("failed to get Postgres URL, %v", err)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	sut := NewDB(ctx, url)
+	// the tests act for every user, like the trash purger
+	ctx, cancel := context.WithCancel(middleware.WithPrincipal(context.Background(), middleware.SystemPrincipal))
 	defer cancel()
+	sut, err := NewDB(ctx, url, Config{MaxConns: 4})
+	if err != nil {
+		t.Fatalf("failed to connect to Postgres db, %v", err)
+	}
+	defer sut.Close()
+
+	migrator, err := NewMigrator(sut)
+	if err != nil {
+		t.Fatalf("failed to load migrations, %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("failed to migrate database, %v", err)
+	}
+
+	t.Run("migrations", func(t *testing.T) {
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			t.Fatalf("failed to get migration status, %v", err)
+		}
+		for _, s := range statuses {
+			if !s.Applied || s.Modified {
+				t.Fatalf("expected migration %d_%s to be applied and unmodified", s.Version, s.Name)
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, len(statuses))
+		if err != nil {
+			t.Fatalf("failed to revert migrations, %v", err)
+		}
+		if len(reverted) != len(statuses) {
+			t.Fatalf("wrong number of reverted migrations, expected: %d, got: %d", len(statuses), len(reverted))
+		}
+
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			t.Fatalf("failed to re-apply migrations, %v", err)
+		}
+		if len(applied) != len(statuses) {
+			t.Fatalf("wrong number of applied migrations, expected: %d, got: %d", len(statuses), len(applied))
+		}
+
+		applied, err = migrator.Up(ctx)
+		if err != nil {
+			t.Fatalf("failed to run migrations on an up to date schema, %v", err)
+		}
+		if len(applied) != 0 {
+			t.Fatalf("expected no migrations to apply, got: %d", len(applied))
+		}
+	})
 
 	t.Run("happy path", func(t *testing.T) {
 		todo := models.Todo{
 			Title: "a newly created todo",
 		}
 
-		id, err := sut.Create(todo)
+		created, err := sut.Create(ctx, todo)
 		if err != nil {
 			t.Fatalf("failed to create new todo, %v", err)
 		}
+		id := created.Id
 
-		newTodo, err := sut.Get(id)
+		newTodo, err := sut.Get(ctx, id)
 		if err != nil {
 			t.Fatalf("failed to get new todo, %v", err)
 		}
@@ -55,12 +112,15 @@ func TestDB(t *testing.T) {
 		}
 
 		newTodo.Done = true
-		updatedRecords, err := sut.Update(newTodo.Id, newTodo)
-		if updatedRecords != 1 {
-			t.Fatalf("updated wrong number of records, expected: 1, got: %d", updatedRecords)
+		updatedTodo, err := replaceTodo(ctx, sut, newTodo.Id, newTodo)
+		if err != nil {
+			t.Fatalf("failed to update todo, %v", err)
+		}
+		if updatedTodo.Id != newTodo.Id || updatedTodo.Done != true {
+			t.Fatalf("update returned bad data, expected: %+v, got: %+v", newTodo, updatedTodo)
 		}
 
-		completedTodo, err := sut.Get(id)
+		completedTodo, err := sut.Get(ctx, id)
 		if err != nil {
 			t.Fatalf("failed to get new todo, %v", err)
 		}
@@ -72,15 +132,15 @@ func TestDB(t *testing.T) {
 			t.Fatalf("updated todo has bad data for 'title', expected: %s, got: %s", todo.Title, completedTodo.Title)
 		}
 
-		allTodos, err := sut.GetAll()
+		allTodos, err := sut.List(ctx, ListOptions{})
 		if err != nil {
-			t.Fatalf("failed to get all todos, %v", err)
+			t.Fatalf("failed to list todos, %v", err)
 		}
-		if len(allTodos) != 1 {
-			t.Fatalf("wrong number of total todos, expected: 1, got: %d", len(allTodos))
+		if len(allTodos.Todos) != 1 {
+			t.Fatalf("wrong number of total todos, expected: 1, got: %d", len(allTodos.Todos))
 		}
 
-		deletedRecords, err := sut.Delete(completedTodo.Id)
+		deletedRecords, err := trashTodo(ctx, sut, completedTodo.Id)
 		if err != nil {
 			t.Fatalf("failed to delete todo: %s, %v", completedTodo.Id, err)
 		}
@@ -88,14 +148,1318 @@ func TestDB(t *testing.T) {
 			t.Fatalf("wrong number of deleted todos, expected: 1, got: %d", deletedRecords)
 		}
 
-		allTodos, err = sut.GetAll()
+		allTodos, err = sut.List(ctx, ListOptions{})
+		if err != nil {
+			t.Fatalf("failed to list todos, %v", err)
+		}
+		if len(allTodos.Todos) != 0 {
+			t.Fatalf("wrong number of total todos, expected: 0, got: %d", len(allTodos.Todos))
+		}
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		created, err := sut.Create(ctx, models.Todo{Title: "patch me"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		id := created.Id
+		defer trashTodo(ctx, sut, id)
+
+		// only the fields in the patch may change
+		patched, err := patchTodo(ctx, sut, id, models.TodoPatch{Done: models.Some(true)})
+		if err != nil {
+			t.Fatalf("failed to patch todo, %v", err)
+		}
+		if patched.Title != "patch me" || !patched.Done {
+			t.Fatalf("patch changed the wrong fields, got: %+v", patched)
+		}
+
+		patched, err = patchTodo(ctx, sut, id, models.TodoPatch{Title: models.Some("patched")})
+		if err != nil {
+			t.Fatalf("failed to patch todo, %v", err)
+		}
+		if patched.Title != "patched" || !patched.Done {
+			t.Fatalf("patch changed the wrong fields, got: %+v", patched)
+		}
+
+		unchanged, err := patchTodo(ctx, sut, id, models.TodoPatch{})
+		if err != nil {
+			t.Fatalf("failed to apply an empty patch, %v", err)
+		}
+		// the write itself still counts as one
+		unchanged.Version, unchanged.UpdatedAt = patched.Version, patched.UpdatedAt
+		if !reflect.DeepEqual(unchanged, patched) {
+			t.Fatalf("empty patch changed the todo, expected: %+v, got: %+v", patched, unchanged)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		var ids []string
+		for i := range 5 {
+			created, err := sut.Create(ctx, models.Todo{Title: fmt.Sprintf("page %d", i)})
+			if err != nil {
+				t.Fatalf("failed to create new todo, %v", err)
+			}
+			id := created.Id
+			defer trashTodo(ctx, sut, id)
+			ids = append(ids, id)
+		}
+
+		var listed []string
+		opts := ListOptions{Limit: 2}
+		for pages := 1; ; pages++ {
+			page, err := sut.List(ctx, opts)
+			if err != nil {
+				t.Fatalf("failed to list todos, %v", err)
+			}
+			for _, todo := range page.Todos {
+				listed = append(listed, todo.Id)
+			}
+			if page.NextCursor == "" {
+				if pages != 3 {
+					t.Fatalf("wrong number of pages, expected: 3, got: %d", pages)
+				}
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		if !slices.Equal(listed, ids) {
+			t.Fatalf("pages returned wrong todos, expected: %v, got: %v", ids, listed)
+		}
+
+		if _, err := sut.List(ctx, ListOptions{Cursor: "garbage"}); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected ErrInvalid for a bad cursor, got %v", err)
+		}
+	})
+
+	t.Run("filter and sort", func(t *testing.T) {
+		var ids []string
+		for _, title := range []string{"walk the dog", "100% done", "wash the car"} {
+			created, err := sut.Create(ctx, models.Todo{Title: title, Done: title == "100% done"})
+			if err != nil {
+				t.Fatalf("failed to create new todo, %v", err)
+			}
+			id := created.Id
+			defer trashTodo(ctx, sut, id)
+			ids = append(ids, id)
+		}
+		done, open := true, false
+
+		tests := []struct {
+			name string
+			opts ListOptions
+			want []string
+		}{
+			{"open", ListOptions{Filter: Filter{Done: &open}}, []string{ids[0], ids[2]}},
+			{"done", ListOptions{Filter: Filter{Done: &done}}, []string{ids[1]}},
+			{"title ignores case", ListOptions{Filter: Filter{TitleContains: "THE"}}, []string{ids[0], ids[2]}},
+			{"title wildcards are literal", ListOptions{Filter: Filter{TitleContains: "%"}}, []string{ids[1]}},
+			{"created after", ListOptions{Filter: Filter{CreatedAfter: time.Now().Add(time.Hour)}}, nil},
+			{"newest first", ListOptions{Sort: Sort{{Field: "createdAt", Desc: true}}}, []string{ids[2], ids[1], ids[0]}},
+			{"by title", ListOptions{Sort: Sort{{Field: "title"}}}, []string{ids[1], ids[0], ids[2]}},
+			{"paged by done", ListOptions{Limit: 1, Sort: Sort{{Field: "done", Desc: true}, {Field: "title"}}}, []string{ids[1], ids[0], ids[2]}},
+		}
+		for _, tt := range tests {
+			var listed []string
+			opts := tt.opts
+			for {
+				page, err := sut.List(ctx, opts)
+				if err != nil {
+					t.Fatalf("%s: failed to list todos, %v", tt.name, err)
+				}
+				for _, todo := range page.Todos {
+					listed = append(listed, todo.Id)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+			if !slices.Equal(listed, tt.want) {
+				t.Errorf("%s: wrong todos, expected: %v, got: %v", tt.name, tt.want, listed)
+			}
+		}
+
+		if _, err := sut.List(ctx, ListOptions{Sort: Sort{{Field: "id; DROP TABLE todos"}}}); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected ErrInvalid for an unknown sort field, got %v", err)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		var ids []string
+		for _, title := range []string{"Buy milk", "Buy bread and some milk for the week", "Walk the dogs"} {
+			created, err := sut.Create(ctx, models.Todo{Title: title})
+			if err != nil {
+				t.Fatalf("failed to create new todo, %v", err)
+			}
+			id := created.Id
+			defer trashTodo(ctx, sut, id)
+			ids = append(ids, id)
+		}
+
+		results, err := sut.Search(ctx, SearchOptions{Query: "milk"})
+		if err != nil {
+			t.Fatalf("failed to search todos, %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("wrong number of results, expected: 2, got: %d", len(results))
+		}
+		if results[0].Id != ids[0] || results[0].Rank < results[1].Rank {
+			t.Fatalf("results are not ranked, got: %+v", results)
+		}
+		if results[0].Snippet != "Buy <mark>milk</mark>" {
+			t.Fatalf("wrong snippet, got: %q", results[0].Snippet)
+		}
+
+		// stemming and websearch syntax
+		results, err = sut.Search(ctx, SearchOptions{Query: `dog or "buy bread" -milk`})
+		if err != nil {
+			t.Fatalf("failed to search todos, %v", err)
+		}
+		if len(results) != 1 || results[0].Id != ids[2] {
+			t.Fatalf("wrong results for websearch query, got: %+v", results)
+		}
+
+		// markup in the todo is escaped, only the highlighting is HTML
+		created, err := sut.Create(ctx, models.Todo{Title: `<img src=x onerror="alert(1)"> cheese`, Description: "<b>aged</b> cheese"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, created.Id)
+		results, err = sut.Search(ctx, SearchOptions{Query: "cheese"})
+		if err != nil {
+			t.Fatalf("failed to search todos, %v", err)
+		}
+		if len(results) != 1 || strings.Contains(results[0].Snippet, "<img") || !strings.HasSuffix(results[0].Snippet, "<mark>cheese</mark>") ||
+			strings.Contains(results[0].DescriptionSnippet, "<b>") || !strings.Contains(results[0].DescriptionSnippet, "&lt;b&gt;") {
+			t.Fatalf("expected the snippets to be escaped, got: %+v", results)
+		}
+
+		results, err = sut.Search(ctx, SearchOptions{Query: "the"})
+		if err != nil {
+			t.Fatalf("failed to search for a stop word, %v", err)
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no results for a stop word, got: %+v", results)
+		}
+	})
+
+	t.Run("details", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+		created, err := sut.Create(ctx, models.Todo{Title: "file taxes", Description: "*forms*", Priority: models.PriorityUrgent, DueAt: &past})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, created.Id)
+		if created.Description != "*forms*" || created.Priority != models.PriorityUrgent || created.DueAt == nil || !created.DueAt.Equal(past) {
+			t.Fatalf("create returned bad data, got: %+v", created)
+		}
+		if !created.UpdatedAt.Equal(created.CreatedAt) || created.CompletedAt != nil {
+			t.Fatalf("create returned bad timestamps, got: %+v", created)
+		}
+
+		open, err := sut.Create(ctx, models.Todo{Title: "no due date"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, open.Id)
+		if open.Priority != models.PriorityNormal || open.DueAt != nil {
+			t.Fatalf("create returned bad defaults, got: %+v", open)
+		}
+
+		overdue := true
+		page, err := sut.List(ctx, ListOptions{Filter: Filter{Overdue: &overdue}})
+		if err != nil {
+			t.Fatalf("failed to list overdue todos, %v", err)
+		}
+		if len(page.Todos) != 1 || page.Todos[0].Id != created.Id {
+			t.Fatalf("wrong overdue todos, got: %+v", page.Todos)
+		}
+		overdue = false
+		page, err = sut.List(ctx, ListOptions{Filter: Filter{Overdue: &overdue, Priorities: []models.Priority{models.PriorityNormal}}})
+		if err != nil {
+			t.Fatalf("failed to list todos, %v", err)
+		}
+		if len(page.Todos) != 1 || page.Todos[0].Id != open.Id {
+			t.Fatalf("wrong todos that are not overdue, got: %+v", page.Todos)
+		}
+
+		// overdue is decided by Config.Now, not the clock of the database server
+		earlier := past.Add(-time.Hour)
+		clockDB, err := NewDB(ctx, url, Config{MaxConns: 1, Now: func() time.Time { return earlier }})
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres db, %v", err)
+		}
+		defer clockDB.Close()
+		overdue = true
+		page, err = clockDB.List(ctx, ListOptions{Filter: Filter{Overdue: &overdue}})
+		if err != nil {
+			t.Fatalf("failed to list overdue todos, %v", err)
+		}
+		if len(page.Todos) != 0 {
+			t.Fatalf("expected no overdue todos an hour before the due date, got: %+v", page.Todos)
+		}
+
+		// todos without a due date page correctly in both directions
+		for _, sort := range []string{"dueAt", "-dueAt"} {
+			s, _ := ParseSort(sort)
+			first, err := sut.List(ctx, ListOptions{Limit: 1, Sort: s})
+			if err != nil {
+				t.Fatalf("failed to list todos by %s, %v", sort, err)
+			}
+			second, err := sut.List(ctx, ListOptions{Limit: 1, Sort: s, Cursor: first.NextCursor})
+			if err != nil {
+				t.Fatalf("failed to list second page by %s, %v", sort, err)
+			}
+			if len(first.Todos) != 1 || len(second.Todos) != 1 || first.Todos[0].Id == second.Todos[0].Id {
+				t.Fatalf("wrong pages by %s, got: %+v and %+v", sort, first.Todos, second.Todos)
+			}
+			if (sort == "dueAt") != (first.Todos[0].Id == created.Id) {
+				t.Fatalf("todos without a due date must sort last, got %+v first by %s", first.Todos[0], sort)
+			}
+		}
+
+		completed, err := patchTodo(ctx, sut, created.Id, models.TodoPatch{Done: models.Some(true), DueAt: models.Optional[time.Time]{Set: true, Null: true}})
+		if err != nil {
+			t.Fatalf("failed to patch todo, %v", err)
+		}
+		if completed.CompletedAt == nil || completed.DueAt != nil || !completed.UpdatedAt.After(created.UpdatedAt) {
+			t.Fatalf("patch returned bad data, got: %+v", completed)
+		}
+
+		reopened, err := replaceTodo(ctx, sut, created.Id, models.Todo{Title: "file taxes"})
+		if err != nil {
+			t.Fatalf("failed to update todo, %v", err)
+		}
+		if reopened.CompletedAt != nil || reopened.Description != "" || reopened.Priority != models.PriorityNormal {
+			t.Fatalf("update returned bad data, got: %+v", reopened)
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		tagged, err := sut.Create(ctx, models.Todo{Title: "fix login", Tags: []string{"backend", "urgent"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, tagged.Id)
+		if !slices.Equal(tagged.Tags, []string{"backend", "urgent"}) {
+			t.Fatalf("create returned wrong tags, got: %v", tagged.Tags)
+		}
+		other, err := sut.Create(ctx, models.Todo{Title: "restyle login", Tags: []string{"frontend"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, other.Id)
+		if untagged, err := sut.Get(ctx, other.Id); err != nil || untagged.Tags == nil {
+			t.Fatalf("expected tags to be read back, got: %+v, %v", untagged, err)
+		}
+
+		patched, err := patchTodo(ctx, sut, tagged.Id, models.TodoPatch{AddTags: []string{"frontend"}, RemoveTags: []string{"urgent"}})
+		if err != nil {
+			t.Fatalf("failed to patch tags, %v", err)
+		}
+		if !slices.Equal(patched.Tags, []string{"backend", "frontend"}) {
+			t.Fatalf("patch returned wrong tags, got: %v", patched.Tags)
+		}
+
+		for _, tt := range []struct {
+			all  bool
+			want int
+		}{{true, 1}, {false, 2}} {
+			page, err := sut.List(ctx, ListOptions{Filter: Filter{Tags: []string{"backend", "frontend"}, AllTags: tt.all}})
+			if err != nil {
+				t.Fatalf("failed to filter by tags, %v", err)
+			}
+			if len(page.Todos) != tt.want {
+				t.Fatalf("wrong number of todos for all=%t, expected: %d, got: %d", tt.all, tt.want, len(page.Todos))
+			}
+		}
+
+		tags, err := sut.ListTags(ctx)
+		if err != nil {
+			t.Fatalf("failed to list tags, %v", err)
+		}
+		counts := map[string]int{}
+		for _, tag := range tags {
+			counts[tag.Name] = tag.TodoCount
+		}
+		if counts["backend"] != 1 || counts["frontend"] != 2 || counts["urgent"] != 0 {
+			t.Fatalf("wrong tag counts, got: %v", counts)
+		}
+
+		if _, err := sut.CreateTag(ctx, "backend"); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict for a duplicate tag, got %v", err)
+		}
+		ops, err := sut.CreateTag(ctx, "ops")
+		if err != nil {
+			t.Fatalf("failed to create tag, %v", err)
+		}
+		defer sut.DeleteTag(ctx, ops.Id)
+
+		var frontend models.Tag
+		for _, tag := range tags {
+			if tag.Name == "frontend" {
+				frontend = tag
+			}
+		}
+		if _, err := sut.RenameTag(ctx, frontend.Id, "ops"); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict renaming to a taken name, got %v", err)
+		}
+		if _, err := sut.RenameTag(ctx, frontend.Id, "web"); err != nil {
+			t.Fatalf("failed to rename tag, %v", err)
+		}
+		if got, _ := sut.Get(ctx, other.Id); !slices.Equal(got.Tags, []string{"web"}) {
+			t.Fatalf("rename did not change the todo, got: %v", got.Tags)
+		}
+
+		if err := sut.DeleteTag(ctx, frontend.Id); err != nil {
+			t.Fatalf("failed to delete tag, %v", err)
+		}
+		if got, _ := sut.Get(ctx, tagged.Id); !slices.Equal(got.Tags, []string{"backend"}) {
+			t.Fatalf("delete did not remove the tag from the todo, got: %v", got.Tags)
+		}
+		if err := sut.DeleteTag(ctx, frontend.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting a missing tag, got %v", err)
+		}
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		lists, err := sut.GetLists(ctx)
+		if err != nil {
+			t.Fatalf("failed to get lists, %v", err)
+		}
+		if len(lists) == 0 || !lists[0].Inbox {
+			t.Fatalf("expected the inbox first, got: %+v", lists)
+		}
+		inbox := lists[0]
+
+		work, err := sut.CreateList(ctx, "Work")
+		if err != nil {
+			t.Fatalf("failed to create list, %v", err)
+		}
+		defer sut.DeleteList(ctx, work.Id, true)
+
+		unfiled, err := sut.Create(ctx, models.Todo{Title: "buy milk"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, unfiled.Id)
+		if unfiled.ListId != inbox.Id {
+			t.Fatalf("expected the todo in the inbox, got list %q", unfiled.ListId)
+		}
+		filed, err := sut.Create(ctx, models.Todo{ListId: work.Id, Title: "write report", Done: true})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, filed.Id)
+
+		var v *models.ValidationError
+		if _, err := sut.Create(ctx, models.Todo{ListId: "1986", Title: "lost"}); !errors.As(err, &v) || v.Errors[0].Field != "listId" {
+			t.Fatalf("expected a validation error for a missing list, got: %v", err)
+		}
+
+		moved, err := patchTodo(ctx, sut, unfiled.Id, models.TodoPatch{ListId: models.Some(work.Id)})
+		if err != nil || moved.ListId != work.Id {
+			t.Fatalf("failed to move todo, got: %+v, %v", moved, err)
+		}
+		// a moved todo goes after those already in the list
+		if moved.Position <= filed.Position {
+			t.Fatalf("expected the moved todo after %q, got position %q", filed.Position, moved.Position)
+		}
+		work, err = sut.GetList(ctx, work.Id)
+		if err != nil {
+			t.Fatalf("failed to get list, %v", err)
+		}
+		if work.OpenCount != 1 || work.DoneCount != 1 {
+			t.Fatalf("wrong list counts, got: %+v", work)
+		}
+		page, err := sut.List(ctx, ListOptions{Filter: Filter{ListId: work.Id}})
+		if err != nil || len(page.Todos) != 2 {
+			t.Fatalf("expected 2 todos in the list, got: %+v, %v", page.Todos, err)
+		}
+
+		if err := sut.DeleteList(ctx, inbox.Id, false); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict deleting the inbox, got: %v", err)
+		}
+		if err := sut.DeleteList(ctx, work.Id, false); err != nil {
+			t.Fatalf("failed to delete list, %v", err)
+		}
+		if got, err := sut.Get(ctx, filed.Id); err != nil || got.ListId != inbox.Id {
+			t.Fatalf("expected the todo moved to the inbox, got: %+v, %v", got, err)
+		}
+		// the todos of the list go after those of the inbox, in their order
+		byPosition, _ := ParseSort("position")
+		page, err = sut.List(ctx, ListOptions{Filter: Filter{ListId: inbox.Id}, Sort: byPosition, Limit: MaxListLimit})
+		if err != nil {
+			t.Fatalf("failed to list the inbox, %v", err)
+		}
+		var order []string
+		for _, todo := range page.Todos {
+			if todo.ParentId == nil {
+				order = append(order, todo.Id)
+			}
+		}
+		if len(order) < 2 || !slices.Equal(order[len(order)-2:], []string{filed.Id, unfiled.Id}) {
+			t.Fatalf("expected the moved todos last in the inbox, got: %v", order)
+		}
+
+		home, err := sut.CreateList(ctx, "Home")
+		if err != nil {
+			t.Fatalf("failed to create list, %v", err)
+		}
+		chore, err := sut.Create(ctx, models.Todo{ListId: home.Id, Title: "water plants"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		if err := sut.DeleteList(ctx, home.Id, true); err != nil {
+			t.Fatalf("failed to delete list, %v", err)
+		}
+		if _, err := sut.Get(ctx, chore.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected the todo deleted with its list, got: %v", err)
+		}
+		if _, err := sut.GetList(ctx, home.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting a deleted list, got: %v", err)
+		}
+		// the todo went to the trash, and comes back in the inbox
+		restored, err := sut.Restore(ctx, chore.Id)
+		if err != nil {
+			t.Fatalf("failed to restore a todo of a deleted list, %v", err)
+		}
+		defer trashTodo(ctx, sut, restored.Id)
+		if restored.ListId != inbox.Id || restored.DeletedAt != nil {
+			t.Fatalf("expected the todo restored to the inbox, got: %+v", restored)
+		}
+	})
+
+	t.Run("subtasks", func(t *testing.T) {
+		parent, err := sut.Create(ctx, models.Todo{Title: "release"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, parent.Id)
+		if parent.Progress != nil {
+			t.Fatalf("expected no progress without subtasks, got: %d", *parent.Progress)
+		}
+
+		ids := []string{parent.Id}
+		for i := 2; i <= MaxDepth; i++ {
+			child, err := sut.Create(ctx, models.Todo{ParentId: &ids[len(ids)-1], Title: fmt.Sprintf("level %d", i)})
+			if err != nil {
+				t.Fatalf("failed to create subtask at level %d, %v", i, err)
+			}
+			if child.ListId != parent.ListId {
+				t.Fatalf("expected the subtask in its parent's list, got: %q", child.ListId)
+			}
+			ids = append(ids, child.Id)
+		}
+		sibling, err := sut.Create(ctx, models.Todo{ParentId: &parent.Id, Title: "tag release", Done: true})
+		if err != nil {
+			t.Fatalf("failed to create subtask, %v", err)
+		}
+
+		if got, _ := sut.Get(ctx, parent.Id); got.Progress == nil || *got.Progress != 50 {
+			t.Fatalf("expected 50%% progress, got: %v", got.Progress)
+		}
+		descendants, err := sut.Descendants(ctx, parent.Id)
+		if err != nil {
+			t.Fatalf("failed to get descendants, %v", err)
+		}
+		if len(descendants) != MaxDepth {
+			t.Fatalf("expected %d descendants, got: %d", MaxDepth, len(descendants))
+		}
+
+		var v *models.ValidationError
+		deepest := ids[len(ids)-1]
+		if _, err := sut.Create(ctx, models.Todo{ParentId: &deepest, Title: "too deep"}); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error nesting too deep, got: %v", err)
+		}
+		if _, err := patchTodo(ctx, sut, parent.Id, models.TodoPatch{ParentId: models.Some(deepest)}); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error for a cycle, got: %v", err)
+		}
+		if _, err := patchTodo(ctx, sut, parent.Id, models.TodoPatch{ParentId: models.Some(parent.Id)}); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error for a todo under itself, got: %v", err)
+		}
+		if _, err := patchTodo(ctx, sut, sibling.Id, models.TodoPatch{ParentId: models.Some(deepest)}); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error nesting too deep, got: %v", err)
+		}
+		missing := "1986"
+		if _, err := sut.Create(ctx, models.Todo{ParentId: &missing, Title: "orphan"}); !errors.As(err, &v) || v.Errors[0].Field != "parentId" {
+			t.Fatalf("expected a validation error for a missing parent, got: %v", err)
+		}
+
+		// completing the last open subtask completes its ancestors
+		autoDB, err := NewDB(ctx, url, Config{MaxConns: 1, AutoCompleteParents: true})
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres db, %v", err)
+		}
+		defer autoDB.Close()
+		if _, err := patchTodo(ctx, autoDB, deepest, models.TodoPatch{Done: models.Some(true)}); err != nil {
+			t.Fatalf("failed to complete subtask, %v", err)
+		}
+		if got, _ := sut.Get(ctx, parent.Id); !got.Done || got.CompletedAt == nil {
+			t.Fatalf("expected the parent to be completed, got: %+v", got)
+		}
+
+		// a recurring ancestor that is completed that way repeats too
+		dueAt := time.Now().Add(time.Hour)
+		weekly, err := sut.Create(ctx, models.Todo{Title: "weekly review", DueAt: &dueAt, Recurrence: "FREQ=WEEKLY"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, weekly.Id)
+		step, err := sut.Create(ctx, models.Todo{Title: "review inbox", ParentId: &weekly.Id})
+		if err != nil {
+			t.Fatalf("failed to create subtask, %v", err)
+		}
+		if _, err := patchTodo(ctx, autoDB, step.Id, models.TodoPatch{Done: models.Some(true)}); err != nil {
+			t.Fatalf("failed to complete subtask, %v", err)
+		}
+		series, err := sut.Series(ctx, weekly.Id)
+		if err != nil || len(series) != 2 || !series[0].Done || series[1].Done || series[1].Title != "weekly review" {
+			t.Fatalf("expected the completed ancestor to be followed by its next todo, got: %+v, %v", series, err)
+		}
+		defer trashTodo(ctx, sut, series[1].Id)
+
+		if _, err := trashTodo(ctx, sut, parent.Id); err != nil {
+			t.Fatalf("failed to delete todo, %v", err)
+		}
+		if _, err := sut.Get(ctx, sibling.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected subtasks deleted with their parent, got: %v", err)
+		}
+	})
+
+	t.Run("recurrence", func(t *testing.T) {
+		now := time.Date(2025, time.March, 20, 12, 0, 0, 0, time.UTC)
+		clockDB, err := NewDB(ctx, url, Config{MaxConns: 1, Now: func() time.Time { return now }})
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres db, %v", err)
+		}
+		defer clockDB.Close()
+
+		dueAt := time.Date(2025, time.March, 8, 10, 0, 0, 0, time.UTC)
+		first, err := clockDB.Create(ctx, models.Todo{Title: "water plants", DueAt: &dueAt, Recurrence: "FREQ=WEEKLY;BYDAY=SA", Tags: []string{"home"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, clockDB, first.Id)
+		if first.Recurrence != "FREQ=WEEKLY;BYDAY=SA" || first.SeriesId != nil {
+			t.Fatalf("create returned wrong recurrence, got: %q, %v", first.Recurrence, first.SeriesId)
+		}
+
+		done, err := patchTodo(ctx, clockDB, first.Id, models.TodoPatch{Done: models.Some(true)})
+		if err != nil {
+			t.Fatalf("failed to complete todo, %v", err)
+		}
+		if done.SeriesId == nil || *done.SeriesId != first.Id {
+			t.Fatalf("expected the todo to start a series, got: %v", done.SeriesId)
+		}
+		if _, err := patchTodo(ctx, clockDB, first.Id, models.TodoPatch{Title: models.Some("water all plants")}); err != nil {
+			t.Fatalf("failed to patch todo, %v", err)
+		}
+
+		series, err := clockDB.Series(ctx, first.Id)
 		if err != nil {
-			t.Fatalf("failed to get all todos, %v", err)
+			t.Fatalf("failed to get series, %v", err)
 		}
-		if len(allTodos) != 0 {
-			t.Fatalf("wrong number of total todos, expected: 0, got: %d", len(allTodos))
+		if len(series) != 2 {
+			t.Fatalf("expected 2 todos in the series, got: %d", len(series))
+		}
+		next := series[1]
+		defer trashTodo(ctx, clockDB, next.Id)
+		// the occurrence on the 15th had already passed
+		if want := time.Date(2025, time.March, 22, 10, 0, 0, 0, time.UTC); next.Done || !next.DueAt.Equal(want) {
+			t.Fatalf("wrong next occurrence, expected due: %v, got: %+v", want, next)
+		}
+		if next.SeriesId == nil || *next.SeriesId != first.Id || !slices.Equal(next.Tags, []string{"home"}) {
+			t.Fatalf("next occurrence doesn't continue the series, got: %+v", next)
+		}
+
+		if _, err := clockDB.Series(ctx, "1986"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a missing todo, got: %v", err)
 		}
 	})
+
+	t.Run("positions", func(t *testing.T) {
+		var ids []string
+		for _, title := range []string{"first", "second", "third"} {
+			todo, err := sut.Create(ctx, models.Todo{Title: title})
+			if err != nil {
+				t.Fatalf("failed to create new todo, %v", err)
+			}
+			defer trashTodo(ctx, sut, todo.Id)
+			ids = append(ids, todo.Id)
+		}
+
+		order := func() []string {
+			page, err := sut.List(ctx, ListOptions{Limit: MaxListLimit, Sort: Sort{{Field: "position"}}})
+			if err != nil {
+				t.Fatalf("failed to list todos, %v", err)
+			}
+			var got []string
+			for _, todo := range page.Todos {
+				if slices.Contains(ids, todo.Id) {
+					got = append(got, todo.Id)
+				}
+			}
+			return got
+		}
+
+		if _, err := sut.Move(ctx, ids[2], models.TodoMove{Before: ids[0]}); err != nil {
+			t.Fatalf("failed to move todo, %v", err)
+		}
+		if got, want := order(), []string{ids[2], ids[0], ids[1]}; !slices.Equal(got, want) {
+			t.Fatalf("wrong order after moving, expected: %v, got: %v", want, got)
+		}
+
+		// squeezing todos into the same gap over and over makes the keys
+		// longer until they are spread out again, only among the siblings
+		other, err := sut.CreateList(ctx, "elsewhere")
+		if err != nil {
+			t.Fatalf("failed to create list, %v", err)
+		}
+		defer sut.DeleteList(ctx, other.Id, true)
+		bystander, err := sut.Create(ctx, models.Todo{Title: "bystander", ListId: other.Id})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		for i := range 200 {
+			moved, err := sut.Move(ctx, ids[i%2], models.TodoMove{After: ids[2]})
+			if err != nil {
+				t.Fatalf("failed to move todo, %v", err)
+			}
+			if len(moved.Position) > MaxPositionLength {
+				t.Fatalf("expected positions to be rebalanced, got: %q", moved.Position)
+			}
+		}
+		if got, want := order(), []string{ids[2], ids[1], ids[0]}; !slices.Equal(got, want) {
+			t.Fatalf("wrong order after rebalancing, expected: %v, got: %v", want, got)
+		}
+		if got, err := sut.Get(ctx, bystander.Id); err != nil || got.Position != bystander.Position || got.Version != bystander.Version {
+			t.Fatalf("expected todos of other lists to keep their positions, got: %+v, %v", got, err)
+		}
+
+		var v *models.ValidationError
+		if _, err := sut.Move(ctx, ids[0], models.TodoMove{Before: ids[0]}); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error moving next to itself, got: %v", err)
+		}
+		if _, err := sut.Move(ctx, "1986", models.TodoMove{Before: ids[0]}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound moving a missing todo, got: %v", err)
+		}
+	})
+
+	t.Run("trash", func(t *testing.T) {
+		now := time.Date(2025, time.March, 20, 12, 0, 0, 0, time.UTC)
+		clockDB, err := NewDB(ctx, url, Config{MaxConns: 1, Now: func() time.Time { return now }})
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres db, %v", err)
+		}
+		defer clockDB.Close()
+
+		parent, err := clockDB.Create(ctx, models.Todo{Title: "clean up", Tags: []string{"trashed"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		child, err := clockDB.Create(ctx, models.Todo{Title: "empty bins", ParentId: &parent.Id})
+		if err != nil {
+			t.Fatalf("failed to create new subtask, %v", err)
+		}
+
+		if count, err := trashTodo(ctx, clockDB, parent.Id); err != nil || count != 2 {
+			t.Fatalf("expected the todo and its subtask to be deleted, got: %d, %v", count, err)
+		}
+		if _, err := clockDB.Get(ctx, child.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting a deleted subtask, got: %v", err)
+		}
+		if _, err := trashTodo(ctx, clockDB, parent.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting a todo twice, got: %v", err)
+		}
+		tags, err := clockDB.ListTags(ctx)
+		if err != nil {
+			t.Fatalf("failed to list tags, %v", err)
+		}
+		for _, tag := range tags {
+			if tag.Name == "trashed" && tag.TodoCount != 0 {
+				t.Fatalf("expected deleted todos not to be counted, got: %d", tag.TodoCount)
+			}
+		}
+
+		trash, err := clockDB.List(ctx, ListOptions{Filter: Filter{Trashed: true}})
+		if err != nil {
+			t.Fatalf("failed to list the trash, %v", err)
+		}
+		if len(trash.Todos) != 2 || trash.Todos[0].DeletedAt == nil || !trash.Todos[0].DeletedAt.Equal(now) {
+			t.Fatalf("trash returned wrong todos, got: %+v", trash.Todos)
+		}
+
+		if _, err := clockDB.Restore(ctx, child.Id); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict restoring a subtask before its parent, got: %v", err)
+		}
+		restored, err := clockDB.Restore(ctx, parent.Id)
+		if err != nil {
+			t.Fatalf("failed to restore todo, %v", err)
+		}
+		if restored.DeletedAt != nil || restored.Progress == nil {
+			t.Fatalf("restore returned wrong todo, got: %+v", restored)
+		}
+		if _, err := clockDB.Get(ctx, child.Id); err != nil {
+			t.Fatalf("expected the subtask to be restored, got: %v", err)
+		}
+
+		if err := clockDB.Purge(ctx, parent.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound purging a todo outside the trash, got: %v", err)
+		}
+		trashTodo(ctx, clockDB, parent.Id)
+		now = now.Add(time.Hour)
+		if count, err := clockDB.PurgeTrash(ctx, 2*time.Hour); err != nil || count != 0 {
+			t.Fatalf("expected nothing to be purged yet, got: %d, %v", count, err)
+		}
+		if _, err := clockDB.PurgeTrash(ctx, time.Hour); err != nil {
+			t.Fatalf("failed to purge the trash, %v", err)
+		}
+		if _, err := clockDB.Restore(ctx, parent.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound restoring a purged todo, got: %v", err)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		now := time.Now().Add(30 * 24 * time.Hour)
+		clockDB, err := NewDB(ctx, url, Config{MaxConns: 1, Now: func() time.Time { return now }})
+		if err != nil {
+			t.Fatalf("failed to connect to Postgres db, %v", err)
+		}
+		defer clockDB.Close()
+
+		done, err := clockDB.Create(ctx, models.Todo{Title: "archive me", Done: true})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, clockDB, done.Id)
+		open, err := clockDB.Create(ctx, models.Todo{Title: "keep me"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, clockDB, open.Id)
+
+		// completed just now as far as the database is concerned, which is
+		// 30 days ago for the clock
+		if count, err := clockDB.ArchiveCompleted(ctx, 60*24*time.Hour); err != nil || count != 0 {
+			t.Fatalf("expected nothing to be archived yet, got: %d, %v", count, err)
+		}
+		if _, err := clockDB.ArchiveCompleted(ctx, 7*24*time.Hour); err != nil {
+			t.Fatalf("failed to archive completed todos, %v", err)
+		}
+
+		archived, err := clockDB.Get(ctx, done.Id)
+		if err != nil {
+			t.Fatalf("failed to get archived todo, %v", err)
+		}
+		if archived.ArchivedAt == nil {
+			t.Fatalf("expected the done todo to be archived, got: %+v", archived)
+		}
+		if kept, _ := clockDB.Get(ctx, open.Id); kept.ArchivedAt != nil {
+			t.Fatalf("expected the open todo not to be archived, got: %+v", kept)
+		}
+
+		notArchived := false
+		page, err := clockDB.List(ctx, ListOptions{Limit: MaxListLimit, Filter: Filter{Archived: &notArchived}})
+		if err != nil {
+			t.Fatalf("failed to list todos, %v", err)
+		}
+		if slices.ContainsFunc(page.Todos, func(todo models.Todo) bool { return todo.Id == done.Id }) {
+			t.Fatalf("expected the archived todo not to be listed")
+		}
+
+		// the owner can't unarchive it with a role that only lets them read
+		if _, err := clockDB.Unarchive(asViewer(ctx, done.OwnerId), done.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound unarchiving with a viewer's role, got: %v", err)
+		}
+		unarchived, err := clockDB.Unarchive(ctx, done.Id)
+		if err != nil {
+			t.Fatalf("failed to unarchive todo, %v", err)
+		}
+		if unarchived.ArchivedAt != nil {
+			t.Fatalf("unarchive returned an archived todo, got: %+v", unarchived)
+		}
+		if _, err := clockDB.Unarchive(ctx, "1986"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound unarchiving a missing todo, got: %v", err)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		existing, err := sut.Create(ctx, models.Todo{Title: "existing"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, existing.Id)
+
+		ops := []BatchOp{
+			{Kind: BatchCreate, Todo: models.Todo{Title: "batched", Tags: []string{"batch"}}},
+			{Kind: BatchUpdate, Id: existing.Id, Patch: models.TodoPatch{Done: models.Some(true)}},
+			{Kind: BatchUpdate, Id: "1986", Patch: models.TodoPatch{Done: models.Some(true)}},
+		}
+
+		results, err := sut.Batch(ctx, ops, true)
+		if err != nil {
+			t.Fatalf("failed to run batch, %v", err)
+		}
+		if !errors.Is(results[2].Err, ErrNotFound) || !errors.Is(results[0].Err, ErrBatchAborted) || !errors.Is(results[1].Err, ErrBatchAborted) {
+			t.Fatalf("wrong results for a failed atomic batch, got: %+v", results)
+		}
+		if todo, _ := sut.Get(ctx, existing.Id); todo.Done {
+			t.Fatalf("expected the atomic batch to be rolled back")
+		}
+
+		results, err = sut.Batch(ctx, ops, false)
+		if err != nil {
+			t.Fatalf("failed to run batch, %v", err)
+		}
+		if results[0].Err != nil || results[1].Err != nil || !errors.Is(results[2].Err, ErrNotFound) {
+			t.Fatalf("wrong results for a batch, got: %+v", results)
+		}
+		defer trashTodo(ctx, sut, results[0].Todo.Id)
+		if !slices.Equal(results[0].Todo.Tags, []string{"batch"}) || !results[1].Todo.Done {
+			t.Fatalf("batch returned wrong todos, got: %+v", results)
+		}
+		if todo, _ := sut.Get(ctx, existing.Id); !todo.Done {
+			t.Fatalf("expected the update to be committed")
+		}
+
+		// a failing statement only rolls back its own savepoint
+		results, err = sut.Batch(ctx, []BatchOp{
+			{Kind: BatchCreate, Todo: models.Todo{Title: "bad list", ListId: "not a number"}},
+			{Kind: BatchDelete, Id: results[0].Todo.Id},
+		}, false)
+		if err != nil {
+			t.Fatalf("failed to run batch, %v", err)
+		}
+		if !errors.Is(results[0].Err, ErrInvalid) || results[1].Err != nil {
+			t.Fatalf("wrong results for a batch, got: %+v", results)
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		created, err := sut.Create(ctx, models.Todo{Title: "versioned"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, created.Id)
+		if created.Version != 1 {
+			t.Fatalf("expected a new todo to be at version 1, got: %d", created.Version)
+		}
+
+		updated, err := patchTodo(ctx, sut, created.Id, models.TodoPatch{Done: models.Some(true)})
+		if err != nil {
+			t.Fatalf("failed to patch todo, %v", err)
+		}
+		if updated.Version != 2 {
+			t.Fatalf("expected an update to bump the version to 2, got: %d", updated.Version)
+		}
+
+		stale := errors.New("stale")
+		if _, err := sut.DeleteFunc(ctx, created.Id, func(models.Todo) error { return stale }); err != stale {
+			t.Fatalf("expected the check's error to be returned as is, got: %v", err)
+		}
+		if _, err := sut.Get(ctx, created.Id); err != nil {
+			t.Fatalf("expected a failed check to keep the todo, got: %v", err)
+		}
+		var checked models.Todo
+		if _, err := sut.DeleteFunc(ctx, created.Id, func(todo models.Todo) error { checked = todo; return nil }); err != nil {
+			t.Fatalf("failed to delete todo, %v", err)
+		}
+		if checked.Version != 2 {
+			t.Fatalf("expected the check to get the current todo, got: %+v", checked)
+		}
+		if _, err := sut.DeleteFunc(ctx, "1986", func(models.Todo) error { return nil }); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting a missing todo, got: %v", err)
+		}
+
+		// the tags and progress of a todo are part of it too
+		tag, err := sut.CreateTag(ctx, "versioned")
+		if err != nil {
+			t.Fatalf("failed to create tag, %v", err)
+		}
+		parent, err := sut.Create(ctx, models.Todo{Title: "versioned parent", Tags: []string{tag.Name}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		defer trashTodo(ctx, sut, parent.Id)
+		version := parent.Version
+		bumped := func(change string) {
+			t.Helper()
+			got, err := sut.Get(ctx, parent.Id)
+			if err != nil {
+				t.Fatalf("failed to get todo, %v", err)
+			}
+			if got.Version <= version {
+				t.Fatalf("expected %s to bump the version past %d, got: %d", change, version, got.Version)
+			}
+			version = got.Version
+		}
+		subtask, err := sut.Create(ctx, models.Todo{Title: "versioned subtask", ParentId: &parent.Id})
+		if err != nil {
+			t.Fatalf("failed to create subtask, %v", err)
+		}
+		bumped("adding a subtask")
+		if _, err := patchTodo(ctx, sut, subtask.Id, models.TodoPatch{Done: models.Some(true)}); err != nil {
+			t.Fatalf("failed to patch subtask, %v", err)
+		}
+		bumped("completing a subtask")
+		if _, err := trashTodo(ctx, sut, subtask.Id); err != nil {
+			t.Fatalf("failed to trash subtask, %v", err)
+		}
+		bumped("trashing a subtask")
+		if _, err := sut.RenameTag(ctx, tag.Id, "renamed"); err != nil {
+			t.Fatalf("failed to rename tag, %v", err)
+		}
+		bumped("renaming a tag")
+		if err := sut.DeleteTag(ctx, tag.Id); err != nil {
+			t.Fatalf("failed to delete tag, %v", err)
+		}
+		bumped("deleting a tag")
+	})
+
+	t.Run("users", func(t *testing.T) {
+		alice, err := sut.CreateUser(ctx, models.User{Name: "alice"})
+		if err != nil {
+			t.Fatalf("failed to create user, %v", err)
+		}
+		bob, err := sut.CreateUser(ctx, models.User{Name: "bob"})
+		if err != nil {
+			t.Fatalf("failed to create user, %v", err)
+		}
+		if _, err := sut.CreateUser(ctx, models.User{Name: "alice"}); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict creating a user with a taken name, got: %v", err)
+		}
+		if got, err := sut.GetUser(ctx, alice.Id); err != nil || got.Name != "alice" || got.Role != models.DefaultUserRole {
+			t.Fatalf("failed to get user, got: %+v, %v", got, err)
+		}
+		carol, err := sut.CreateUser(ctx, models.User{Name: "carol", Subject: "auth0|carol", Role: "viewer"})
+		if err != nil {
+			t.Fatalf("failed to create user, %v", err)
+		}
+		if got, err := sut.UserBySubject(ctx, "auth0|carol"); err != nil || got.Id != carol.Id || got.Subject != "auth0|carol" || got.Role != "viewer" {
+			t.Fatalf("failed to get user by subject, got: %+v, %v", got, err)
+		}
+		if _, err := sut.CreateUser(ctx, models.User{Name: "carol2", Subject: "auth0|carol"}); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict linking a subject twice, got: %v", err)
+		}
+		if _, err := sut.UserBySubject(ctx, ""); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for users without a subject, got: %v", err)
+		}
+		asAlice := middleware.WithPrincipal(ctx, middleware.Principal{UserId: alice.Id})
+		asBob := middleware.WithPrincipal(ctx, middleware.Principal{UserId: bob.Id})
+
+		todo, err := sut.Create(asAlice, models.Todo{Title: "alice's", Tags: []string{"private"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		if todo.OwnerId != alice.Id {
+			t.Fatalf("expected the todo to belong to alice, got: %+v", todo)
+		}
+		inbox, err := sut.GetList(asAlice, todo.ListId)
+		if err != nil || !inbox.Inbox || inbox.OwnerId != alice.Id {
+			t.Fatalf("expected the todo in alice's inbox, got: %+v, %v", inbox, err)
+		}
+
+		if _, err := sut.Get(asBob, todo.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting another user's todo, got: %v", err)
+		}
+		if _, err := patchTodo(asBob, sut, todo.Id, models.TodoPatch{Done: models.Some(true)}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound patching another user's todo, got: %v", err)
+		}
+		if _, err := trashTodo(asBob, sut, todo.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting another user's todo, got: %v", err)
+		}
+		if _, err := sut.GetList(asBob, inbox.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting another user's list, got: %v", err)
+		}
+
+		var validationErr *models.ValidationError
+		if _, err := sut.Create(asBob, models.Todo{Title: "bob's", ListId: inbox.Id}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error adding to another user's list, got: %v", err)
+		}
+		if _, err := sut.Create(asBob, models.Todo{Title: "bob's", ParentId: &todo.Id}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error adding a subtask to another user's todo, got: %v", err)
+		}
+
+		// tag names are only unique per user
+		if _, err := sut.CreateTag(asBob, "private"); err != nil {
+			t.Fatalf("failed to create a tag another user has too, %v", err)
+		}
+		page, err := sut.List(asBob, ListOptions{Limit: MaxListLimit})
+		if err != nil {
+			t.Fatalf("failed to list todos, %v", err)
+		}
+		tags, err := sut.ListTags(asBob)
+		if err != nil {
+			t.Fatalf("failed to list tags, %v", err)
+		}
+		if len(page.Todos) != 0 || len(tags) != 1 || tags[0].TodoCount != 0 {
+			t.Fatalf("expected bob to see none of alice's todos, got: %+v, %+v", page.Todos, tags)
+		}
+
+		// the system principal reaches every user's todos, and a caller
+		// without a principal none of them
+		if got, err := sut.Get(ctx, todo.Id); err != nil || got.Id != todo.Id {
+			t.Fatalf("failed to get todo as the system principal, got: %+v, %v", got, err)
+		}
+		if _, err := sut.Get(context.Background(), todo.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting a todo without a principal, got: %v", err)
+		}
+		page, err = sut.List(context.Background(), ListOptions{Limit: MaxListLimit})
+		if err != nil || len(page.Todos) != 0 {
+			t.Fatalf("expected no todos without a principal, got: %+v, %v", page.Todos, err)
+		}
+		if _, err := patchTodo(context.Background(), sut, todo.Id, models.TodoPatch{Done: models.Some(true)}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound patching a todo without a principal, got: %v", err)
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		user, err := sut.CreateUser(ctx, models.User{Name: "ci"})
+		if err != nil {
+			t.Fatalf("failed to create user, %v", err)
+		}
+		minted, secret, err := sut.MintKey(ctx, models.APIKey{UserId: user.Id, Name: "deploy", Scopes: []string{"read", "write"}})
+		if err != nil {
+			t.Fatalf("failed to mint key, %v", err)
+		}
+		if !strings.HasPrefix(secret, KeyPrefix) || !strings.HasPrefix(secret, minted.Prefix) || minted.UserId != user.Id {
+			t.Fatalf("mint returned wrong key: got %+v, %q", minted, secret)
+		}
+
+		p, err := sut.Authenticate(ctx, secret)
+		if err != nil {
+			t.Fatalf("failed to authenticate, %v", err)
+		}
+		if p.UserId != user.Id || !p.HasScope("write") || p.HasScope("admin") || !slices.Equal(p.Roles, []string{"editor"}) {
+			t.Fatalf("authenticate returned wrong principal: got %+v", p)
+		}
+		if _, err := sut.Authenticate(ctx, secret+"x"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for an unknown key, got: %v", err)
+		}
+
+		keys, err := sut.ListKeys(ctx, user.Id)
+		if err != nil {
+			t.Fatalf("failed to list keys, %v", err)
+		}
+		if len(keys) != 1 || keys[0].Id != minted.Id || keys[0].LastUsedAt == nil {
+			t.Fatalf("expected the key with its last use, got: %+v", keys)
+		}
+
+		var validationErr *models.ValidationError
+		if _, _, err := sut.MintKey(ctx, models.APIKey{UserId: "999999", Name: "ghost", Scopes: []string{"read"}}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error minting a key for an unknown user, got: %v", err)
+		}
+		past := time.Now().Add(-time.Hour)
+		if _, _, err := sut.MintKey(ctx, models.APIKey{UserId: user.Id, Name: "old", Scopes: []string{"read"}, ExpiresAt: &past}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error minting an expired key, got: %v", err)
+		}
+
+		if err := sut.RevokeKey(ctx, minted.Id); err != nil {
+			t.Fatalf("failed to revoke key, %v", err)
+		}
+		if _, err := sut.Authenticate(ctx, secret); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a revoked key, got: %v", err)
+		}
+		if err := sut.RevokeKey(ctx, "999999"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound revoking an unknown key, got: %v", err)
+		}
+	})
+
+	t.Run("sharing", func(t *testing.T) {
+		var users []models.User
+		for _, name := range []string{"dave", "erin", "frank"} {
+			user, err := sut.CreateUser(ctx, models.User{Name: name})
+			if err != nil {
+				t.Fatalf("failed to create user, %v", err)
+			}
+			users = append(users, user)
+		}
+		dave, erin, frank := users[0], users[1], users[2]
+		asDave := middleware.WithPrincipal(ctx, middleware.Principal{UserId: dave.Id})
+		asErin := middleware.WithPrincipal(ctx, middleware.Principal{UserId: erin.Id})
+		asFrank := middleware.WithPrincipal(ctx, middleware.Principal{UserId: frank.Id})
+
+		list, err := sut.CreateList(asDave, "team")
+		if err != nil {
+			t.Fatalf("failed to create list, %v", err)
+		}
+		todo, err := sut.Create(asDave, models.Todo{Title: "shared", ListId: list.Id, Tags: []string{"team"}})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		inbox, err := sut.Create(asDave, models.Todo{Title: "inbox"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+
+		if _, _, err := sut.Invite(asErin, list.Id, models.Invitation{Role: "viewer"}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound inviting to another user's list, got: %v", err)
+		}
+		if _, _, err := sut.Invite(asDave, inbox.ListId, models.Invitation{Role: "viewer"}); !errors.Is(err, ErrInboxShared) {
+			t.Fatalf("expected ErrInboxShared inviting to the inbox, got: %v", err)
+		}
+		inv, token, err := sut.Invite(asDave, list.Id, models.Invitation{Role: "viewer"})
+		if err != nil || !strings.HasPrefix(token, InvitationPrefix) || inv.ListId != list.Id || inv.AcceptedAt != nil {
+			t.Fatalf("failed to invite, got: %+v, %v", inv, err)
+		}
+		var validationErr *models.ValidationError
+		if _, err := sut.AcceptInvitation(asDave, token); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error accepting an invitation to one's own list, got: %v", err)
+		}
+		member, err := sut.AcceptInvitation(asErin, token)
+		if err != nil || member.UserId != erin.Id || member.Name != "erin" || member.Role != "viewer" {
+			t.Fatalf("failed to accept invitation, got: %+v, %v", member, err)
+		}
+		if _, err := sut.AcceptInvitation(asFrank, token); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound accepting a used invitation, got: %v", err)
+		}
+
+		// viewers see the list and its todos, and nothing else of the owner's
+		if got, err := sut.Get(asErin, todo.Id); err != nil || got.Title != "shared" {
+			t.Fatalf("failed to get a shared todo, got: %+v, %v", got, err)
+		}
+		if _, err := sut.Get(asErin, inbox.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting a todo that isn't shared, got: %v", err)
+		}
+		if _, err := patchTodo(asErin, sut, todo.Id, models.TodoPatch{Done: models.Some(true)}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound patching as a viewer, got: %v", err)
+		}
+		if _, err := trashTodo(asErin, sut, todo.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting as a viewer, got: %v", err)
+		}
+		if _, err := sut.Create(asErin, models.Todo{Title: "nope", ListId: list.Id}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error adding to a list as a viewer, got: %v", err)
+		}
+		lists, err := sut.GetLists(asErin)
+		if err != nil || len(lists) != 2 {
+			t.Fatalf("expected the shared list along with the inbox, got: %+v, %v", lists, err)
+		}
+
+		// editors change the todos, which stay the owner's
+		if _, err := sut.SetMemberRole(asErin, list.Id, erin.Id, "editor"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound changing a role as a member, got: %v", err)
+		}
+		if member, err := sut.SetMemberRole(asDave, list.Id, erin.Id, "editor"); err != nil || member.Role != "editor" {
+			t.Fatalf("failed to change role, got: %+v, %v", member, err)
+		}
+		if got, err := patchTodo(asErin, sut, todo.Id, models.TodoPatch{Done: models.Some(true)}); err != nil || !got.Done {
+			t.Fatalf("failed to patch as an editor, got: %+v, %v", got, err)
+		}
+		created, err := sut.Create(asErin, models.Todo{Title: "by erin", ListId: list.Id})
+		if err != nil || created.OwnerId != dave.Id {
+			t.Fatalf("expected the todo to belong to the owner of the list, got: %+v, %v", created, err)
+		}
+		if _, err := sut.RenameList(asErin, list.Id, "mine"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound renaming a shared list, got: %v", err)
+		}
+
+		// with a role that only lets them read, the list membership still
+		// decides, but their own todos are out of reach
+		own, err := sut.Create(asErin, models.Todo{Title: "erin's"})
+		if err != nil {
+			t.Fatalf("failed to create new todo, %v", err)
+		}
+		asErinViewer := asViewer(ctx, erin.Id)
+		if got, err := patchTodo(asErinViewer, sut, todo.Id, models.TodoPatch{Done: models.Some(false)}); err != nil || got.Done {
+			t.Fatalf("failed to patch a shared todo with a viewer's role, got: %+v, %v", got, err)
+		}
+		if _, err := sut.Create(asErinViewer, models.Todo{Title: "by a viewer", ListId: list.Id}); err != nil {
+			t.Fatalf("failed to add to a shared list with a viewer's role, %v", err)
+		}
+		if _, err := patchTodo(asErinViewer, sut, own.Id, models.TodoPatch{Done: models.Some(true)}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound patching one's own todo with a viewer's role, got: %v", err)
+		}
+		if _, err := sut.Create(asErinViewer, models.Todo{Title: "nope"}); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error adding to one's inbox with a viewer's role, got: %v", err)
+		}
+		if _, err := sut.Get(asErinViewer, own.Id); err != nil {
+			t.Fatalf("expected one's own todo to stay readable, got: %v", err)
+		}
+		members, err := sut.ListMembers(asErin, list.Id)
+		if err != nil || len(members) != 2 || members[0].Role != models.OwnerRole || members[0].UserId != dave.Id {
+			t.Fatalf("unexpected members, got: %+v, %v", members, err)
+		}
+		if _, err := sut.ListMembers(asFrank, list.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound listing the members as a stranger, got: %v", err)
+		}
+
+		// the owner hands the list over along with its todos and their tags
+		if _, err := sut.TransferList(asDave, list.Id, frank.Id); !errors.As(err, &validationErr) {
+			t.Fatalf("expected a validation error transferring to a non-member, got: %v", err)
+		}
+		if _, err := sut.TransferList(asErin, list.Id, erin.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound transferring as a member, got: %v", err)
+		}
+		transferred, err := sut.TransferList(asDave, list.Id, erin.Id)
+		if err != nil || transferred.OwnerId != erin.Id {
+			t.Fatalf("failed to transfer list, got: %+v, %v", transferred, err)
+		}
+		if got, err := sut.Get(asErin, todo.Id); err != nil || got.OwnerId != erin.Id || !slices.Equal(got.Tags, []string{"team"}) {
+			t.Fatalf("expected the todo to follow the list, got: %+v, %v", got, err)
+		}
+		tags, err := sut.ListTags(asErin)
+		if err != nil || len(tags) != 1 || tags[0].Name != "team" || tags[0].TodoCount != 1 {
+			t.Fatalf("expected the new owner to get the tags, got: %+v, %v", tags, err)
+		}
+		members, err = sut.ListMembers(asDave, list.Id)
+		if err != nil || len(members) != 2 || members[0].UserId != erin.Id || members[1].UserId != dave.Id || members[1].Role != "editor" {
+			t.Fatalf("unexpected members after the transfer, got: %+v, %v", members, err)
+		}
+
+		if err := sut.RemoveMember(asFrank, list.Id, dave.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound removing a member as a stranger, got: %v", err)
+		}
+		if err := sut.RemoveMember(asDave, list.Id, dave.Id); err != nil {
+			t.Fatalf("failed to leave the list, %v", err)
+		}
+		if _, err := sut.GetList(asDave, list.Id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after leaving the list, got: %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := sut.Get(ctx, "1986"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound getting a missing todo, got: %v", err)
+		}
+		if _, err := replaceTodo(ctx, sut, "1986", models.Todo{Title: "missing"}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound updating a missing todo, got: %v", err)
+		}
+		if _, err := patchTodo(ctx, sut, "1986", models.TodoPatch{Done: models.Some(true)}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound patching a missing todo, got: %v", err)
+		}
+		if _, err := trashTodo(ctx, sut, "1986"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting a missing todo, got: %v", err)
+		}
+		if _, err := sut.Get(ctx, "not-a-number"); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected ErrInvalid getting a todo with a malformed id, got: %v", err)
+		}
+	})
+}
+
+// replaceTodo, patchTodo and trashTodo change todos the way the handlers do.
+func replaceTodo(ctx context.Context, db *DB, id string, todo models.Todo) (models.Todo, error) {
+	return db.UpdateFunc(ctx, id, func(models.Todo) (models.Todo, error) { return todo, nil })
+}
+
+func patchTodo(ctx context.Context, db *DB, id string, p models.TodoPatch) (models.Todo, error) {
+	return db.UpdateFunc(ctx, id, func(todo models.Todo) (models.Todo, error) { return p.Apply(todo), nil })
+}
+
+func trashTodo(ctx context.Context, db *DB, id string) (int64, error) {
+	return db.DeleteFunc(ctx, id, func(models.Todo) error { return nil })
+}
+
+// asViewer returns the context of a request by the user with a viewer's role
+// to a route that lets list membership decide writes.
+func asViewer(ctx context.Context, userId string) (viewer context.Context) {
+	req := httptest.NewRequest(http.MethodPatch, "/todos/1", nil)
+	req = req.WithContext(middleware.WithPrincipal(ctx, middleware.Principal{UserId: userId, Roles: []string{middleware.RoleViewer}}))
+	middleware.SharedWrites(middleware.DefaultPolicy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	return viewer
 }
 
 func startPostgresContainer(t *testing.T) string {
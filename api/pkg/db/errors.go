@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/puddle/v2"
+)
+
+// Sentinel errors returned by DB methods. Callers should test for them with
+// errors.Is, the underlying driver error is wrapped alongside for logging.
+var (
+	// ErrNotFound means no row matched the given id.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the change violates a uniqueness or foreign key
+	// constraint.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid means the input was rejected by the database, e.g. an id
+	// with the wrong syntax or a value that fails a check constraint.
+	ErrInvalid = errors.New("invalid input")
+	// ErrUnavailable means the database could not be reached.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// foreignKeyFields names the input field behind a foreign key, a violation
+// means the field refers to a row that doesn't exist, which is reported to
+// the client as a validation error rather than a conflict.
+var foreignKeyFields = map[string]string{
+	"todos_list_id_fkey":    "listId",
+	"todos_parent_id_fkey":  "parentId",
+	"api_keys_user_id_fkey": "userId",
+}
+
+// translateError maps driver errors onto the package's sentinel errors.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	// leave cancellations alone so callers can tell them apart from outages
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch field := foreignKeyFields[pgErr.ConstraintName]; {
+		case pgErr.Code == "23503" && field != "":
+			v := &models.ValidationError{}
+			v.Add(field, "does not exist")
+			return v
+		case pgErr.Code == "23505", // unique_violation
+			pgErr.Code == "23503", // foreign_key_violation
+			pgErr.Code == "23P01": // exclusion_violation
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case pgErr.Code == "23502", // not_null_violation
+			pgErr.Code == "23514", // check_violation
+			pgErr.Code == "22P02", // invalid_text_representation
+			pgErr.Code == "22003", // numeric_value_out_of_range
+			pgErr.Code == "22001", // string_data_right_truncation
+			pgErr.Code == "22007", // invalid_datetime_format
+			pgErr.Code == "22008": // datetime_field_overflow
+			return fmt.Errorf("%w: %w", ErrInvalid, err)
+		case pgErr.Code[:2] == "08", // connection_exception
+			pgErr.Code[:2] == "53", // insufficient_resources
+			pgErr.Code == "57P01",  // admin_shutdown
+			pgErr.Code == "57P03":  // cannot_connect_now
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return err
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err) ||
+		errors.Is(err, puddle.ErrClosedPool) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return err
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"time"
+
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// KeyPrefix starts every API key, so leaked keys are easy to spot.
+const KeyPrefix = "todo_"
+
+// keyLastUsedInterval is how often the last use of a key is recorded, so
+// that authenticating doesn't write on every request.
+const keyLastUsedInterval = time.Minute
+
+// keyColumns are the columns scanKey reads, in order.
+const keyColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at"
+
+func scanKey(row pgx.Row) (key models.APIKey, err error) {
+	err = row.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &key.Scopes, &key.ExpiresAt, &key.LastUsedAt,
+		&key.RevokedAt, &key.CreatedAt)
+	return key, err
+}
+
+// hashKey returns the hash of an API key as it is stored.
+func hashKey(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// MintKey creates an API key for the user of key and returns it along with
+// its secret, which can't be recovered later.
+func (db *DB) MintKey(ctx context.Context, key models.APIKey) (minted models.APIKey, secret string, err error) {
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(db.now()) {
+		var v models.ValidationError
+		v.Add("expiresAt", "must be in the future")
+		return models.APIKey{}, "", v.Err()
+	}
+
+	secret = KeyPrefix + rand.Text()
+	minted, err = scanKey(db.pool.QueryRow(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expires_at)
+  VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+keyColumns,
+		key.UserId, key.Name, secret[:len(KeyPrefix)+4], hashKey(secret), key.Scopes, key.ExpiresAt))
+	if err != nil {
+		return models.APIKey{}, "", translateError(err)
+	}
+	return minted, secret, nil
+}
+
+// ListKeys returns the API keys of the user with userId, or of every user if
+// it is empty, newest first.
+func (db *DB) ListKeys(ctx context.Context, userId string) (keys []models.APIKey, err error) {
+	rows, err := db.pool.Query(ctx,
+		"SELECT "+keyColumns+" FROM api_keys WHERE NULLIF($1, '') IS NULL OR user_id = NULLIF($1, '')::integer ORDER BY id DESC",
+		userId)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	keys, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.APIKey, error) {
+		return scanKey(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return keys, nil
+}
+
+// RevokeKey revokes the API key with id, it can't be used from then on.
+// Revoking a key twice keeps the time of the first.
+func (db *DB) RevokeKey(ctx context.Context, id string) error {
+	commandTag, err := db.pool.Exec(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1", id, db.now())
+	if err != nil {
+		return translateError(err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the principal of the API key secret and records that
+// it was used. Unknown, expired or revoked keys fail with ErrNotFound.
+func (db *DB) Authenticate(ctx context.Context, secret string) (p middleware.Principal, err error) {
+	now := db.now()
+	var role string
+	err = db.pool.QueryRow(ctx, `WITH key AS (
+    SELECT id, user_id, scopes, last_used_at FROM api_keys
+    WHERE hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+  ), used AS (
+    UPDATE api_keys SET last_used_at = $2 FROM key
+    WHERE api_keys.id = key.id AND (key.last_used_at IS NULL OR key.last_used_at <= $3)
+  )
+  SELECT key.user_id, users.role, key.scopes FROM key JOIN users ON users.id = key.user_id`,
+		hashKey(secret), now, now.Add(-keyLastUsedInterval),
+	).Scan(&p.UserId, &role, &p.Scopes)
+	if err != nil {
+		return p, translateError(err)
+	}
+	p.Roles = []string{role}
+	return p, nil
+}
@@ -0,0 +1,590 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultListLimit is the page size used when ListOptions.Limit is 0.
+	DefaultListLimit = 50
+	// MaxListLimit is the largest page size List returns.
+	MaxListLimit = 500
+)
+
+// ListOptions selects a page of todos.
+type ListOptions struct {
+	// Limit is the maximum number of todos to return, DefaultListLimit if 0.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first.
+	Cursor string
+	// Filter restricts which todos are listed.
+	Filter Filter
+	// Sort orders the todos, by creation time if empty.
+	Sort Sort
+}
+
+// PageLimit returns the effective page size for the options.
+func (opts ListOptions) PageLimit() int {
+	switch {
+	case opts.Limit <= 0:
+		return DefaultListLimit
+	case opts.Limit > MaxListLimit:
+		return MaxListLimit
+	default:
+		return opts.Limit
+	}
+}
+
+// Filter restricts a listing to the todos matching every condition that is
+// set. Zero values match everything.
+type Filter struct {
+	// ListId matches the todos in one list.
+	ListId string
+	Done   *bool
+	// CreatedAfter is an inclusive lower bound on the creation time.
+	CreatedAfter time.Time
+	// CreatedBefore is an exclusive upper bound on the creation time.
+	CreatedBefore time.Time
+	// TitleContains matches titles containing the text, ignoring case.
+	TitleContains string
+	// Priorities matches todos with any of the priorities.
+	Priorities []models.Priority
+	// DueAfter is an inclusive lower bound on the due date, and DueBefore an
+	// exclusive upper bound. Todos without a due date never match either.
+	DueAfter  time.Time
+	DueBefore time.Time
+	// Overdue matches todos that are, or aren't, open past their due date.
+	Overdue *bool
+	// Tags matches todos with any of the tags, or with all of them if
+	// AllTags is set.
+	Tags    []string
+	AllTags bool
+	// Archived matches todos that are, or aren't, archived.
+	Archived *bool
+	// Trashed lists the todos in the trash instead of the others.
+	Trashed bool
+}
+
+// Matches reports whether todo passes the filter, now decides which todos
+// are overdue.
+func (f Filter) Matches(todo models.Todo, now time.Time) bool {
+	switch {
+	case f.Trashed != (todo.DeletedAt != nil):
+		return false
+	case f.ListId != "" && todo.ListId != f.ListId:
+		return false
+	case f.Done != nil && todo.Done != *f.Done:
+		return false
+	case !f.CreatedAfter.IsZero() && todo.CreatedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !todo.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case f.TitleContains != "" && !strings.Contains(strings.ToLower(todo.Title), strings.ToLower(f.TitleContains)):
+		return false
+	case len(f.Priorities) > 0 && !slices.Contains(f.Priorities, todo.Priority):
+		return false
+	case !f.DueAfter.IsZero() && (todo.DueAt == nil || todo.DueAt.Before(f.DueAfter)):
+		return false
+	case !f.DueBefore.IsZero() && (todo.DueAt == nil || !todo.DueAt.Before(f.DueBefore)):
+		return false
+	case f.Overdue != nil && todo.IsOverdue(now) != *f.Overdue:
+		return false
+	case f.Archived != nil && (todo.ArchivedAt != nil) != *f.Archived:
+		return false
+	case len(f.Tags) > 0 && f.AllTags && !containsAll(todo.Tags, f.Tags):
+		return false
+	case len(f.Tags) > 0 && !f.AllTags && !slices.ContainsFunc(f.Tags, func(tag string) bool { return slices.Contains(todo.Tags, tag) }):
+		return false
+	default:
+		return true
+	}
+}
+
+// SortField orders a listing by one field, ascending unless Desc is set.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Sort is a list of fields to order by, the first one taking precedence.
+// Todos that compare equal on every field are ordered by id.
+type Sort []SortField
+
+// sortKey describes a field todos can be sorted by.
+type sortKey struct {
+	column  string
+	value   func(todo models.Todo) any
+	compare func(a, b models.Todo) int
+	// field carries the value of the field in cursors
+	field cursorField
+}
+
+// cursorField reads the field of a todo a cursor carries, copies it to the
+// todo of a cursor, and checks and sets it when the cursor is decoded.
+type cursorField struct {
+	get  func(todo models.Todo) any
+	copy func(dst *models.Todo, src models.Todo)
+	set  func(todo *models.Todo, data json.RawMessage) error
+}
+
+// fieldOf returns the cursorField for the field ptr points to, valid rejects
+// values that can't come from a todo.
+func fieldOf[T any](ptr func(todo *models.Todo) *T, valid func(v T) bool) cursorField {
+	return cursorField{
+		get:  func(todo models.Todo) any { return *ptr(&todo) },
+		copy: func(dst *models.Todo, src models.Todo) { *ptr(dst) = *ptr(&src) },
+		set: func(todo *models.Todo, data json.RawMessage) error {
+			var v T
+			if err := json.Unmarshal(data, &v); err != nil {
+				return err
+			}
+			if valid != nil && !valid(v) {
+				return fmt.Errorf("invalid value %s", data)
+			}
+			*ptr(todo) = v
+			return nil
+		},
+	}
+}
+
+// sortKeys whitelists the fields a listing can be sorted by.
+var sortKeys = map[string]sortKey{
+	"createdAt": {
+		column:  "created_at",
+		value:   func(todo models.Todo) any { return todo.CreatedAt },
+		compare: func(a, b models.Todo) int { return a.CreatedAt.Compare(b.CreatedAt) },
+		field:   fieldOf(func(todo *models.Todo) *time.Time { return &todo.CreatedAt }, nil),
+	},
+	// position is the order todos are arranged in by hand, see DB.Move
+	"position": {
+		column:  "position",
+		value:   func(todo models.Todo) any { return todo.Position },
+		compare: func(a, b models.Todo) int { return cmp.Compare(a.Position, b.Position) },
+		field: fieldOf(func(todo *models.Todo) *string { return &todo.Position }, func(position string) bool {
+			return len(position) <= MaxPositionLength
+		}),
+	},
+	"title": {
+		column:  "title",
+		value:   func(todo models.Todo) any { return todo.Title },
+		compare: func(a, b models.Todo) int { return cmp.Compare(a.Title, b.Title) },
+		field: fieldOf(func(todo *models.Todo) *string { return &todo.Title }, func(title string) bool {
+			return utf8.RuneCountInString(title) <= models.MaxTitleLength
+		}),
+	},
+	"done": {
+		column: "done",
+		value:  func(todo models.Todo) any { return todo.Done },
+		compare: func(a, b models.Todo) int {
+			return cmp.Compare(boolRank(a.Done), boolRank(b.Done))
+		},
+		field: fieldOf(func(todo *models.Todo) *bool { return &todo.Done }, nil),
+	},
+	"priority": {
+		column:  "priority",
+		value:   func(todo models.Todo) any { return todo.Priority.Rank() },
+		compare: func(a, b models.Todo) int { return cmp.Compare(a.Priority.Rank(), b.Priority.Rank()) },
+		field:   fieldOf(func(todo *models.Todo) *models.Priority { return &todo.Priority }, models.Priority.Valid),
+	},
+	// todos without a due date sort after every date, as if due at infinity,
+	// so that the comparisons paging relies on never see a NULL
+	"dueAt": {
+		column: "COALESCE(due_at, 'infinity')",
+		value: func(todo models.Todo) any {
+			if todo.DueAt == nil {
+				return pgtype.Timestamptz{InfinityModifier: pgtype.Infinity, Valid: true}
+			}
+			return *todo.DueAt
+		},
+		compare: func(a, b models.Todo) int {
+			switch {
+			case a.DueAt == nil && b.DueAt == nil:
+				return 0
+			case a.DueAt == nil:
+				return 1
+			case b.DueAt == nil:
+				return -1
+			default:
+				return a.DueAt.Compare(*b.DueAt)
+			}
+		},
+		field: fieldOf(func(todo *models.Todo) **time.Time { return &todo.DueAt }, nil),
+	},
+	"updatedAt": {
+		column:  "updated_at",
+		value:   func(todo models.Todo) any { return todo.UpdatedAt },
+		compare: func(a, b models.Todo) int { return a.UpdatedAt.Compare(b.UpdatedAt) },
+		field:   fieldOf(func(todo *models.Todo) *time.Time { return &todo.UpdatedAt }, nil),
+	},
+}
+
+// idKey breaks ties so that every todo has a unique position in a listing.
+var idKey = sortKey{
+	column:  "id",
+	value:   func(todo models.Todo) any { return todo.Id },
+	compare: func(a, b models.Todo) int { return compareIDs(a.Id, b.Id) },
+	field: fieldOf(func(todo *models.Todo) *string { return &todo.Id }, func(id string) bool {
+		n, err := strconv.ParseInt(id, 10, 64)
+		return err == nil && n > 0 && strconv.FormatInt(n, 10) == id
+	}),
+}
+
+// defaultSort lists todos in the order they were created.
+var defaultSort = Sort{{Field: "createdAt"}}
+
+// SortFields returns the names of the fields a listing can be sorted by.
+func SortFields() []string {
+	fields := make([]string, 0, len(sortKeys))
+	for field := range sortKeys {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// ParseSort parses a comma separated list of field names, each prefixed with
+// "-" to sort in descending order, e.g. "-createdAt,title".
+func ParseSort(s string) (Sort, error) {
+	var sort Sort
+	for name := range strings.SplitSeq(s, ",") {
+		field := SortField{Field: strings.TrimPrefix(name, "-"), Desc: strings.HasPrefix(name, "-")}
+		if _, ok := sortKeys[field.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q, must be one of %s", field.Field, strings.Join(SortFields(), ", "))
+		}
+		if slices.ContainsFunc(sort, func(f SortField) bool { return f.Field == field.Field }) {
+			return nil, fmt.Errorf("cannot sort by %q more than once", field.Field)
+		}
+		sort = append(sort, field)
+	}
+	return sort, nil
+}
+
+// String returns the sort in the form accepted by ParseSort.
+func (s Sort) String() string {
+	names := make([]string, len(s))
+	for i, field := range s {
+		names[i] = field.Field
+		if field.Desc {
+			names[i] = "-" + field.Field
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Compare orders todos the way List does, returning a negative number when
+// a comes before b.
+func (s Sort) Compare(a, b models.Todo) int {
+	for _, k := range s.keys() {
+		c := k.compare(a, b)
+		if k.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+type orderedKey struct {
+	sortKey
+	desc bool
+}
+
+// keys resolves the sort to the keys it orders by, ending with the id.
+func (s Sort) keys() []orderedKey {
+	if len(s) == 0 {
+		s = defaultSort
+	}
+	keys := make([]orderedKey, 0, len(s)+1)
+	for _, field := range s {
+		keys = append(keys, orderedKey{sortKeys[field.Field], field.Desc})
+	}
+	return append(keys, orderedKey{sortKey: idKey})
+}
+
+func (s Sort) validate() error {
+	for _, field := range s {
+		if _, ok := sortKeys[field.Field]; !ok {
+			return fmt.Errorf("%w: cannot sort by %q", ErrInvalid, field.Field)
+		}
+	}
+	return nil
+}
+
+// Page is one page of a listing. NextCursor is empty on the last page.
+type Page struct {
+	Todos      []models.Todo
+	NextCursor string
+}
+
+// Cursor is the position of a todo in a listing. It holds the values of the
+// fields the listing is sorted by and the id of the last todo of a page, and
+// is handed to clients as an opaque string.
+type Cursor struct {
+	Sort Sort
+	// Last has only the fields the listing is sorted by and the id set.
+	Last models.Todo
+}
+
+// encodedCursor is the JSON form of a Cursor, Values has a value for each
+// key of the sort, ending with the id.
+type encodedCursor struct {
+	Sort   string            `json:"s,omitempty"`
+	Values []json.RawMessage `json:"v"`
+}
+
+var errCursorSort = errors.New("cursor belongs to a listing with a different sort")
+
+// CursorAfter returns the cursor for the page following todo in a listing
+// ordered by sort.
+func CursorAfter(todo models.Todo, sort Sort) Cursor {
+	var last models.Todo
+	for _, k := range sort.keys() {
+		k.field.copy(&last, todo)
+	}
+	return Cursor{Sort: sort, Last: last}
+}
+
+// Encode returns the opaque form of the cursor.
+func (c Cursor) Encode() string {
+	enc := encodedCursor{Sort: c.Sort.String()}
+	for _, k := range c.Sort.keys() {
+		value, _ := json.Marshal(k.field.get(c.Last))
+		enc.Values = append(enc.Values, value)
+	}
+	b, _ := json.Marshal(enc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a cursor returned by Encode for a listing ordered by
+// sort, checking that it has a valid value for each of the sort's fields and
+// the id.
+func DecodeCursor(s string, sort Sort) (Cursor, error) {
+	if err := sort.validate(); err != nil {
+		return Cursor{}, err
+	}
+	var enc encodedCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	if err := json.Unmarshal(b, &enc); err != nil {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	if enc.Sort != sort.String() {
+		return Cursor{}, fmt.Errorf("%w: %w", ErrInvalid, errCursorSort)
+	}
+
+	keys := sort.keys()
+	if len(enc.Values) != len(keys) {
+		return Cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+	}
+	c := Cursor{Sort: sort}
+	for i, k := range keys {
+		if err := k.field.set(&c.Last, enc.Values[i]); err != nil {
+			return Cursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalid)
+		}
+	}
+	return c, nil
+}
+
+// List returns a page of todos using keyset pagination, so pages stay
+// consistent while todos are added and deep pages are as cheap as the first.
+func (db *DB) List(ctx context.Context, opts ListOptions) (page Page, err error) {
+	limit := opts.PageLimit()
+	if err := opts.Sort.validate(); err != nil {
+		return page, err
+	}
+
+	var after *Cursor
+	if opts.Cursor != "" {
+		cursor, err := DecodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, err
+		}
+		after = &cursor
+	}
+
+	where, args := listConditions(opts.Filter, opts.Sort, after, db.now())
+	args = append(args, owner(ctx))
+	where = append(where, todoScope(len(args), readAccess))
+	query := "SELECT " + todoColumns + " FROM todos"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to find out whether there is another page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d", orderBy(opts.Sort), len(args))
+
+	rows, err := db.pool.Query(ctx, query, args...)
+	if err != nil {
+		return page, fmt.Errorf("executing list query: %w", translateError(err))
+	}
+	defer rows.Close()
+
+	page.Todos = []models.Todo{}
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return Page{}, fmt.Errorf("scanning todo: %w", err)
+		}
+		page.Todos = append(page.Todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, translateError(err)
+	}
+
+	if len(page.Todos) > limit {
+		page.Todos = page.Todos[:limit]
+		page.NextCursor = CursorAfter(page.Todos[limit-1], opts.Sort).Encode()
+	}
+	return page, nil
+}
+
+// listConditions compiles the filter, and the position to continue from, to
+// SQL conditions. Values are always passed as arguments, never inlined.
+// Whether a todo is overdue is decided by now, the application's clock
+// (Config.Now), rather than by NOW() on the database server.
+func listConditions(f Filter, sort Sort, after *Cursor, now time.Time) (where []string, args []any) {
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Trashed {
+		where = append(where, "deleted_at IS NOT NULL")
+	} else {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if f.ListId != "" {
+		where = append(where, "list_id = "+arg(f.ListId))
+	}
+	if f.Done != nil {
+		where = append(where, "done = "+arg(*f.Done))
+	}
+	if !f.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(f.CreatedBefore))
+	}
+	if f.TitleContains != "" {
+		where = append(where, "title ILIKE '%' || "+arg(escapeLike(f.TitleContains))+" || '%'")
+	}
+	if len(f.Priorities) > 0 {
+		ranks := make([]int, len(f.Priorities))
+		for i, p := range f.Priorities {
+			ranks[i] = p.Rank()
+		}
+		where = append(where, "priority = ANY("+arg(ranks)+")")
+	}
+	if !f.DueAfter.IsZero() {
+		where = append(where, "due_at >= "+arg(f.DueAfter))
+	}
+	if !f.DueBefore.IsZero() {
+		where = append(where, "due_at < "+arg(f.DueBefore))
+	}
+	if f.Overdue != nil {
+		overdue := "(due_at IS NOT NULL AND due_at < " + arg(now) + " AND NOT done)"
+		if !*f.Overdue {
+			overdue = "NOT " + overdue
+		}
+		where = append(where, overdue)
+	}
+	if f.Archived != nil {
+		if *f.Archived {
+			where = append(where, "archived_at IS NOT NULL")
+		} else {
+			where = append(where, "archived_at IS NULL")
+		}
+	}
+	if len(f.Tags) > 0 {
+		tagged := " FROM todo_tags JOIN tags ON tags.id = todo_tags.tag_id" +
+			" WHERE todo_tags.todo_id = todos.id AND tags.name = ANY(" + arg(f.Tags) + ")"
+		if f.AllTags {
+			distinct := slices.Compact(slices.Sorted(slices.Values(f.Tags)))
+			where = append(where, "(SELECT count(*)"+tagged+") = "+arg(len(distinct)))
+		} else {
+			where = append(where, "EXISTS (SELECT 1"+tagged+")")
+		}
+	}
+
+	// rows after the cursor: greater on the first key, or equal on it and
+	// greater on the second, and so on, with "greater" flipped for desc keys
+	if after != nil {
+		keys := sort.keys()
+		alternatives := make([]string, len(keys))
+		for i, k := range keys {
+			var terms []string
+			for _, prev := range keys[:i] {
+				terms = append(terms, prev.column+" = "+arg(prev.value(after.Last)))
+			}
+			op := " > "
+			if k.desc {
+				op = " < "
+			}
+			terms = append(terms, k.column+op+arg(k.value(after.Last)))
+			alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+		}
+		where = append(where, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	return where, args
+}
+
+func orderBy(sort Sort) string {
+	keys := sort.keys()
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		terms[i] = k.column
+		if k.desc {
+			terms[i] += " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func containsAll(tags, want []string) bool {
+	for _, tag := range want {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareIDs orders ids numerically, like the integer column they come from.
+func compareIDs(a, b string) int {
+	x, errX := strconv.ParseInt(a, 10, 64)
+	y, errY := strconv.ParseInt(b, 10, 64)
+	if errX != nil || errY != nil {
+		return cmp.Compare(a, b)
+	}
+	return cmp.Compare(x, y)
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
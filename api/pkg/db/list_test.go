@@ -0,0 +1,173 @@
+package db_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "example.com/todos/pkg/db"
+	"example.com/todos/pkg/models"
+)
+
+func TestCursor(t *testing.T) {
+	todo := models.Todo{
+		Id: "42", Title: "Buy milk", Description: "semi-skimmed", Priority: models.PriorityHigh,
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+	}
+
+	got, err := DecodeCursor(CursorAfter(todo, nil).Encode(), nil)
+	if err != nil {
+		t.Fatalf("failed to decode cursor, %v", err)
+	}
+	// only the sort fields and the id are carried
+	want := models.Todo{Id: todo.Id, CreatedAt: todo.CreatedAt}
+	if !reflect.DeepEqual(got.Last, want) {
+		t.Fatalf("cursor did not round trip, expected: %+v, got: %+v", want, got.Last)
+	}
+
+	sort := Sort{{Field: "priority", Desc: true}, {Field: "title"}}
+	got, err = DecodeCursor(CursorAfter(todo, sort).Encode(), sort)
+	if err != nil {
+		t.Fatalf("failed to decode cursor, %v", err)
+	}
+	want = models.Todo{Id: todo.Id, Title: todo.Title, Priority: todo.Priority}
+	if !reflect.DeepEqual(got.Last, want) {
+		t.Fatalf("cursor did not round trip, expected: %+v, got: %+v", want, got.Last)
+	}
+
+	// cursors with values that can't come from a todo are rejected
+	tests := []struct {
+		sort, cursor string
+	}{
+		{"priority", `{"s":"priority","v":["high"]}`},
+		{"priority", `{"s":"priority","v":["high","42","7"]}`},
+		{"priority", `{"s":"priority","v":["highest","42"]}`},
+		{"priority", `{"s":"priority","v":[2,"42"]}`},
+		{"priority", `{"s":"priority","v":["high","forty-two"]}`},
+		{"priority", `{"s":"priority","v":["high","042"]}`},
+		{"priority", `{"s":"priority","v":["high",null]}`},
+		{"title", `{"s":"title","v":["` + strings.Repeat("a", models.MaxTitleLength+1) + `","42"]}`},
+	}
+	for _, tt := range tests {
+		sort, _ := ParseSort(tt.sort)
+		if _, err := DecodeCursor(base64.RawURLEncoding.EncodeToString([]byte(tt.cursor)), sort); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for cursor %s, got %v", tt.cursor, err)
+		}
+	}
+
+	if _, err := DecodeCursor(CursorAfter(todo, nil).Encode(), Sort{{Field: "title"}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected ErrInvalid for a cursor with a different sort, got %v", err)
+	}
+
+	for _, bad := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := DecodeCursor(bad, nil); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for cursor %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestListOptions_PageLimit(t *testing.T) {
+	tests := []struct {
+		limit, want int
+	}{
+		{0, DefaultListLimit},
+		{-1, DefaultListLimit},
+		{10, 10},
+		{MaxListLimit + 1, MaxListLimit},
+	}
+	for _, tt := range tests {
+		if got := (ListOptions{Limit: tt.limit}).PageLimit(); got != tt.want {
+			t.Errorf("PageLimit() for %d = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	sort, err := ParseSort("-createdAt,title")
+	if err != nil {
+		t.Fatalf("failed to parse sort, %v", err)
+	}
+	want := Sort{{Field: "createdAt", Desc: true}, {Field: "title"}}
+	if !slices.Equal(sort, want) {
+		t.Fatalf("wrong sort, expected: %v, got: %v", want, sort)
+	}
+	if sort.String() != "-createdAt,title" {
+		t.Errorf("sort did not round trip, got: %s", sort)
+	}
+
+	for _, bad := range []string{"", "colour", "title,-title", "createdAt,", "--title"} {
+		if _, err := ParseSort(bad); err == nil {
+			t.Errorf("expected an error for sort %q", bad)
+		}
+	}
+}
+
+func TestSort_Compare(t *testing.T) {
+	now := time.Now()
+	todos := []models.Todo{
+		{Id: "10", Title: "b", CreatedAt: now},
+		{Id: "9", Title: "a", CreatedAt: now},
+		{Id: "2", Title: "a", Done: true, CreatedAt: now.Add(time.Hour)},
+	}
+	ids := func(sort Sort) []string {
+		sorted := slices.SortedFunc(slices.Values(todos), sort.Compare)
+		var ids []string
+		for _, todo := range sorted {
+			ids = append(ids, todo.Id)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"createdAt", []string{"9", "10", "2"}},
+		{"-createdAt", []string{"2", "9", "10"}},
+		{"title,-createdAt", []string{"2", "9", "10"}},
+		{"done,title", []string{"9", "10", "2"}},
+	}
+	for _, tt := range tests {
+		sort, err := ParseSort(tt.sort)
+		if err != nil {
+			t.Fatalf("failed to parse sort %q, %v", tt.sort, err)
+		}
+		if got := ids(sort); !slices.Equal(got, tt.want) {
+			t.Errorf("wrong order for %q, expected: %v, got: %v", tt.sort, tt.want, got)
+		}
+	}
+	if got := ids(nil); !slices.Equal(got, []string{"9", "10", "2"}) {
+		t.Errorf("wrong default order, got: %v", got)
+	}
+}
+
+func TestFilter_Matches(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	todo := models.Todo{Title: "Buy MILK", Done: true, CreatedAt: day}
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"empty", Filter{}, true},
+		{"done", Filter{Done: &yes}, true},
+		{"not done", Filter{Done: &no}, false},
+		{"after is inclusive", Filter{CreatedAfter: day}, true},
+		{"after", Filter{CreatedAfter: day.Add(time.Second)}, false},
+		{"before is exclusive", Filter{CreatedBefore: day}, false},
+		{"before", Filter{CreatedBefore: day.Add(time.Second)}, true},
+		{"title ignores case", Filter{TitleContains: "milk"}, true},
+		{"title", Filter{TitleContains: "bread"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(todo, day); got != tt.want {
+			t.Errorf("%s: Matches() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInboxDeleted is returned when deleting the inbox, which todos created
+// without a list rely on.
+var ErrInboxDeleted = errors.New("the inbox cannot be deleted")
+
+// listColumns are the columns scanList reads, in order.
+const listColumns = "id, owner_id, name, inbox, created_at, " +
+	"(SELECT count(*) FROM todos WHERE todos.list_id = lists.id AND NOT todos.done AND todos.archived_at IS NULL AND todos.deleted_at IS NULL), " +
+	"(SELECT count(*) FROM todos WHERE todos.list_id = lists.id AND todos.done AND todos.archived_at IS NULL AND todos.deleted_at IS NULL)"
+
+func scanList(row pgx.Row) (list models.List, err error) {
+	err = row.Scan(&list.Id, &list.OwnerId, &list.Name, &list.Inbox, &list.CreatedAt, &list.OpenCount, &list.DoneCount)
+	return list, err
+}
+
+// GetLists returns the caller's lists, the inbox first and the others by
+// name.
+func (db *DB) GetLists(ctx context.Context) (lists []models.List, err error) {
+	rows, err := db.pool.Query(ctx,
+		"SELECT "+listColumns+" FROM lists WHERE "+listScope(1, readAccess)+" ORDER BY inbox DESC, name, id", owner(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	lists, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.List, error) {
+		return scanList(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return lists, nil
+}
+
+func (db *DB) CreateList(ctx context.Context, name string) (list models.List, err error) {
+	list, err = scanList(db.pool.QueryRow(ctx, "INSERT INTO lists (owner_id, name) VALUES ("+ownerOrDefault(2)+", $1) RETURNING "+listColumns,
+		name, owner(ctx)))
+	return list, translateError(err)
+}
+
+func (db *DB) GetList(ctx context.Context, id string) (list models.List, err error) {
+	list, err = scanList(db.pool.QueryRow(ctx, "SELECT "+listColumns+" FROM lists WHERE id = $1 AND "+listScope(2, readAccess), id, owner(ctx)))
+	return list, translateError(err)
+}
+
+func (db *DB) RenameList(ctx context.Context, id string, name string) (list models.List, err error) {
+	list, err = scanList(db.pool.QueryRow(ctx, "UPDATE lists SET name = $1 WHERE id = $2 AND "+ownerScope("lists", 3)+" RETURNING "+listColumns,
+		name, id, owner(ctx)))
+	return list, translateError(err)
+}
+
+// DeleteList deletes a list after moving its todos to the owner's inbox. If
+// cascade is set the todos, and their subtasks, go to the trash as well, so
+// that they can still be restored.
+func (db *DB) DeleteList(ctx context.Context, id string, cascade bool) error {
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var inbox bool
+		var ownerId string
+		err := tx.QueryRow(ctx, "SELECT inbox, owner_id FROM lists WHERE id = $1 AND "+ownerScope("lists", 2)+" FOR UPDATE",
+			id, owner(ctx)).Scan(&inbox, &ownerId)
+		if err != nil {
+			return err
+		}
+		if inbox {
+			return fmt.Errorf("%w: %w", ErrConflict, ErrInboxDeleted)
+		}
+
+		if cascade {
+			_, err := tx.Exec(ctx, `WITH RECURSIVE subtree AS (
+    SELECT id, 1 AS depth FROM todos WHERE list_id = $1 AND deleted_at IS NULL
+    UNION ALL
+    SELECT todos.id, subtree.depth + 1
+    FROM todos JOIN subtree ON todos.parent_id = subtree.id
+    WHERE todos.deleted_at IS NULL AND subtree.depth < $2
+  )
+  UPDATE todos SET deleted_at = $3 WHERE id IN (SELECT id FROM subtree)`,
+				id, MaxDepth, db.now())
+			if err != nil {
+				return fmt.Errorf("trashing todos: %w", err)
+			}
+		}
+
+		// deleting the list would delete its todos too, those in the trash
+		// included
+		var inboxId string
+		err = tx.QueryRow(ctx, "SELECT id FROM lists WHERE inbox AND owner_id = $1", ownerId).Scan(&inboxId)
+		if err != nil {
+			return err
+		}
+		if err := moveTodos(ctx, tx, id, inboxId); err != nil {
+			return fmt.Errorf("moving todos to the inbox: %w", err)
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM lists WHERE id = $1", id)
+		return err
+	})
+	return translateError(err)
+}
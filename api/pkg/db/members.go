@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrInboxShared is returned when sharing or transferring an inbox, which
+	// is where the todos its owner creates without a list go.
+	ErrInboxShared = errors.New("the inbox cannot be shared or transferred")
+	// ErrSubtasksElsewhere is returned when transferring a list whose todos
+	// have subtasks or parents in other lists, which would end up with
+	// different owners.
+	ErrSubtasksElsewhere = errors.New("todos of the list have subtasks or parents in other lists, move them first")
+)
+
+// InvitationPrefix starts every invitation token, so they aren't mistaken
+// for API keys.
+const InvitationPrefix = "invite_"
+
+// DefaultInvitationTTL is how long invitations created without an expiry
+// can be accepted.
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+// invitationColumns are the columns scanInvitation reads, in order.
+const invitationColumns = "id, list_id, role, expires_at, accepted_by, accepted_at, created_at"
+
+func scanInvitation(row pgx.Row) (inv models.Invitation, err error) {
+	err = row.Scan(&inv.Id, &inv.ListId, &inv.Role, &inv.ExpiresAt, &inv.AcceptedBy, &inv.AcceptedAt, &inv.CreatedAt)
+	return inv, err
+}
+
+func scanMember(row pgx.Row) (member models.Member, err error) {
+	err = row.Scan(&member.ListId, &member.UserId, &member.Name, &member.Role, &member.CreatedAt)
+	return member, err
+}
+
+// ListMembers returns the owner of the list with id, with the role
+// models.OwnerRole, followed by the users it is shared with in the order they
+// joined. Every member can see the others.
+func (db *DB) ListMembers(ctx context.Context, listId string) (members []models.Member, err error) {
+	// the ORDER BY of a UNION can only name its columns, so the union is
+	// sorted outside of it
+	rows, err := db.pool.Query(ctx, `SELECT list_id, user_id, name, role, created_at FROM (
+  SELECT lists.id AS list_id, users.id AS user_id, users.name, 'owner' AS role, lists.created_at
+    FROM lists JOIN users ON users.id = lists.owner_id
+    WHERE lists.id = $1 AND `+listScope(2, readAccess)+`
+  UNION ALL
+  SELECT list_members.list_id, users.id, users.name, list_members.role, list_members.created_at
+    FROM list_members JOIN users ON users.id = list_members.user_id JOIN lists ON lists.id = list_members.list_id
+    WHERE list_members.list_id = $1 AND `+listScope(2, readAccess)+`
+) AS members
+ORDER BY role = 'owner' DESC, created_at, user_id`,
+		listId, owner(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	members, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Member, error) {
+		return scanMember(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(members) == 0 {
+		return nil, ErrNotFound
+	}
+	return members, nil
+}
+
+// SetMemberRole changes the role of a member of the caller's list.
+func (db *DB) SetMemberRole(ctx context.Context, listId, userId, role string) (member models.Member, err error) {
+	member, err = scanMember(db.pool.QueryRow(ctx, `UPDATE list_members SET role = $3
+  FROM lists, users
+  WHERE list_members.list_id = $1 AND list_members.user_id = $2
+    AND lists.id = list_members.list_id AND `+ownerScope("lists", 4)+` AND users.id = list_members.user_id
+  RETURNING list_members.list_id, users.id, users.name, list_members.role, list_members.created_at`,
+		listId, userId, role, owner(ctx)))
+	return member, translateError(err)
+}
+
+// RemoveMember stops sharing the list with the user. The owner can remove
+// any member, and members can leave by removing themselves.
+func (db *DB) RemoveMember(ctx context.Context, listId, userId string) error {
+	commandTag, err := db.pool.Exec(ctx, `DELETE FROM list_members USING lists
+  WHERE list_members.list_id = $1 AND list_members.user_id = $2 AND lists.id = list_members.list_id
+    AND ($3::integer IS NULL OR lists.owner_id = $3 OR list_members.user_id = $3)`,
+		listId, userId, owner(ctx))
+	if err != nil {
+		return translateError(err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Invite creates an invitation to the caller's list and returns it along
+// with its token, which can't be recovered later. Invitations without an
+// expiry expire after DefaultInvitationTTL.
+func (db *DB) Invite(ctx context.Context, listId string, inv models.Invitation) (created models.Invitation, token string, err error) {
+	now := db.now()
+	switch {
+	case inv.ExpiresAt.IsZero():
+		inv.ExpiresAt = now.Add(DefaultInvitationTTL)
+	case !inv.ExpiresAt.After(now):
+		var v models.ValidationError
+		v.Add("expiresAt", "must be in the future")
+		return models.Invitation{}, "", v.Err()
+	}
+
+	token = InvitationPrefix + rand.Text()
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var inbox bool
+		err := tx.QueryRow(ctx, "SELECT inbox FROM lists WHERE id = $1 AND "+ownerScope("lists", 2), listId, owner(ctx)).
+			Scan(&inbox)
+		if err != nil {
+			return err
+		}
+		if inbox {
+			return fmt.Errorf("%w: %w", ErrConflict, ErrInboxShared)
+		}
+		created, err = scanInvitation(tx.QueryRow(ctx,
+			"INSERT INTO list_invitations (list_id, role, hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING "+invitationColumns,
+			listId, inv.Role, hashKey(token), inv.ExpiresAt))
+		return err
+	})
+	if err != nil {
+		return models.Invitation{}, "", translateError(err)
+	}
+	return created, token, nil
+}
+
+// AcceptInvitation makes the caller a member of the list the token invites
+// to, or changes their role if they already are one. Each invitation can be
+// accepted once, tokens that don't exist, have expired or were used fail with
+// ErrNotFound.
+func (db *DB) AcceptInvitation(ctx context.Context, token string) (member models.Member, err error) {
+	userId := owner(ctx)
+	if userId == nil {
+		return models.Member{}, fmt.Errorf("%w: accepting an invitation needs a user", ErrInvalid)
+	}
+
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var listId, role, ownerId string
+		err := tx.QueryRow(ctx, `UPDATE list_invitations SET accepted_by = $2, accepted_at = $3
+  FROM lists
+  WHERE hash = $1 AND accepted_at IS NULL AND expires_at > $3 AND lists.id = list_invitations.list_id
+  RETURNING list_invitations.list_id, list_invitations.role, lists.owner_id`,
+			hashKey(token), userId, db.now()).Scan(&listId, &role, &ownerId)
+		if err != nil {
+			return err
+		}
+		if ownerId == userId {
+			var v models.ValidationError
+			v.Add("token", "invites to a list of your own")
+			return v.Err()
+		}
+
+		member, err = scanMember(tx.QueryRow(ctx, `INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, $3)
+  ON CONFLICT (list_id, user_id) DO UPDATE SET role = EXCLUDED.role
+  RETURNING list_id, user_id, (SELECT name FROM users WHERE id = $2), role, created_at`,
+			listId, userId, role))
+		return err
+	})
+	return member, translateError(err)
+}
+
+// TransferList makes a member the owner of the caller's list, along with its
+// todos, and the previous owner an editor of it. The tags of the todos are
+// replaced with the new owner's tags of the same names.
+func (db *DB) TransferList(ctx context.Context, listId, userId string) (list models.List, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var inbox bool
+		var ownerId string
+		err := tx.QueryRow(ctx, "SELECT inbox, owner_id FROM lists WHERE id = $1 AND "+ownerScope("lists", 2)+" FOR UPDATE",
+			listId, owner(ctx)).Scan(&inbox, &ownerId)
+		if err != nil {
+			return err
+		}
+		if inbox {
+			return fmt.Errorf("%w: %w", ErrConflict, ErrInboxShared)
+		}
+
+		// the new owner must be a member, and stops being one
+		var role string
+		err = tx.QueryRow(ctx, "DELETE FROM list_members WHERE list_id = $1 AND user_id = $2 RETURNING role",
+			listId, userId).Scan(&role)
+		if errors.Is(err, pgx.ErrNoRows) {
+			var v models.ValidationError
+			v.Add("userId", "must be a member of the list")
+			return v.Err()
+		}
+		if err != nil {
+			return err
+		}
+
+		var elsewhere bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (
+    SELECT 1 FROM todos child JOIN todos parent ON parent.id = child.parent_id
+    WHERE (child.list_id = $1) <> (parent.list_id = $1))`, listId).Scan(&elsewhere)
+		if err != nil {
+			return err
+		}
+		if elsewhere {
+			return fmt.Errorf("%w: %w", ErrConflict, ErrSubtasksElsewhere)
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO tags (owner_id, name)
+  SELECT DISTINCT $2::integer, tags.name
+  FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id JOIN tags ON tags.id = todo_tags.tag_id
+  WHERE todos.list_id = $1
+  ON CONFLICT (owner_id, name) DO NOTHING`, listId, userId)
+		if err != nil {
+			return fmt.Errorf("creating tags: %w", err)
+		}
+		_, err = tx.Exec(ctx, `UPDATE todo_tags SET tag_id = theirs.id
+  FROM todos, tags mine, tags theirs
+  WHERE todos.id = todo_tags.todo_id AND todos.list_id = $1
+    AND mine.id = todo_tags.tag_id AND theirs.owner_id = $2 AND theirs.name = mine.name`, listId, userId)
+		if err != nil {
+			return fmt.Errorf("replacing tags: %w", err)
+		}
+
+		// the todos follow through the cascading foreign key on (list_id, owner_id)
+		if _, err := tx.Exec(ctx, "UPDATE lists SET owner_id = $2 WHERE id = $1", listId, userId); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, 'editor')", listId, ownerId)
+		if err != nil {
+			return err
+		}
+		list, err = scanList(tx.QueryRow(ctx, "SELECT "+listColumns+" FROM lists WHERE id = $1", listId))
+		return err
+	})
+	return list, translateError(err)
+}
@@ -0,0 +1,90 @@
+package db_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	. "example.com/todos/pkg/db"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":      {Data: []byte("CREATE INDEX todos_done ON todos (done);")},
+		"0002_add_index.down.sql":    {Data: []byte("DROP INDEX todos_done;")},
+		"0001_create_todos.up.sql":   {Data: []byte("CREATE TABLE todos ();")},
+		"0001_create_todos.down.sql": {Data: []byte("DROP TABLE todos;")},
+		"0003_no_down.up.sql":        {Data: []byte("SELECT 1;")},
+	}
+
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatalf("failed to load migrations, %v", err)
+	}
+
+	if len(migrations) != 3 {
+		t.Fatalf("wrong number of migrations, expected: 3, got: %d", len(migrations))
+	}
+	for i, name := range []string{"create_todos", "add_index", "no_down"} {
+		if migrations[i].Version != int64(i+1) {
+			t.Errorf("migration %d has wrong version, expected: %d, got: %d", i, i+1, migrations[i].Version)
+		}
+		if migrations[i].Name != name {
+			t.Errorf("migration %d has wrong name, expected: %s, got: %s", i, name, migrations[i].Name)
+		}
+		if migrations[i].Checksum == "" {
+			t.Errorf("migration %d has no checksum", i)
+		}
+	}
+	if migrations[0].Down != "DROP TABLE todos;" {
+		t.Errorf("migration 1 has wrong down step, got: %q", migrations[0].Down)
+	}
+	if migrations[2].Down != "" {
+		t.Errorf("migration 3 should have no down step, got: %q", migrations[2].Down)
+	}
+	if migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("expected different checksums for different migrations")
+	}
+}
+
+func TestLoadMigrations_Errors(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"unexpected file": {
+			"0001_create_todos.up.sql": {Data: []byte("SELECT 1;")},
+			"README.md":                {Data: []byte("notes")},
+		},
+		"missing up step": {
+			"0001_create_todos.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"conflicting names": {
+			"0001_create_todos.up.sql": {Data: []byte("SELECT 1;")},
+			"0001_create_tags.up.sql":  {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadMigrations(fsys); err == nil {
+				t.Fatalf("expected an error loading migrations")
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(Migrations)
+	if err != nil {
+		t.Fatalf("failed to load embedded migrations, %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatalf("expected embedded migrations")
+	}
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("embedded migrations should be numbered consecutively, expected: %d, got: %d", i+1, m.Version)
+		}
+		if m.Down == "" {
+			t.Errorf("embedded migration %d_%s has no down step", m.Version, m.Name)
+		}
+	}
+}
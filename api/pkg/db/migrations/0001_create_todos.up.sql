@@ -1,6 +1,8 @@
+-- IF NOT EXISTS lets databases bootstrapped from the old init.sql adopt the
+-- migration history without failing.
 CREATE TABLE IF NOT EXISTS todos (
   id SERIAL PRIMARY KEY,
   title TEXT NOT NULL,
   done BOOLEAN NOT NULL DEFAULT FALSE,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
-);
\ No newline at end of file
+);
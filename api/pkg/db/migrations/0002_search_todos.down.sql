@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS todos_search_idx;
+
+ALTER TABLE todos DROP COLUMN IF EXISTS search;
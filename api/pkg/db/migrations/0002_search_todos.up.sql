@@ -0,0 +1,4 @@
+ALTER TABLE todos
+  ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+
+CREATE INDEX todos_search_idx ON todos USING GIN (search);
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS todos_search_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS search;
+ALTER TABLE todos
+  ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+CREATE INDEX todos_search_idx ON todos USING GIN (search);
+
+DROP INDEX IF EXISTS todos_due_at_idx;
+DROP TRIGGER IF EXISTS todos_set_timestamps ON todos;
+DROP FUNCTION IF EXISTS todos_set_timestamps();
+
+ALTER TABLE todos
+  DROP COLUMN IF EXISTS description,
+  DROP COLUMN IF EXISTS priority,
+  DROP COLUMN IF EXISTS due_at,
+  DROP COLUMN IF EXISTS updated_at,
+  DROP COLUMN IF EXISTS completed_at;
@@ -0,0 +1,39 @@
+ALTER TABLE todos
+  ADD COLUMN description TEXT NOT NULL DEFAULT '',
+  ADD COLUMN priority SMALLINT NOT NULL DEFAULT 0 CHECK (priority BETWEEN -1 AND 2),
+  ADD COLUMN due_at TIMESTAMPTZ,
+  ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  ADD COLUMN completed_at TIMESTAMPTZ;
+
+UPDATE todos SET updated_at = created_at, completed_at = CASE WHEN done THEN created_at END;
+
+-- updated_at and completed_at are kept up to date here so that every way of
+-- writing a todo agrees on them
+CREATE FUNCTION todos_set_timestamps() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    NEW.updated_at = NOW();
+  END IF;
+  IF NOT NEW.done THEN
+    NEW.completed_at = NULL;
+  ELSIF TG_OP = 'INSERT' OR NOT OLD.done THEN
+    NEW.completed_at = NOW();
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todos_set_timestamps
+  BEFORE INSERT OR UPDATE ON todos
+  FOR EACH ROW EXECUTE FUNCTION todos_set_timestamps();
+
+CREATE INDEX todos_due_at_idx ON todos (due_at) WHERE NOT done;
+
+-- search the description too, title matches ranking higher
+DROP INDEX todos_search_idx;
+ALTER TABLE todos DROP COLUMN search;
+ALTER TABLE todos
+  ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', description), 'B')
+  ) STORED;
+CREATE INDEX todos_search_idx ON todos USING GIN (search);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS lists (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  inbox BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- there is exactly one inbox, todos created without a list go there
+CREATE UNIQUE INDEX IF NOT EXISTS lists_inbox_idx ON lists (inbox) WHERE inbox;
+INSERT INTO lists (name, inbox) VALUES ('Inbox', TRUE);
+
+ALTER TABLE todos ADD COLUMN list_id INTEGER REFERENCES lists (id) ON DELETE CASCADE;
+UPDATE todos SET list_id = (SELECT id FROM lists WHERE inbox);
+ALTER TABLE todos ALTER COLUMN list_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS todos_list_id_idx ON todos (list_id);
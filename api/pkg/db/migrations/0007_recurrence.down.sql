@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS todos_series_id_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS series_id;
+ALTER TABLE todos DROP COLUMN IF EXISTS recurrence;
@@ -0,0 +1,5 @@
+ALTER TABLE todos ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+
+-- the first todo of a series, which may since have been deleted
+ALTER TABLE todos ADD COLUMN series_id INTEGER;
+CREATE INDEX IF NOT EXISTS todos_series_id_idx ON todos (series_id) WHERE series_id IS NOT NULL;
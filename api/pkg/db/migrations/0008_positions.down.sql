@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS todos_position_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS position;
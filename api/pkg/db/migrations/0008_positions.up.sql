@@ -0,0 +1,11 @@
+-- position keys compare byte by byte, whatever the database's collation
+ALTER TABLE todos ADD COLUMN position TEXT COLLATE "C" NOT NULL DEFAULT '';
+
+-- existing todos keep the order they were created in, the keys are fixed
+-- width hex numbers, which sort the same as the keys the application makes,
+-- without trailing zeros
+UPDATE todos SET position = rtrim(lpad(to_hex(ordered.n), 8, '0'), '0')
+FROM (SELECT id, row_number() OVER (ORDER BY created_at, id) AS n FROM todos) AS ordered
+WHERE todos.id = ordered.id;
+
+CREATE INDEX IF NOT EXISTS todos_position_idx ON todos (position, id);
@@ -0,0 +1,3 @@
+-- deleted todos stay in the trash until they are restored or purged
+ALTER TABLE todos ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS todos_deleted_at_idx ON todos (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS todos_archived_at_idx;
+ALTER TABLE todos DROP COLUMN IF EXISTS archived_at;
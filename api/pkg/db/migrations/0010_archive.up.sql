@@ -0,0 +1,3 @@
+-- archived todos are left out of listings unless asked for
+ALTER TABLE todos ADD COLUMN archived_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS todos_archived_at_idx ON todos (archived_at) WHERE archived_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS todos_bump_version ON todos;
+DROP FUNCTION IF EXISTS todos_bump_version();
+ALTER TABLE todos DROP COLUMN IF EXISTS version;
@@ -0,0 +1,13 @@
+-- version counts the writes to a todo, it is the todo's ETag
+ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE FUNCTION todos_bump_version() RETURNS TRIGGER AS $$
+BEGIN
+  NEW.version = OLD.version + 1;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todos_bump_version
+  BEFORE UPDATE ON todos
+  FOR EACH ROW EXECUTE FUNCTION todos_bump_version();
@@ -0,0 +1,25 @@
+-- without owners only the first user's lists, todos and tags fit the schema
+DELETE FROM lists WHERE owner_id <> (SELECT min(id) FROM users);
+DELETE FROM tags WHERE owner_id <> (SELECT min(id) FROM users);
+
+ALTER TABLE tags
+  DROP CONSTRAINT IF EXISTS tags_owner_id_name_key,
+  DROP COLUMN IF EXISTS owner_id,
+  ADD CONSTRAINT tags_name_key UNIQUE (name);
+
+DROP INDEX IF EXISTS todos_owner_id_idx;
+ALTER TABLE todos
+  DROP CONSTRAINT IF EXISTS todos_list_id_fkey,
+  DROP CONSTRAINT IF EXISTS todos_parent_id_fkey,
+  DROP CONSTRAINT IF EXISTS todos_id_owner_id_key,
+  DROP COLUMN IF EXISTS owner_id,
+  ADD CONSTRAINT todos_list_id_fkey FOREIGN KEY (list_id) REFERENCES lists (id) ON DELETE CASCADE,
+  ADD CONSTRAINT todos_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES todos (id) ON DELETE CASCADE;
+
+DROP INDEX IF EXISTS lists_inbox_idx;
+CREATE UNIQUE INDEX lists_inbox_idx ON lists (inbox) WHERE inbox;
+ALTER TABLE lists
+  DROP CONSTRAINT IF EXISTS lists_id_owner_id_key,
+  DROP COLUMN IF EXISTS owner_id;
+
+DROP TABLE IF EXISTS users;
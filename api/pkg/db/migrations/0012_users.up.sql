@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS users (
+  id SERIAL PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- everything created before there were users belongs to the first one
+INSERT INTO users (name) VALUES ('local');
+
+ALTER TABLE lists ADD COLUMN owner_id INTEGER REFERENCES users (id) ON DELETE CASCADE;
+UPDATE lists SET owner_id = (SELECT min(id) FROM users);
+ALTER TABLE lists ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE lists ADD CONSTRAINT lists_id_owner_id_key UNIQUE (id, owner_id);
+
+-- every user has exactly one inbox
+DROP INDEX IF EXISTS lists_inbox_idx;
+CREATE UNIQUE INDEX lists_inbox_idx ON lists (owner_id) WHERE inbox;
+
+-- a todo belongs to the owner of its list, the composite foreign keys keep
+-- todos out of other users' lists and from under other users' todos
+ALTER TABLE todos ADD COLUMN owner_id INTEGER;
+UPDATE todos SET owner_id = lists.owner_id FROM lists WHERE lists.id = todos.list_id;
+ALTER TABLE todos ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE todos ADD CONSTRAINT todos_id_owner_id_key UNIQUE (id, owner_id);
+ALTER TABLE todos
+  DROP CONSTRAINT IF EXISTS todos_list_id_fkey,
+  DROP CONSTRAINT IF EXISTS todos_parent_id_fkey,
+  ADD CONSTRAINT todos_list_id_fkey FOREIGN KEY (list_id, owner_id)
+    REFERENCES lists (id, owner_id) ON DELETE CASCADE ON UPDATE CASCADE,
+  ADD CONSTRAINT todos_parent_id_fkey FOREIGN KEY (parent_id, owner_id)
+    REFERENCES todos (id, owner_id) ON DELETE CASCADE ON UPDATE CASCADE;
+CREATE INDEX IF NOT EXISTS todos_owner_id_idx ON todos (owner_id);
+
+-- tag names are unique per user
+ALTER TABLE tags ADD COLUMN owner_id INTEGER REFERENCES users (id) ON DELETE CASCADE;
+UPDATE tags SET owner_id = (SELECT min(id) FROM users);
+ALTER TABLE tags ALTER COLUMN owner_id SET NOT NULL;
+ALTER TABLE tags
+  DROP CONSTRAINT IF EXISTS tags_name_key,
+  ADD CONSTRAINT tags_owner_id_name_key UNIQUE (owner_id, name);
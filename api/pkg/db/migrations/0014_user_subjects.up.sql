@@ -0,0 +1,3 @@
+-- the subject of the identity provider's tokens for the user, tokens of a
+-- subject that isn't linked to a user are rejected
+ALTER TABLE users ADD COLUMN IF NOT EXISTS subject TEXT UNIQUE;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'editor' CHECK (role IN ('viewer', 'editor', 'admin'));
+
+-- the local user keeps administering the service
+UPDATE users SET role = 'admin' WHERE id = (SELECT min(id) FROM users);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS todos_siblings_position_idx;
@@ -0,0 +1,3 @@
+-- new keys are placed among the todos of a list under the same parent, the
+-- index on position alone still serves sorting listings by it
+CREATE INDEX IF NOT EXISTS todos_siblings_position_idx ON todos (list_id, parent_id, position, id);
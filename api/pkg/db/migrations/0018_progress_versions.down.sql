@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS todos_bump_parent_version_on_update ON todos;
+DROP TRIGGER IF EXISTS todos_bump_parent_version ON todos;
+DROP FUNCTION IF EXISTS todos_bump_parent_version();
@@ -0,0 +1,26 @@
+-- the progress of a todo is part of it, so a subtask that is added, done or
+-- undone, moved, trashed or restored is a write to its parent too, purging
+-- one from the trash isn't
+CREATE FUNCTION todos_bump_parent_version() RETURNS TRIGGER AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' OR (TG_OP = 'DELETE' AND OLD.deleted_at IS NULL) THEN
+    UPDATE todos SET version = version WHERE id = OLD.parent_id;
+  END IF;
+  IF TG_OP = 'INSERT' OR (TG_OP = 'UPDATE' AND NEW.parent_id IS DISTINCT FROM OLD.parent_id) THEN
+    UPDATE todos SET version = version WHERE id = NEW.parent_id;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todos_bump_parent_version
+  AFTER INSERT OR DELETE ON todos
+  FOR EACH ROW EXECUTE FUNCTION todos_bump_parent_version();
+
+CREATE TRIGGER todos_bump_parent_version_on_update
+  AFTER UPDATE OF done, parent_id, deleted_at ON todos
+  FOR EACH ROW
+  WHEN (OLD.done IS DISTINCT FROM NEW.done
+    OR OLD.parent_id IS DISTINCT FROM NEW.parent_id
+    OR OLD.deleted_at IS DISTINCT FROM NEW.deleted_at)
+  EXECUTE FUNCTION todos_bump_parent_version();
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// positionDigits are the characters of a position key in ascending order,
+// which is their byte order, so keys sort the same in Go and in Postgres
+// with the "C" collation.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// MaxPositionLength is the longest position key, before the keys of the
+// todo's siblings are spread out again.
+const MaxPositionLength = 32
+
+// positionLockClass is the first pg_advisory_xact_lock key that serializes
+// changes to positions, the second is the id of the list, so that two todos
+// of a list never get the same key.
+const positionLockClass int32 = 0x706f7369 // "posi"
+
+// KeyBetween returns a position key that sorts between a and b, either of
+// which may be empty to mean the start or the end. Keys are fractions in
+// base 62 written without the leading "0." and never end in a zero, which
+// leaves room for another key between any two.
+//
+// Positions are only compared between siblings, the todos of a list under
+// the same parent.
+func KeyBetween(a, b string) string {
+	if b == "" && a != "" {
+		return increment(a)
+	}
+	return midpoint(a, b)
+}
+
+// increment returns the shortest key after a, for appending. It counts up
+// the last digit that isn't the largest one, dropping those after it, and
+// only adds a digit when every digit is the largest one, so appending
+// lengthens keys by one for every 61 todos at most.
+func increment(a string) string {
+	for i := len(a) - 1; i >= 0; i-- {
+		if d := strings.IndexByte(positionDigits, a[i]); d < len(positionDigits)-1 {
+			return a[:i] + string(positionDigits[d+1])
+		}
+	}
+	return a + positionDigits[1:2]
+}
+
+// midpoint returns a key between a and b, b is empty to mean the end.
+func midpoint(a, b string) string {
+	if b != "" {
+		// a key between them has to start with their common prefix, with a
+		// padded with zeros
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(tail(a, n), b[n:])
+		}
+	}
+
+	lo := strings.IndexByte(positionDigits, digitAt(a, 0))
+	hi := len(positionDigits)
+	if b != "" {
+		hi = strings.IndexByte(positionDigits, b[0])
+	}
+	switch {
+	case hi-lo > 1:
+		return string(positionDigits[(lo+hi)/2])
+	case len(b) > 1:
+		// b is longer so its first digit alone sorts before it
+		return b[:1]
+	default:
+		return string(positionDigits[lo]) + midpoint(tail(a, 1), "")
+	}
+}
+
+func digitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return positionDigits[0]
+}
+
+func tail(key string, i int) string {
+	if i < len(key) {
+		return key[i:]
+	}
+	return ""
+}
+
+// SpreadKeys returns n ascending position keys spaced out evenly, all of them
+// as short as possible.
+func SpreadKeys(n int) []string {
+	base := len(positionDigits)
+	length, capacity := 1, base
+	for capacity <= n {
+		length++
+		capacity *= base
+	}
+	step := capacity / (n + 1)
+
+	keys := make([]string, n)
+	for i := range keys {
+		digits := make([]byte, length)
+		v := (i + 1) * step
+		for j := length - 1; j >= 0; j-- {
+			digits[j] = positionDigits[v%base]
+			v /= base
+		}
+		keys[i] = strings.TrimRight(string(digits), positionDigits[:1])
+	}
+	return keys
+}
+
+// siblings is the condition on the todos of list $n under parent $n+1, a
+// NULL parent for those at the top level.
+func siblings(n int) string {
+	return fmt.Sprintf("list_id = $%[1]d AND ($%[2]d::integer IS NULL AND parent_id IS NULL OR parent_id = $%[2]d)", n, n+1)
+}
+
+// lockPositions takes the lock on the positions of the todos of the list,
+// which changes to them hold until the end of their transaction.
+func lockPositions(ctx context.Context, tx pgx.Tx, listId string) error {
+	_, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1, $2::integer)", positionLockClass, listId)
+	return err
+}
+
+// lastPosition returns the position after every todo of the list under
+// parentId, spreading their keys out first if it would be too long.
+func lastPosition(ctx context.Context, tx pgx.Tx, listId string, parentId *string) (string, error) {
+	if err := lockPositions(ctx, tx, listId); err != nil {
+		return "", err
+	}
+	for rebalanced := false; ; rebalanced = true {
+		var last string
+		err := tx.QueryRow(ctx, "SELECT COALESCE(max(position), '') FROM todos WHERE "+siblings(1), listId, parentId).
+			Scan(&last)
+		if err != nil {
+			return "", err
+		}
+		key := KeyBetween(last, "")
+		if len(key) <= MaxPositionLength || rebalanced {
+			return key, nil
+		}
+		if err := rebalance(ctx, tx, listId, parentId); err != nil {
+			return "", err
+		}
+	}
+}
+
+// moveTodos moves every todo of list from to list to, after the todos
+// already there under the same parent and in the same order as before, since
+// their keys were only ordered among their old siblings.
+func moveTodos(ctx context.Context, tx pgx.Tx, from, to string) error {
+	type moved struct {
+		id       int32
+		parentId *string
+	}
+	rows, err := tx.Query(ctx, "SELECT id, parent_id FROM todos WHERE list_id = $1 ORDER BY parent_id NULLS FIRST, position, id",
+		from)
+	if err != nil {
+		return err
+	}
+	todos, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (todo moved, err error) {
+		err = row.Scan(&todo.id, &todo.parentId)
+		return todo, err
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int32, len(todos))
+	positions := make([]string, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.id
+		if i > 0 && equalIds(todo.parentId, todos[i-1].parentId) {
+			positions[i] = KeyBetween(positions[i-1], "")
+			continue
+		}
+		if positions[i], err = lastPosition(ctx, tx, to, todo.parentId); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, `UPDATE todos SET list_id = $1, position = moved.position
+  FROM unnest($2::integer[], $3::text[]) AS moved (id, position)
+  WHERE todos.id = moved.id`, to, ids, positions)
+	return err
+}
+
+// rebalance gives the todos of the list under parentId new, evenly spaced
+// position keys in the same order as before. The caller must hold the
+// position lock of the list.
+func rebalance(ctx context.Context, tx pgx.Tx, listId string, parentId *string) error {
+	rows, err := tx.Query(ctx, "SELECT id FROM todos WHERE "+siblings(1)+" ORDER BY position, id", listId, parentId)
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int32])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE todos SET position = spread.position
+  FROM unnest($1::integer[], $2::text[]) AS spread (id, position)
+  WHERE todos.id = spread.id`, ids, SpreadKeys(len(ids)))
+	return err
+}
+
+// Move places the todo with id right before or after another todo in the
+// same list and under the same parent, and returns it.
+func (db *DB) Move(ctx context.Context, id string, move models.TodoMove) (moved models.Todo, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		// the position lock comes before the row lock, like for new todos,
+		// so the list is read first and checked again once the todo is locked
+		var todo models.Todo
+		for locked := ""; ; {
+			current, err := getTodo(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if current.ListId != locked {
+				if err := lockPositions(ctx, tx, current.ListId); err != nil {
+					return err
+				}
+				locked = current.ListId
+			}
+			if todo, err = lockTodo(ctx, tx, id); err != nil {
+				return err
+			}
+			if todo.ListId == locked {
+				break
+			}
+		}
+
+		for rebalanced := false; ; rebalanced = true {
+			key, err := positionNextTo(ctx, tx, todo, move)
+			if err != nil {
+				return err
+			}
+			// no key or one that's too long means it's time to spread them out
+			if key != "" && len(key) <= MaxPositionLength || rebalanced {
+				if _, err := tx.Exec(ctx, "UPDATE todos SET position = $1 WHERE id = $2", key, id); err != nil {
+					return err
+				}
+				break
+			}
+			if err := rebalance(ctx, tx, todo.ListId, todo.ParentId); err != nil {
+				return err
+			}
+		}
+
+		moved, err = getTodo(ctx, tx, id)
+		return err
+	})
+	return moved, translateError(err)
+}
+
+// positionNextTo returns a key for todo right before or after the todo the
+// move refers to, or an empty key if there is no room between them.
+func positionNextTo(ctx context.Context, tx pgx.Tx, todo models.Todo, move models.TodoMove) (string, error) {
+	field, anchorId := "after", move.After
+	if move.Before != "" {
+		field, anchorId = "before", move.Before
+	}
+
+	var v models.ValidationError
+	anchor, err := getTodo(ctx, tx, anchorId)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		v.Add(field, "does not exist")
+		return "", v.Err()
+	case err != nil:
+		return "", err
+	case anchor.Id == todo.Id:
+		v.Add(field, "must not be the todo itself")
+		return "", v.Err()
+	case anchor.ListId != todo.ListId || !equalIds(anchor.ParentId, todo.ParentId):
+		v.Add(field, "must be in the same list and under the same parent")
+		return "", v.Err()
+	}
+
+	// the sibling on the other side of the anchor, leaving out the todo
+	// being moved, bounds the new key
+	query := `SELECT COALESCE(max(position), '') FROM todos
+  WHERE (position, id) < ($1, $2) AND id <> $3 AND ` + siblings(4)
+	if field == "after" {
+		query = `SELECT COALESCE(min(position), '') FROM todos
+  WHERE (position, id) > ($1, $2) AND id <> $3 AND ` + siblings(4)
+	}
+	var neighbour string
+	err = tx.QueryRow(ctx, query, anchor.Position, anchor.Id, todo.Id, todo.ListId, todo.ParentId).Scan(&neighbour)
+	if err != nil {
+		return "", err
+	}
+
+	lo, hi := neighbour, anchor.Position
+	if field == "after" {
+		lo, hi = anchor.Position, neighbour
+	}
+	if hi != "" && lo >= hi {
+		return "", nil
+	}
+	return KeyBetween(lo, hi), nil
+}
+
+func equalIds(a, b *string) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,97 @@
+package db_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	. "example.com/todos/pkg/db"
+)
+
+func TestKeyBetween(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"", "", "V"},
+		{"U", "", "V"},
+		{"z", "", "z1"},
+		{"Az", "", "B"},
+		{"zz", "", "zz1"},
+		{"", "U", "F"},
+		{"", "1", "0V"},
+		{"A", "B", "AV"},
+		{"A", "A1", "A0V"},
+		{"Az", "B", "AzV"},
+		{"A", "C", "B"},
+	}
+	for _, tt := range tests {
+		if got := KeyBetween(tt.a, tt.b); got != tt.want {
+			t.Errorf("KeyBetween(%q, %q): got %q want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// inserting at the front, the back and in the middle over and over keeps
+	// the keys ordered and lets them grow only slowly
+	keys := []string{KeyBetween("", "")}
+	for i := range 300 {
+		var at int
+		switch i % 3 {
+		case 0:
+			at = 0
+		case 1:
+			at = len(keys)
+		default:
+			at = len(keys) / 2
+		}
+		var lo, hi string
+		if at > 0 {
+			lo = keys[at-1]
+		}
+		if at < len(keys) {
+			hi = keys[at]
+		}
+		key := KeyBetween(lo, hi)
+		if key <= lo || hi != "" && key >= hi || strings.HasSuffix(key, "0") {
+			t.Fatalf("KeyBetween(%q, %q) returned %q", lo, hi, key)
+		}
+		keys = slices.Insert(keys, at, key)
+	}
+	for _, key := range keys {
+		if len(key) > MaxPositionLength {
+			t.Fatalf("expected keys to stay short, got %q", key)
+		}
+	}
+}
+
+func TestKeyBetween_Appending(t *testing.T) {
+	// appending carries over into shorter digits first, so a thousand todos
+	// fit before the keys need spreading out
+	last := KeyBetween("", "")
+	for range 1000 {
+		key := KeyBetween(last, "")
+		if key <= last || strings.HasSuffix(key, "0") {
+			t.Fatalf("KeyBetween(%q, \"\") returned %q", last, key)
+		}
+		last = key
+	}
+	if len(last) > MaxPositionLength {
+		t.Fatalf("expected appended keys to stay short, got %q", last)
+	}
+}
+
+func TestSpreadKeys(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 61, 62, 1000} {
+		keys := SpreadKeys(n)
+		if len(keys) != n {
+			t.Fatalf("SpreadKeys(%d) returned %d keys", n, len(keys))
+		}
+		if !slices.IsSorted(keys) || len(slices.Compact(slices.Clone(keys))) != n {
+			t.Fatalf("SpreadKeys(%d) returned keys out of order: %v", n, keys)
+		}
+		for _, key := range keys {
+			if key == "" || strings.HasSuffix(key, "0") || len(key) > 2 {
+				t.Fatalf("SpreadKeys(%d) returned key %q", n, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// scheduleNext creates the todo that follows the just completed todo in its
+// series, if it recurs, and returns the completed todo, which starts the
+// series if it's the first one.
+func (db *DB) scheduleNext(ctx context.Context, tx pgx.Tx, todo models.Todo) (models.Todo, error) {
+	next, ok := todo.NextOccurrence(db.now())
+	if !ok {
+		return todo, nil
+	}
+
+	if todo.SeriesId == nil {
+		if _, err := tx.Exec(ctx, "UPDATE todos SET series_id = id WHERE id = $1", todo.Id); err != nil {
+			return models.Todo{}, err
+		}
+		todo.SeriesId = &todo.Id
+		next.SeriesId = &todo.Id
+	}
+	if _, err := db.insertTodo(ctx, tx, next); err != nil {
+		return models.Todo{}, err
+	}
+	return getTodo(ctx, tx, todo.Id)
+}
+
+// Series returns the todos in the recurring series of the todo with id,
+// ordered by due date, or just that todo if it hasn't been repeated.
+func (db *DB) Series(ctx context.Context, id string) (todos []models.Todo, err error) {
+	rows, err := db.pool.Query(ctx, `SELECT `+todoColumns+` FROM todos
+  WHERE (id = $1 OR series_id = (SELECT series_id FROM todos WHERE id = $1)) AND deleted_at IS NULL
+    AND `+todoScope(2, readAccess)+`
+  ORDER BY due_at NULLS LAST, id`, id, owner(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	todos, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Todo, error) {
+		return scanTodo(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(todos) == 0 {
+		return nil, ErrNotFound
+	}
+	return todos, nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"example.com/todos/pkg/models"
+)
+
+const (
+	// DefaultSearchLimit is the number of results used when
+	// SearchOptions.Limit is 0.
+	DefaultSearchLimit = 20
+	// MaxSearchLimit is the largest number of results Search returns.
+	MaxSearchLimit = 100
+)
+
+// SearchOptions describes a full-text search.
+type SearchOptions struct {
+	// Query uses the web search syntax of websearch_to_tsquery: words,
+	// "quoted phrases", "or" and -excluded words.
+	Query string
+	// Limit is the maximum number of results, DefaultSearchLimit if 0.
+	Limit int
+}
+
+// PageLimit returns the effective number of results for the options.
+func (opts SearchOptions) PageLimit() int {
+	switch {
+	case opts.Limit <= 0:
+		return DefaultSearchLimit
+	case opts.Limit > MaxSearchLimit:
+		return MaxSearchLimit
+	default:
+		return opts.Limit
+	}
+}
+
+// The snippets mark matches with characters of the Unicode private use area,
+// which are taken out of the text first, so that the text can be escaped
+// before they are replaced with <mark> tags.
+const (
+	markStart = "\uE000"
+	markStop  = "\uE001"
+)
+
+var marks = strings.NewReplacer(markStart, "<mark>", markStop, "</mark>")
+
+// highlight returns the headline as HTML, the text escaped and the matches
+// wrapped in <mark> tags.
+func highlight(headline string) string {
+	return marks.Replace(html.EscapeString(headline))
+}
+
+// Search returns the todos matching the query, best matches first. The
+// snippets are HTML, see models.SearchResult.
+func (db *DB) Search(ctx context.Context, opts SearchOptions) (results []models.SearchResult, err error) {
+	rows, err := db.pool.Query(ctx, `SELECT `+todoColumns+`,
+  ts_rank(search, query) AS rank,
+  ts_headline('english', translate(title, $4, ''), query, $5 || ', HighlightAll=true'),
+  ts_headline('english', translate(description, $4, ''), query, $5 || ', MaxFragments=2')
+FROM todos, websearch_to_tsquery('english', $1) AS query
+WHERE search @@ query AND deleted_at IS NULL AND `+todoScope(3, readAccess)+`
+ORDER BY rank DESC, id
+LIMIT $2`, opts.Query, opts.PageLimit(), owner(ctx), markStart+markStop, "StartSel="+markStart+", StopSel="+markStop)
+	if err != nil {
+		return nil, fmt.Errorf("executing search query: %w", translateError(err))
+	}
+	defer rows.Close()
+
+	results = []models.SearchResult{}
+	for rows.Next() {
+		var r models.SearchResult
+		r.Todo, err = scanTodo(rows, &r.Rank, &r.Snippet, &r.DescriptionSnippet)
+		if err != nil {
+			return nil, fmt.Errorf("scanning search result: %w", err)
+		}
+		r.Snippet, r.DescriptionSnippet = highlight(r.Snippet), highlight(r.DescriptionSnippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+	return results, nil
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxDepth is how deep subtasks can be nested, a top level todo is at depth 1.
+const MaxDepth = 5
+
+// hierarchyLockID is the pg_advisory_xact_lock key that serializes changes
+// to parent_id, so that two concurrent moves can't form a cycle between them.
+const hierarchyLockID int64 = 0x7375627461736b73 // "subtasks"
+
+// writeSubtask checks the hierarchy around the todo that was just written
+// with id, and completes its ancestors if enabled. It runs after the write so
+// that the row is locked before the hierarchy lock is taken, which keeps the
+// lock order the same for every transaction.
+func (db *DB) writeSubtask(ctx context.Context, tx pgx.Tx, id string, todo models.Todo) error {
+	if todo.ParentId == nil {
+		return nil
+	}
+	if err := checkParent(ctx, tx, *todo.ParentId); err != nil {
+		return err
+	}
+	if err := checkHierarchy(ctx, tx, id); err != nil {
+		return err
+	}
+	if db.autoCompleteParents && todo.Done {
+		return db.completeAncestors(ctx, tx, *todo.ParentId)
+	}
+	return nil
+}
+
+// checkParent returns a *models.ValidationError if the parent is in the
+// trash, or in a list the caller can't change. A parent that doesn't exist at
+// all fails the foreign key instead.
+func checkParent(ctx context.Context, tx pgx.Tx, parentId string) error {
+	var trashed bool
+	err := tx.QueryRow(ctx, "SELECT deleted_at IS NOT NULL FROM todos WHERE id = $1 AND "+todoScope(2, writes(ctx)),
+		parentId, owner(ctx)).Scan(&trashed)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	var v models.ValidationError
+	if trashed || errors.Is(err, pgx.ErrNoRows) {
+		v.Add("parentId", "does not exist")
+	}
+	return v.Err()
+}
+
+// checkHierarchy returns a *models.ValidationError if the todo with id is now
+// its own ancestor, or its subtree is nested deeper than MaxDepth.
+func checkHierarchy(ctx context.Context, tx pgx.Tx, id string) error {
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", hierarchyLockID); err != nil {
+		return err
+	}
+
+	// both walks stop past MaxDepth, which is as far as a cycle can reach
+	// before it comes back around, so they end even once one was formed
+	var cycle bool
+	var depth int
+	err := tx.QueryRow(ctx, `WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id, 1 AS depth FROM todos WHERE id = $1
+    UNION ALL
+    SELECT todos.id, todos.parent_id, ancestors.depth + 1
+    FROM todos JOIN ancestors ON todos.id = ancestors.parent_id
+    WHERE ancestors.depth <= $2
+  ), descendants AS (
+    SELECT id, 1 AS depth FROM todos WHERE id = $1
+    UNION ALL
+    SELECT todos.id, descendants.depth + 1
+    FROM todos JOIN descendants ON todos.parent_id = descendants.id
+    WHERE descendants.depth <= $2
+  )
+  SELECT
+    EXISTS (SELECT 1 FROM ancestors WHERE depth > 1 AND id = $1),
+    (SELECT max(depth) FROM ancestors) + (SELECT max(depth) FROM descendants) - 1`,
+		id, MaxDepth,
+	).Scan(&cycle, &depth)
+	if err != nil {
+		return err
+	}
+
+	var v models.ValidationError
+	switch {
+	case cycle:
+		v.Add("parentId", "must not be the todo itself or one of its subtasks")
+	case depth > MaxDepth:
+		v.Add("parentId", "must not nest subtasks more than %d levels deep", MaxDepth)
+	}
+	return v.Err()
+}
+
+// completeAncestors marks the todo with id done if all of its subtasks are,
+// and then its parent, and so on up the tree. The recurring ones it completes
+// are followed by their next todo, as when they are completed by hand.
+func (db *DB) completeAncestors(ctx context.Context, tx pgx.Tx, id string) error {
+	var completed []models.Todo
+	for {
+		ancestor, err := scanTodo(tx.QueryRow(ctx, `UPDATE todos SET done = TRUE
+  WHERE id = $1 AND NOT done AND deleted_at IS NULL
+    AND NOT EXISTS (SELECT 1 FROM todos subtasks WHERE subtasks.parent_id = $1 AND NOT subtasks.done AND subtasks.deleted_at IS NULL)
+  RETURNING `+todoColumns, id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		completed = append(completed, ancestor)
+		if ancestor.ParentId == nil {
+			break
+		}
+		id = *ancestor.ParentId
+	}
+	for _, ancestor := range completed {
+		if _, err := db.scheduleNext(ctx, tx, ancestor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Descendants returns every subtask of the todo with id, however deeply
+// nested, ordered by creation time, leaving out the ones in the trash. See
+// models.Todo.WithChildren.
+func (db *DB) Descendants(ctx context.Context, id string) (todos []models.Todo, err error) {
+	rows, err := db.pool.Query(ctx, `WITH RECURSIVE descendants AS (
+    SELECT id, 1 AS depth FROM todos WHERE parent_id = $1 AND deleted_at IS NULL AND `+todoScope(3, readAccess)+`
+    UNION ALL
+    SELECT todos.id, descendants.depth + 1
+    FROM todos JOIN descendants ON todos.parent_id = descendants.id
+    WHERE todos.deleted_at IS NULL AND descendants.depth < $2
+  )
+  SELECT `+todoColumns+` FROM todos WHERE id IN (SELECT id FROM descendants) ORDER BY created_at, id`,
+		id, MaxDepth, owner(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	todos, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Todo, error) {
+		return scanTodo(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return todos, nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// tagColumns are the columns scanTag reads, in order.
+const tagColumns = "id, owner_id, name, created_at, (SELECT count(*) FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id " +
+	"WHERE todo_tags.tag_id = tags.id AND todos.deleted_at IS NULL)"
+
+func scanTag(row pgx.Row) (tag models.Tag, err error) {
+	err = row.Scan(&tag.Id, &tag.OwnerId, &tag.Name, &tag.CreatedAt, &tag.TodoCount)
+	return tag, err
+}
+
+// setTags replaces the tags of a todo with the named ones of its owner,
+// creating any tag that doesn't exist yet.
+func setTags(ctx context.Context, q querier, todoID string, names []string) error {
+	if names == nil {
+		names = []string{}
+	}
+	if _, err := q.Exec(ctx, `INSERT INTO tags (owner_id, name) SELECT owner_id, unnest($2::text[]) FROM todos WHERE id = $1
+  ON CONFLICT (owner_id, name) DO NOTHING`, todoID, names); err != nil {
+		return fmt.Errorf("creating tags: %w", err)
+	}
+	_, err := q.Exec(ctx, `DELETE FROM todo_tags WHERE todo_id = $1::integer
+  AND tag_id NOT IN (SELECT id FROM tags WHERE name = ANY($2::text[])
+    AND owner_id = (SELECT owner_id FROM todos WHERE id = $1::integer))`, todoID, names)
+	if err != nil {
+		return fmt.Errorf("removing tags: %w", err)
+	}
+	_, err = q.Exec(ctx, `INSERT INTO todo_tags (todo_id, tag_id)
+  SELECT $1::integer, id FROM tags WHERE name = ANY($2::text[])
+    AND owner_id = (SELECT owner_id FROM todos WHERE id = $1::integer)
+  ON CONFLICT DO NOTHING`, todoID, names)
+	if err != nil {
+		return fmt.Errorf("adding tags: %w", err)
+	}
+	return nil
+}
+
+// ListTags returns the caller's tags, ordered by name.
+func (db *DB) ListTags(ctx context.Context) (tags []models.Tag, err error) {
+	rows, err := db.pool.Query(ctx, "SELECT "+tagColumns+" FROM tags WHERE "+ownerScope("tags", 1)+" ORDER BY name", owner(ctx))
+	if err != nil {
+		return nil, translateError(err)
+	}
+	tags, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Tag, error) {
+		return scanTag(row)
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return tags, nil
+}
+
+// CreateTag creates a tag, failing with ErrConflict if the name is taken.
+func (db *DB) CreateTag(ctx context.Context, name string) (tag models.Tag, err error) {
+	tag, err = scanTag(db.pool.QueryRow(ctx, "INSERT INTO tags (owner_id, name) VALUES ("+ownerOrDefault(2)+", $1) RETURNING "+tagColumns,
+		name, owner(ctx)))
+	return tag, translateError(err)
+}
+
+func (db *DB) GetTag(ctx context.Context, id string) (tag models.Tag, err error) {
+	tag, err = scanTag(db.pool.QueryRow(ctx, "SELECT "+tagColumns+" FROM tags WHERE id = $1 AND "+ownerScope("tags", 2), id, owner(ctx)))
+	return tag, translateError(err)
+}
+
+// RenameTag renames a tag on every todo it labels, failing with ErrConflict
+// if the name is taken.
+func (db *DB) RenameTag(ctx context.Context, id string, name string) (tag models.Tag, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		tag, err = scanTag(tx.QueryRow(ctx, "UPDATE tags SET name = $1 WHERE id = $2 AND "+ownerScope("tags", 3)+" RETURNING "+tagColumns,
+			name, id, owner(ctx)))
+		if err != nil {
+			return err
+		}
+		return touchTagged(ctx, tx, tag.Id)
+	})
+	return tag, translateError(err)
+}
+
+// DeleteTag deletes a tag and removes it from every todo.
+func (db *DB) DeleteTag(ctx context.Context, id string) error {
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		// the todos lose the tag along with it, so they are touched first
+		err := tx.QueryRow(ctx, "SELECT id FROM tags WHERE id = $1 AND "+ownerScope("tags", 2)+" FOR UPDATE", id, owner(ctx)).
+			Scan(&id)
+		if err != nil {
+			return err
+		}
+		if err := touchTagged(ctx, tx, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM tags WHERE id = $1", id)
+		return err
+	})
+	return translateError(err)
+}
+
+// touchTagged bumps the version of the todos labelled with the tag, since
+// their tags are part of them.
+func touchTagged(ctx context.Context, tx pgx.Tx, tagId string) error {
+	_, err := tx.Exec(ctx, "UPDATE todos SET version = version WHERE id IN (SELECT todo_id FROM todo_tags WHERE tag_id = $1)", tagId)
+	return err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrParentDeleted is returned when restoring a subtask whose parent is still
+// in the trash.
+var ErrParentDeleted = errors.New("the parent todo is in the trash, restore it first")
+
+// Restore takes the todo with id out of the trash, along with the subtasks
+// that were deleted with it, and returns it.
+func (db *DB) Restore(ctx context.Context, id string) (restored models.Todo, err error) {
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var deletedAt time.Time
+		var parentTrashed bool
+		err := tx.QueryRow(ctx, `SELECT deleted_at,
+    COALESCE((SELECT parent.deleted_at IS NOT NULL FROM todos parent WHERE parent.id = todos.parent_id), FALSE)
+  FROM todos WHERE id = $1 AND deleted_at IS NOT NULL AND `+todoScope(2, writes(ctx))+` FOR UPDATE OF todos`,
+			id, owner(ctx)).Scan(&deletedAt, &parentTrashed)
+		if err != nil {
+			return err
+		}
+		if parentTrashed {
+			return fmt.Errorf("%w: %w", ErrConflict, ErrParentDeleted)
+		}
+
+		// subtasks deleted before the todo itself stay in the trash
+		_, err = tx.Exec(ctx, `WITH RECURSIVE subtree AS (
+    SELECT id, 1 AS depth FROM todos WHERE id = $1
+    UNION ALL
+    SELECT todos.id, subtree.depth + 1
+    FROM todos JOIN subtree ON todos.parent_id = subtree.id
+    WHERE todos.deleted_at = $2 AND subtree.depth < $3
+  )
+  UPDATE todos SET deleted_at = NULL WHERE id IN (SELECT id FROM subtree)`,
+			id, deletedAt, MaxDepth)
+		if err != nil {
+			return err
+		}
+		restored, err = getTodo(ctx, tx, id)
+		return err
+	})
+	return restored, translateError(err)
+}
+
+// Purge permanently deletes the todo with id, which must be in the trash,
+// along with its subtasks.
+func (db *DB) Purge(ctx context.Context, id string) error {
+	commandTag, err := db.pool.Exec(ctx,
+		"DELETE FROM todos WHERE id = $1 AND deleted_at IS NOT NULL AND "+ownerScope("todos", 2), id, owner(ctx))
+	if err != nil {
+		return translateError(err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes the todos that have been in the trash for
+// longer than olderThan, or all of them if it is 0, and returns how many.
+// With middleware.SystemPrincipal in ctx it empties the trash of every user.
+func (db *DB) PurgeTrash(ctx context.Context, olderThan time.Duration) (count int64, err error) {
+	commandTag, err := db.pool.Exec(ctx, "DELETE FROM todos WHERE deleted_at <= $1 AND "+ownerScope("todos", 2),
+		db.now().Add(-olderThan), owner(ctx))
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return commandTag.RowsAffected(), nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// nobody is the owner of callers without a principal, no row belongs to it.
+const nobody = "0"
+
+// owner returns the id of the user the caller acts for, taken from the
+// principal in ctx. It is nil for middleware.SystemPrincipal, which sees the
+// rows of every user, and nobody without a principal, so that a caller that
+// missed the authentication middleware sees nothing rather than everything.
+func owner(ctx context.Context) any {
+	p, ok := middleware.PrincipalFromContext(ctx)
+	switch {
+	case !ok:
+		return nobody
+	case p.System:
+		return nil
+	default:
+		return p.UserId
+	}
+}
+
+// ownerScope is a condition on the owner of the rows of table, true for those
+// of the user passed as parameter $n, or every row if it is NULL, which only
+// the system principal passes. Rows of other users are out of reach, so they
+// are reported as not found.
+func ownerScope(table string, n int) string {
+	return fmt.Sprintf("($%[2]d::integer IS NULL OR %[1]s.owner_id = $%[2]d)", table, n)
+}
+
+// access is what a scope lets the caller do with the rows it is true for.
+type access int
+
+const (
+	readAccess access = iota
+	// writeAccess reaches the caller's rows and those of the lists shared
+	// with them as an editor
+	writeAccess
+	// sharedWriteAccess only reaches the latter, for callers whose role
+	// lets them read their own rows but not change them
+	sharedWriteAccess
+)
+
+// writes is the access the caller in ctx needs to change rows, see
+// middleware.SharedWritesOnly.
+func writes(ctx context.Context) access {
+	if middleware.SharedWritesOnly(ctx) {
+		return sharedWriteAccess
+	}
+	return writeAccess
+}
+
+// memberScope extends ownerScope to the lists shared with the user: it is
+// true for the rows of table whose list, the listColumn, the user owns or is
+// a member of, as an editor for writes.
+func memberScope(table, listColumn string, n int, a access) string {
+	owned, role := fmt.Sprintf("%s.owner_id = $%d", table, n), ""
+	if a != readAccess {
+		role = " AND list_members.role = 'editor'"
+	}
+	if a == sharedWriteAccess {
+		owned = "FALSE"
+	}
+	return fmt.Sprintf(`($%[2]d::integer IS NULL OR %[1]s OR EXISTS (
+    SELECT 1 FROM list_members WHERE list_members.list_id = %[3]s AND list_members.user_id = $%[2]d%[4]s))`,
+		owned, n, listColumn, role)
+}
+
+// todoScope is the memberScope of todos, and listScope that of lists.
+func todoScope(n int, a access) string { return memberScope("todos", "todos.list_id", n, a) }
+func listScope(n int, a access) string { return memberScope("lists", "lists.id", n, a) }
+
+// ownerOrDefault is the owner of a new row, the user passed as parameter $n,
+// or the first user for the system principal.
+func ownerOrDefault(n int) string {
+	return fmt.Sprintf("COALESCE($%d::integer, (SELECT min(id) FROM users))", n)
+}
+
+// userColumns are the columns scanUser reads, in order.
+const userColumns = "id, name, COALESCE(subject, ''), role, created_at"
+
+func scanUser(row pgx.Row) (user models.User, err error) {
+	err = row.Scan(&user.Id, &user.Name, &user.Subject, &user.Role, &user.CreatedAt)
+	return user, err
+}
+
+// CreateUser adds a user with an empty inbox and returns it. Users without a
+// role get models.DefaultUserRole.
+func (db *DB) CreateUser(ctx context.Context, user models.User) (created models.User, err error) {
+	if user.Role == "" {
+		user.Role = models.DefaultUserRole
+	}
+	err = pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		created, err = scanUser(tx.QueryRow(ctx,
+			"INSERT INTO users (name, subject, role) VALUES ($1, NULLIF($2, ''), $3) RETURNING "+userColumns,
+			user.Name, user.Subject, user.Role))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO lists (owner_id, name, inbox) VALUES ($1, 'Inbox', TRUE)", created.Id)
+		return err
+	})
+	return created, translateError(err)
+}
+
+// GetUser returns the user with id.
+func (db *DB) GetUser(ctx context.Context, id string) (models.User, error) {
+	user, err := scanUser(db.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+	return user, translateError(err)
+}
+
+// UserBySubject returns the user linked to the sub claim of a token.
+func (db *DB) UserBySubject(ctx context.Context, subject string) (models.User, error) {
+	user, err := scanUser(db.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE subject = $1", subject))
+	return user, translateError(err)
+}
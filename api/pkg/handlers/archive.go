@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxOlderThanDays is the largest older_than_days accepted, about a century,
+// which keeps the duration from overflowing.
+const MaxOlderThanDays = 36500
+
+// ArchiveResult is the response body of POST /todos/archive-completed.
+type ArchiveResult struct {
+	Archived int64 `json:"archived"`
+}
+
+// ArchiveCompleted archives every completed todo, or with older_than_days
+// only those completed at least that many days ago. Archived todos are left
+// out of GET /todos unless archived=true.
+func (h *RouteHandler) ArchiveCompleted(w http.ResponseWriter, r *http.Request) {
+	var v models.ValidationError
+	query := r.URL.Query()
+	checkParams(&v, query, []string{"older_than_days"})
+
+	var olderThan time.Duration
+	if s := query.Get("older_than_days"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 || days > MaxOlderThanDays {
+			v.Add("older_than_days", "must be a number of days between 0 and %d", MaxOlderThanDays)
+		}
+		olderThan = time.Duration(days) * 24 * time.Hour
+	}
+	if err := v.Err(); err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	count, err := h.db.ArchiveCompleted(r.Context(), olderThan)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ArchiveResult{Archived: count})
+}
+
+// UnarchiveTodo puts an archived todo back in the listings.
+func (h *RouteHandler) UnarchiveTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	todo, err := h.db.Unarchive(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/jwt"
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// •	POST /admin/users {name,subject,role} → 201 with the user, who gets an inbox of their own, subject links the user to the sub claim of JWTs
+// •	POST /admin/keys {userId,name,scopes,expiresAt} → 201 with the key and its secret, which is only ever shown here
+// •	GET /admin/keys?user_id= → the API keys, of one user or of all of them, newest first
+// •	DELETE /admin/keys/:id → 204, the key can't be used anymore
+//
+// Every admin route needs a key with the admin scope.
+//
+// Users have a role that every route checks: viewers can read, editors can
+// also write, and admins can also purge the trash and use the admin routes.
+// The scopes of a key limit its user's role further. Denied requests get a
+// 403 problem.
+//
+// Besides API keys, JWTs of the configured issuer are accepted as bearer
+// tokens. They act for the user linked to their subject, with the scopes of
+// their scope or scp claim.
+
+// MintedAPIKey is the response body of POST /admin/keys.
+type MintedAPIKey struct {
+	models.APIKey
+	// Secret is the key to send as Authorization: Bearer <secret>.
+	Secret string `json:"secret"`
+}
+
+// Authenticate is a middleware.Authenticator for API keys.
+func (h *RouteHandler) Authenticate(ctx context.Context, token string) (middleware.Principal, error) {
+	p, err := h.db.Authenticate(ctx, token)
+	if errors.Is(err, db.ErrNotFound) {
+		return p, fmt.Errorf("%w: unknown, expired or revoked API key", middleware.ErrUnauthenticated)
+	}
+	return p, err
+}
+
+// TokenAuthenticator returns a middleware.Authenticator that accepts API
+// keys as well as the JWTs v verifies, which act for the user linked to their
+// subject.
+func (h *RouteHandler) TokenAuthenticator(v *jwt.Verifier) middleware.Authenticator {
+	return func(ctx context.Context, token string) (middleware.Principal, error) {
+		if strings.HasPrefix(token, db.KeyPrefix) {
+			return h.Authenticate(ctx, token)
+		}
+
+		claims, err := v.Verify(ctx, token)
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			return middleware.Principal{}, fmt.Errorf("%w: %w", middleware.ErrUnauthenticated, err)
+		} else if err != nil {
+			return middleware.Principal{}, err
+		}
+		user, err := h.db.UserBySubject(ctx, claims.Subject)
+		if errors.Is(err, db.ErrNotFound) || claims.Subject == "" {
+			return middleware.Principal{}, fmt.Errorf("%w: no user is linked to the subject of the token", middleware.ErrUnauthenticated)
+		} else if err != nil {
+			return middleware.Principal{}, err
+		}
+
+		p := middleware.Principal{UserId: user.Id, Roles: []string{user.Role}}
+		for _, scope := range claims.Scopes() {
+			if slices.Contains(models.KeyScopes, scope) && !slices.Contains(p.Scopes, scope) {
+				p.Scopes = append(p.Scopes, scope)
+			}
+		}
+		return p, nil
+	}
+}
+
+// AuthFailed responds to requests the auth middleware turned away, asking
+// for a bearer token if there was none or it wasn't valid.
+func AuthFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, middleware.ErrUnauthenticated) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="todos"`)
+	}
+	writeError(w, r, err)
+}
+
+func (h *RouteHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var in models.UserInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	user, err := h.db.CreateUser(r.Context(), in.User())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *RouteHandler) MintKey(w http.ResponseWriter, r *http.Request) {
+	var in models.APIKeyInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	key, secret, err := h.db.MintKey(r.Context(), in.Key())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(MintedAPIKey{APIKey: key, Secret: secret})
+}
+
+func (h *RouteHandler) GetKeys(w http.ResponseWriter, r *http.Request) {
+	var v models.ValidationError
+	query := r.URL.Query()
+	checkParams(&v, query, []string{"user_id"})
+	if err := v.Err(); err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	keys, err := h.db.ListKeys(r.Context(), query.Get("user_id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (h *RouteHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if err := h.db.RevokeKey(r.Context(), params["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
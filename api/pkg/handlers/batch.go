@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/models"
+)
+
+// MaxBatchOperations is the most operations a single batch may hold.
+const MaxBatchOperations = 100
+
+// BatchRequest is the body of POST /todos:batch.
+type BatchRequest struct {
+	// Atomic applies all of the operations or none of them, it defaults to
+	// true.
+	Atomic     *bool            `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one operation of a batch. Body is a POST /todos body to
+// create, and a merge patch to update.
+type BatchOperation struct {
+	Op   db.BatchKind    `json:"op"`
+	Id   string          `json:"id,omitempty"`
+	Body json.RawMessage `json:"body,omitempty"`
+
+	// parsed is filled in by Validate.
+	parsed db.BatchOp
+}
+
+// Validate checks every operation and decodes its body, reporting problems
+// with the index of the operation, e.g. operations[2].body.title.
+func (b *BatchRequest) Validate() error {
+	var v models.ValidationError
+	switch {
+	case len(b.Operations) == 0:
+		v.Add("operations", "must not be empty")
+	case len(b.Operations) > MaxBatchOperations:
+		v.Add("operations", "must not hold more than %d operations", MaxBatchOperations)
+	}
+
+	for i := range b.Operations {
+		op := &b.Operations[i]
+		prefix := fmt.Sprintf("operations[%d].", i)
+		op.parsed = db.BatchOp{Kind: op.Op, Id: op.Id}
+
+		var body input
+		switch op.Op {
+		case db.BatchCreate:
+			if op.Id != "" {
+				v.Add(prefix+"id", "must not be given to create a todo")
+			}
+			body = &models.TodoInput{}
+		case db.BatchUpdate:
+			body = &op.parsed.Patch
+		case db.BatchDelete:
+			if op.Body != nil {
+				v.Add(prefix+"body", "must not be given to delete a todo")
+			}
+		default:
+			v.Add(prefix+"op", "must be create, update or delete")
+			continue
+		}
+		if op.Op != db.BatchCreate && op.Id == "" {
+			v.Add(prefix+"id", "must not be empty")
+		}
+		if body == nil {
+			continue
+		}
+
+		if op.Body == nil {
+			v.Add(prefix+"body", "must not be empty")
+			continue
+		}
+		if err := decodeBatchBody(op.Body, body); err != nil {
+			for _, fe := range err.Errors {
+				field := prefix + "body"
+				if fe.Field != "" {
+					field += "." + fe.Field
+				}
+				v.Add(field, "%s", fe.Message)
+			}
+			continue
+		}
+		if in, ok := body.(*models.TodoInput); ok {
+			op.parsed.Todo = in.Todo()
+		}
+	}
+	return v.Err()
+}
+
+// decodeBatchBody decodes and validates the body of one operation.
+func decodeBatchBody(data []byte, dst input) *models.ValidationError {
+	var v models.ValidationError
+	if err := json.Unmarshal(data, dst); err != nil {
+		fe, ok := fieldErrorFor(err)
+		if !ok || fe.Field == "" {
+			fe = models.FieldError{Message: "must be a JSON object"}
+		}
+		v.Errors = append(v.Errors, fe)
+		return &v
+	}
+	if err := dst.Validate(); err != nil {
+		var validationErr *models.ValidationError
+		if errors.As(err, &validationErr) {
+			return validationErr
+		}
+		v.Add("", "%v", err)
+		return &v
+	}
+	return nil
+}
+
+// BatchResponse is the response body of POST /todos:batch, with a result for
+// every operation, in order.
+type BatchResponse struct {
+	Results []BatchOperationResult `json:"results"`
+}
+
+// BatchOperationResult is the status and body a single request for the
+// operation would have been answered with, a problem if it failed.
+type BatchOperationResult struct {
+	Status int `json:"status"`
+	Body   any `json:"body,omitempty"`
+}
+
+// BatchTodos runs a list of create, update and delete operations in a single
+// transaction. Unless atomic is false, one failing operation rolls back all
+// of them, and the others are answered with 424 Failed Dependency. The
+// response is 200 either way, with the outcome of each operation.
+func (h *RouteHandler) BatchTodos(w http.ResponseWriter, r *http.Request) {
+	var in BatchRequest
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	ops := make([]db.BatchOp, len(in.Operations))
+	for i, op := range in.Operations {
+		ops[i] = op.parsed
+	}
+	atomic := in.Atomic == nil || *in.Atomic
+	results, err := h.db.Batch(r.Context(), ops, atomic)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	response := BatchResponse{Results: make([]BatchOperationResult, len(results))}
+	for i, result := range results {
+		switch {
+		case result.Err != nil:
+			p := ProblemForError(result.Err)
+			if p.Status >= http.StatusInternalServerError {
+				log.Printf("Error handling %s %s operation %d: %v", r.Method, r.URL.Path, i, result.Err)
+			}
+			response.Results[i] = BatchOperationResult{Status: p.Status, Body: p}
+		case ops[i].Kind == db.BatchCreate:
+			response.Results[i] = BatchOperationResult{Status: http.StatusCreated, Body: result.Todo}
+		case ops[i].Kind == db.BatchUpdate:
+			response.Results[i] = BatchOperationResult{Status: http.StatusOK, Body: result.Todo}
+		default:
+			response.Results[i] = BatchOperationResult{Status: http.StatusNoContent}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
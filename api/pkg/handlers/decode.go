@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"example.com/todos/pkg/models"
+)
+
+// MaxBodyBytes is the largest request body the API accepts.
+const MaxBodyBytes = 64 << 10
+
+// input is a request body that can check itself once decoded.
+type input interface {
+	Validate() error
+}
+
+// decodeInput strictly decodes the JSON body of r into dst and validates it.
+// On failure it writes a problem response and returns false. The body must
+// have one of mediaTypes, or application/json if none are given.
+func decodeInput(w http.ResponseWriter, r *http.Request, dst input, mediaTypes ...string) bool {
+	if err := decodeJSON(w, r, dst, mediaTypes...); err != nil {
+		writeInputError(w, r, err)
+		return false
+	}
+	if err := dst.Validate(); err != nil {
+		writeInputError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// decodeJSON decodes the body of r into dst, rejecting unknown fields,
+// trailing data and bodies larger than MaxBodyBytes.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any, mediaTypes ...string) error {
+	if len(mediaTypes) == 0 {
+		mediaTypes = []string{"application/json"}
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || !slices.Contains(mediaTypes, mediaType) {
+			return &unsupportedMediaTypeError{mediaTypes}
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errTrailingData
+	}
+	return nil
+}
+
+// mediaType returns the media type of the body of r, without parameters.
+func mediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+var errTrailingData = errors.New("request body must contain a single JSON value")
+
+type unsupportedMediaTypeError struct {
+	accepted []string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	return "request body must be " + strings.Join(e.accepted, " or ")
+}
+
+// writeInputError responds with the problem for an error from decoding or
+// validating a request body.
+func writeInputError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		mediaTypeErr *unsupportedMediaTypeError
+		maxBytesErr  *http.MaxBytesError
+		syntaxErr    *json.SyntaxError
+	)
+
+	switch {
+	case errors.As(err, &mediaTypeErr):
+		WriteProblem(w, r, NewProblem(http.StatusUnsupportedMediaType, err.Error()))
+	case errors.As(err, &maxBytesErr):
+		WriteProblem(w, r, NewProblem(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit)))
+	case errors.Is(err, io.EOF):
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, "request body must not be empty"))
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, "request body is not valid JSON"))
+	case errors.Is(err, errTrailingData):
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, err.Error()))
+	default:
+		if fe, ok := fieldErrorFor(err); ok {
+			err = &models.ValidationError{Errors: []models.FieldError{fe}}
+		}
+		var validationErr *models.ValidationError
+		if errors.As(err, &validationErr) {
+			WriteProblem(w, r, ProblemForError(err))
+			return
+		}
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, err.Error()))
+	}
+}
+
+// fieldErrorFor turns a decoding error caused by a single field, a value of
+// the wrong type or an unknown field, into a FieldError.
+func fieldErrorFor(err error) (models.FieldError, bool) {
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "" && typeErr.Type == reflect.TypeFor[time.Time]():
+		return models.FieldError{Field: typeErr.Field, Message: "must be an RFC 3339 timestamp"}, true
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return models.FieldError{Field: typeErr.Field, Message: "must be a JSON " + jsonKind(typeErr.Type.Kind().String())}, true
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return models.FieldError{Field: field, Message: "is not a known field"}, true
+	default:
+		return models.FieldError{}, false
+	}
+}
+
+// jsonKind names a Go kind the way a client sending JSON would think of it.
+func jsonKind(kind string) string {
+	switch kind {
+	case "bool":
+		return "boolean"
+	case "string":
+		return "string"
+	case "slice", "array":
+		return "array"
+	case "struct", "map":
+		return "object"
+	default:
+		return "number"
+	}
+}
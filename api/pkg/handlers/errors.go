@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/jwt"
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// StatusForError translates an error returned by a Database, or by decoding
+// and applying a request, into the HTTP status code to respond with.
+func StatusForError(err error) int {
+	var (
+		validationErr *models.ValidationError
+		patchErr      *PatchError
+	)
+
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, middleware.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, middleware.ErrForbidden):
+		return http.StatusForbidden
+	case errors.As(err, &patchErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrUnavailable),
+		errors.Is(err, jwt.ErrKeysUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// pgDetails are the details sent for the database errors that are the
+// client's fault, by SQLSTATE. The messages of the errors name tables,
+// constraints and values, so they are only logged.
+var pgDetails = map[string]string{
+	"23505": "the resource conflicts with one that already exists",
+	"23503": "the resource refers to one that does not exist, or is still referred to",
+	"23P01": "the resource conflicts with another one",
+	"23502": "a required value is missing",
+	"23514": "a value is not allowed",
+	"22P02": "a value is malformed",
+	"22003": "a number is out of range",
+	"22001": "a value is too long",
+	"22007": "a date or time is malformed",
+	"22008": "a date or time is out of range",
+}
+
+// ProblemForError builds the problem response for err. Server side failures
+// get no detail so that internals aren't leaked to clients, and neither do
+// database errors, which are logged instead.
+func ProblemForError(err error) Problem {
+	status := StatusForError(err)
+
+	var detail string
+	var pgErr *pgconn.PgError
+	var validationErr *models.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		p := NewProblem(status, "the request failed validation")
+		p.Errors = validationErr.Errors
+		return p
+	case errors.Is(err, jwt.ErrKeysUnavailable):
+		detail = "the token signing keys are temporarily unavailable, please retry"
+	case status == http.StatusServiceUnavailable:
+		detail = "the database is temporarily unavailable, please retry"
+	case status >= http.StatusInternalServerError:
+	case errors.Is(err, db.ErrNotFound):
+		detail = "the requested resource does not exist"
+	case errors.As(err, &pgErr):
+		log.Printf("Database error answered with %d: %v", status, err)
+		detail = pgDetails[pgErr.Code]
+		if detail == "" {
+			detail = "the database rejected the request"
+		}
+	default:
+		detail = err.Error()
+	}
+
+	return NewProblem(status, detail)
+}
+
+// writeError responds with the problem for err. Server side failures are
+// logged since their details are not sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := ProblemForError(err)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("Error handling %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	WriteProblem(w, r, p)
+}
@@ -0,0 +1,42 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"example.com/todos/pkg/db"
+	. "example.com/todos/pkg/handlers"
+	"example.com/todos/pkg/jwt"
+	"example.com/todos/pkg/middleware"
+)
+
+// TestStatusForError verifies that database errors are translated into the
+// matching HTTP status, including when they are wrapped.
+func TestStatusForError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{db.ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("getting todo: %w", db.ErrNotFound), http.StatusNotFound},
+		{db.ErrConflict, http.StatusConflict},
+		{db.ErrInvalid, http.StatusBadRequest},
+		{db.ErrUnavailable, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: connection refused", db.ErrUnavailable), http.StatusServiceUnavailable},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{fmt.Errorf("%w: %w", middleware.ErrUnauthenticated, jwt.ErrInvalidToken), http.StatusUnauthorized},
+		{middleware.ErrForbidden, http.StatusForbidden},
+		{fmt.Errorf("%w: connection refused", jwt.ErrKeysUnavailable), http.StatusServiceUnavailable},
+		{errors.New("something unexpected"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := StatusForError(tt.err); got != tt.want {
+			t.Errorf("StatusForError(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"example.com/todos/pkg/models"
+)
+
+// ErrPreconditionFailed is returned when a todo doesn't match the If-Match
+// header, because someone else changed it in the meantime.
+var ErrPreconditionFailed = errors.New("the todo has changed since it was read, its ETag does not match If-Match")
+
+// ETag returns the entity tag of a todo, its quoted version.
+func ETag(todo models.Todo) string {
+	return `"` + strconv.Itoa(todo.Version) + `"`
+}
+
+// writeTodo responds with the todo and its ETag.
+func writeTodo(w http.ResponseWriter, status int, todo models.Todo) {
+	w.Header().Set("ETag", ETag(todo))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(todo)
+}
+
+// checkIfMatch returns ErrPreconditionFailed if r has an If-Match header that
+// doesn't match the todo.
+func checkIfMatch(r *http.Request, todo models.Todo) error {
+	header := r.Header.Values("If-Match")
+	if len(header) == 0 || matchETag(header, ETag(todo), false) {
+		return nil
+	}
+	return ErrPreconditionFailed
+}
+
+// ifMatch wraps fn for UpdateFunc so that nothing is written unless the todo
+// matches the If-Match header of r, which is checked on the locked row.
+func ifMatch(r *http.Request, fn func(todo models.Todo) (models.Todo, error)) func(todo models.Todo) (models.Todo, error) {
+	return func(todo models.Todo) (models.Todo, error) {
+		if err := checkIfMatch(r, todo); err != nil {
+			return models.Todo{}, err
+		}
+		return fn(todo)
+	}
+}
+
+// notModified reports whether r has an If-None-Match header that matches the
+// todo, so the client's copy is up to date.
+func notModified(r *http.Request, todo models.Todo) bool {
+	header := r.Header.Values("If-None-Match")
+	return len(header) > 0 && matchETag(header, ETag(todo), true)
+}
+
+// matchETag reports whether one of the comma separated entity tags in header
+// is etag, or "*". Weak tags only match when weak comparison is allowed.
+func matchETag(header []string, etag string, weak bool) bool {
+	for _, value := range header {
+		for tag := range strings.SplitSeq(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" {
+				return true
+			}
+			if opaque, ok := strings.CutPrefix(tag, "W/"); ok {
+				if !weak {
+					continue
+				}
+				tag = opaque
+			}
+			if tag == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,20 +1,96 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/middleware"
 	"example.com/todos/pkg/models"
 
 	"github.com/gorilla/mux"
 )
 
 type Database interface {
-	Create(todo models.Todo) (id string, err error)
-	Get(id string) (todo models.Todo, err error)
-	GetAll() (todos []models.Todo, err error)
-	Update(id string, todo models.Todo) (count int64, err error)
-	Delete(id string) (count int64, err error)
+	Create(ctx context.Context, todo models.Todo) (created models.Todo, err error)
+	Get(ctx context.Context, id string) (todo models.Todo, err error)
+	// List returns a page of todos ordered by creation time.
+	List(ctx context.Context, opts db.ListOptions) (page db.Page, err error)
+	// Search returns the todos matching a full-text query, best first.
+	Search(ctx context.Context, opts db.SearchOptions) (results []models.SearchResult, err error)
+	// UpdateFunc atomically replaces the writable fields of a todo with the
+	// result of fn, writing nothing if fn returns an error.
+	UpdateFunc(ctx context.Context, id string, fn func(todo models.Todo) (models.Todo, error)) (updated models.Todo, err error)
+	// Restore takes a todo out of the trash with the subtasks deleted
+	// along with it.
+	Restore(ctx context.Context, id string) (restored models.Todo, err error)
+	// Purge permanently deletes a todo in the trash.
+	Purge(ctx context.Context, id string) error
+	// PurgeTrash permanently deletes the todos that have been in the trash
+	// for longer than olderThan, or all of them if it is 0.
+	PurgeTrash(ctx context.Context, olderThan time.Duration) (count int64, err error)
+	// ArchiveCompleted archives every done todo completed more than
+	// olderThan ago, in a single statement.
+	ArchiveCompleted(ctx context.Context, olderThan time.Duration) (count int64, err error)
+	// Unarchive puts an archived todo back in the listings.
+	Unarchive(ctx context.Context, id string) (todo models.Todo, err error)
+	// Batch runs create, update and delete operations in one transaction,
+	// all or nothing if atomic is set.
+	Batch(ctx context.Context, ops []db.BatchOp, atomic bool) (results []db.BatchResult, err error)
+	// DeleteFunc moves a todo and its subtasks to the trash unless check,
+	// called with the locked todo, returns an error.
+	DeleteFunc(ctx context.Context, id string, check func(todo models.Todo) error) (count int64, err error)
+	// Move places a todo right before or after one of its siblings.
+	Move(ctx context.Context, id string, move models.TodoMove) (moved models.Todo, err error)
+	// Descendants returns every subtask of a todo, however deeply nested.
+	Descendants(ctx context.Context, id string) (todos []models.Todo, err error)
+	// Series returns the todos in the recurring series of a todo, ordered
+	// by due date.
+	Series(ctx context.Context, id string) (todos []models.Todo, err error)
+
+	ListTags(ctx context.Context) (tags []models.Tag, err error)
+	CreateTag(ctx context.Context, name string) (tag models.Tag, err error)
+	GetTag(ctx context.Context, id string) (tag models.Tag, err error)
+	// RenameTag renames a tag on every todo it labels.
+	RenameTag(ctx context.Context, id string, name string) (tag models.Tag, err error)
+	// DeleteTag deletes a tag and removes it from every todo.
+	DeleteTag(ctx context.Context, id string) error
+
+	// GetLists returns every list, the inbox first.
+	GetLists(ctx context.Context) (lists []models.List, err error)
+	CreateList(ctx context.Context, name string) (list models.List, err error)
+	GetList(ctx context.Context, id string) (list models.List, err error)
+	RenameList(ctx context.Context, id string, name string) (list models.List, err error)
+	// DeleteList deletes a list, moving its todos to the inbox, and to the
+	// trash as well if cascade is set.
+	DeleteList(ctx context.Context, id string, cascade bool) error
+	// ListMembers returns the owner of a list followed by its members.
+	ListMembers(ctx context.Context, listId string) (members []models.Member, err error)
+	SetMemberRole(ctx context.Context, listId, userId, role string) (member models.Member, err error)
+	// RemoveMember stops sharing a list with a user.
+	RemoveMember(ctx context.Context, listId, userId string) error
+	// Invite creates an invitation to a list and returns it with its token.
+	Invite(ctx context.Context, listId string, inv models.Invitation) (created models.Invitation, token string, err error)
+	// AcceptInvitation makes the caller a member of the list a token invites
+	// to, failing with db.ErrNotFound for unknown, expired and used tokens.
+	AcceptInvitation(ctx context.Context, token string) (member models.Member, err error)
+	// TransferList makes a member the owner of a list and its todos.
+	TransferList(ctx context.Context, listId, userId string) (list models.List, err error)
+
+	// CreateUser adds a user with an empty inbox.
+	CreateUser(ctx context.Context, user models.User) (created models.User, err error)
+	// UserBySubject returns the user linked to the sub claim of a JWT.
+	UserBySubject(ctx context.Context, subject string) (user models.User, err error)
+	// MintKey creates an API key and returns it with its secret.
+	MintKey(ctx context.Context, key models.APIKey) (minted models.APIKey, secret string, err error)
+	// ListKeys returns the API keys of a user, or of every user without one.
+	ListKeys(ctx context.Context, userId string) (keys []models.APIKey, err error)
+	RevokeKey(ctx context.Context, id string) error
+	// Authenticate returns the principal of an API key secret, failing with
+	// db.ErrNotFound for unknown, expired and revoked keys.
+	Authenticate(ctx context.Context, secret string) (p middleware.Principal, err error)
 }
 
 type RouteHandler struct {
@@ -32,54 +108,173 @@ func Healthy(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// •	POST /todos {title:string} → 201 with {id,title,done:false}
-// •	GET /todos → list
-// •	PATCH /todos/:id {done:bool} → 200
-// •	DELETE /todos/:id → 204
-func (h *RouteHandler) GetTodos(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	todos, _ := h.db.GetAll()
-	json.NewEncoder(w).Encode(todos)
-}
-
+// •	POST /todos {listId,parentId,title,description,done,priority,dueAt,tags,recurrence} → 201 with the todo, in the inbox without a listId
+// •	GET /todos/:id?expand=children → the todo, with its subtasks nested under children, and its ETag unless expanded
+// •	GET /todos/:id/series → the todos in a recurring series, see GetTodoSeries
+// •	GET /todos → a filtered and sorted page of todos, see GetTodos
+// •	GET /todos/search?q= → todos matching a full-text query
+// •	PATCH /todos/:id {done:bool} → 200 with the updated todo, completing a recurring todo creates the next one
+// •	PATCH, PUT and DELETE /todos/:id take If-Match with an ETag, 412 if the todo has changed since
+// •	PUT /todos/:id {listId,parentId,title,description,done,priority,dueAt,tags,recurrence} → 200 with the replaced todo
+// •	DELETE /todos/:id → 204, the todo and its subtasks go to the trash
+// •	POST /todos/archive-completed?older_than_days= → 200 with the number of archived todos
+// •	POST /todos/:id/unarchive → 200 with the todo, listed by GET /todos again
+// •	GET /todos/trash → a page of deleted todos, see GetTrash
+// •	POST /todos/:id/restore → 200 with the todo taken out of the trash
+// •	DELETE /todos/trash/:id → 204, permanently, DELETE /todos/trash empties the trash
+// •	POST /todos:batch {atomic,operations} → 200 with the status and body of each operation, see BatchTodos
+// •	POST /todos/:id/move {before|after} → 200 with the todo, placed next to a sibling for sort=position
+// •	GET, POST /tags and GET, PUT, DELETE /tags/:id, see tags.go
+// •	GET, POST /lists, GET, PUT, DELETE /lists/:id and /lists/:id/todos, see lists.go
+// •	every route acts for the principal in the request context, other users' todos, lists and tags are 404
 func (h *RouteHandler) GetTodo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
 	params := mux.Vars(r)
-	todo, err := h.db.Get(params["id"])
+
+	var v models.ValidationError
+	query := r.URL.Query()
+	checkParams(&v, query, []string{"expand"})
+	expand := query.Get("expand")
+	if expand != "" && expand != "children" {
+		v.Add("expand", "must be children")
+	}
+	if err := v.Err(); err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	todo, err := h.db.Get(r.Context(), params["id"])
 	if err != nil {
-		http.NotFound(w, r)
+		writeError(w, r, err)
+		return
+	}
+	if expand == "children" {
+		descendants, err := h.db.Descendants(r.Context(), todo.Id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// the ETag is only the version of the todo itself, which doesn't
+		// cover its subtasks
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(todo.WithChildren(descendants))
+		return
 	}
-	json.NewEncoder(w).Encode(todo)
+
+	if notModified(r, todo) {
+		w.Header().Set("ETag", ETag(todo))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
 }
 
+// acceptPatch lists the patch formats UpdateTodo understands.
+const acceptPatch = "application/merge-patch+json, " + JSONPatchContentType
+
+// UpdateTodo applies a JSON Merge Patch, only the fields present in the body
+// are changed, or a JSON Patch when sent as application/json-patch+json.
+// Like PUT and DELETE it fails with 412 if there is an If-Match header that
+// doesn't match the todo's ETag.
 func (h *RouteHandler) UpdateTodo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if mediaType(r) == JSONPatchContentType {
+		h.jsonPatchTodo(w, r)
+		return
+	}
+
+	params := mux.Vars(r)
+	var patch models.TodoPatch
+	if !decodeInput(w, r, &patch, "application/json", "application/merge-patch+json") {
+		return
+	}
+
+	todo, err := h.db.UpdateFunc(r.Context(), params["id"], ifMatch(r, func(todo models.Todo) (models.Todo, error) {
+		return patch.Apply(todo), nil
+	}))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
+}
+
+// jsonPatchTodo applies an RFC 6902 JSON Patch inside a transaction, so a
+// failing "test" operation works as a compare-and-set.
+func (h *RouteHandler) jsonPatchTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var patch JSONPatch
+	if !decodeInput(w, r, &patch, JSONPatchContentType) {
+		return
+	}
+
+	todo, err := h.db.UpdateFunc(r.Context(), params["id"], ifMatch(r, func(todo models.Todo) (models.Todo, error) {
+		return applyJSONPatch(todo, patch)
+	}))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
+}
+
+// ReplaceTodo replaces every writable field of the todo with the body.
+func (h *RouteHandler) ReplaceTodo(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
-	var todo models.Todo
-	_ = json.NewDecoder(r.Body).Decode(&todo)
-	_, err := h.db.Update(params["id"], todo)
+	var in models.TodoInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	todo, err := h.db.UpdateFunc(r.Context(), params["id"], ifMatch(r, func(models.Todo) (models.Todo, error) {
+		return in.Todo(), nil
+	}))
 	if err != nil {
-		http.NotFound(w, r)
+		writeError(w, r, err)
+		return
 	}
+	writeTodo(w, http.StatusOK, todo)
 }
 
 func (h *RouteHandler) CreateTodo(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var todo models.Todo
-	_ = json.NewDecoder(r.Body).Decode(&todo)
+	var in models.TodoInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	todo, err := h.db.Create(r.Context(), in.Todo())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusCreated, todo)
+}
 
-	id, _ := h.db.Create(todo)
-	todo.Id = id
+// MoveTodo places the todo right before or after another todo in the same
+// list and under the same parent, in the order listed by sort=position.
+func (h *RouteHandler) MoveTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.TodoMove
+	if !decodeInput(w, r, &in) {
+		return
+	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(todo)
+	todo, err := h.db.Move(r.Context(), params["id"], in)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
 }
 
 func (h *RouteHandler) DeleteTodo(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 
-	_, err := h.db.Delete(params["id"])
+	_, err := h.db.DeleteFunc(r.Context(), params["id"], func(todo models.Todo) error {
+		return checkIfMatch(r, todo)
+	})
 	if err != nil {
-		http.NotFound(w, r)
+		writeError(w, r, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
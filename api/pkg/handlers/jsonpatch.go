@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"example.com/todos/pkg/models"
+)
+
+// JSONPatchContentType is the media type of RFC 6902 JSON Patch documents.
+const JSONPatchContentType = "application/json-patch+json"
+
+// ErrTestFailed is returned when a "test" operation doesn't match, which
+// leaves the document unchanged.
+var ErrTestFailed = errors.New("test operation failed")
+
+// PatchOperation is a single operation of a JSON Patch document.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON decodes an operation, ignoring unknown members as RFC 6902
+// requires.
+func (op *PatchOperation) UnmarshalJSON(data []byte) error {
+	type plain PatchOperation
+	return json.Unmarshal(data, (*plain)(op))
+}
+
+// JSONPatch is an RFC 6902 JSON Patch document: a list of operations applied
+// in order, all or nothing.
+type JSONPatch []PatchOperation
+
+// Validate checks that every operation is well formed before any of them is
+// applied.
+func (p JSONPatch) Validate() error {
+	var v models.ValidationError
+	for i, op := range p {
+		field := func(name string) string {
+			return fmt.Sprintf("[%d].%s", i, name)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				v.Add(field("value"), "is required for %q", op.Op)
+			}
+		case "move", "copy":
+			if _, err := parsePointer(op.From); err != nil {
+				v.Add(field("from"), "%v", err)
+			}
+		case "remove":
+		default:
+			v.Add(field("op"), "must be one of add, remove, replace, move, copy or test")
+		}
+
+		if _, err := parsePointer(op.Path); err != nil {
+			v.Add(field("path"), "%v", err)
+		}
+	}
+	return v.Err()
+}
+
+// PatchError reports which operation of a JSON Patch could not be applied.
+type PatchError struct {
+	Index int
+	Op    PatchOperation
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %v", e.Index, e.Op.Op, e.Op.Path, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// Apply applies the patch to a document decoded with json.Decoder.UseNumber
+// and returns the patched document. The input is not modified.
+func (p JSONPatch) Apply(doc any) (any, error) {
+	doc = deepCopy(doc)
+	for i, op := range p {
+		var err error
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, &PatchError{Index: i, Op: op, Err: err}
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "remove":
+		doc, _, err := removeValue(doc, path)
+		return doc, err
+	case "replace":
+		value, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		if doc, _, err = removeValue(doc, path); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > len(from) && isPrefix(from, path) {
+			return nil, errors.New("cannot move a value into one of its children")
+		}
+		doc, value, err := removeValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, value)
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, deepCopy(value))
+	case "test":
+		want, err := decodeValue(op.Value)
+		if err != nil {
+			return nil, err
+		}
+		got, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(got, want) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q is not a JSON pointer", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func getValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot look up %q in a scalar value", token)
+		}
+	}
+	return doc, nil
+}
+
+// addValue sets the value at path, inserting into arrays, and returns the
+// new document.
+func addValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		i := len(node)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node[:i], append([]any{value}, node[i:]...)...)
+		return replaceChild(doc, path[:len(path)-1], node)
+	default:
+		return nil, fmt.Errorf("cannot add %q to a scalar value", last)
+	}
+}
+
+// removeValue deletes the value at path and returns the new document along
+// with the removed value.
+func removeValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q does not exist", last)
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []any:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = replaceChild(doc, path[:len(path)-1], node)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("cannot remove %q from a scalar value", last)
+	}
+}
+
+// replaceChild swaps the array at path for a resized copy, since slices
+// can't grow in place inside their parent.
+func replaceChild(doc any, path []string, child []any) (any, error) {
+	if len(path) == 0 {
+		return child, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = child
+	case []any:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %d is out of bounds", i)
+	}
+	return i, nil
+}
+
+func decodeValue(raw json.RawMessage) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	return value, nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, child := range v {
+			c[key] = deepCopy(child)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, child := range v {
+			c[i] = deepCopy(child)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// jsonEqual compares two JSON values as RFC 6902 defines for "test", so
+// numbers are equal when their values are, regardless of formatting.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, _, errA := big.ParseFloat(string(a), 10, 256, big.ToNearestEven)
+		y, _, errB := big.ParseFloat(string(b), 10, 256, big.ToNearestEven)
+		return errA == nil && errB == nil && x.Cmp(y) == 0
+	default:
+		return a == b
+	}
+}
+
+// readOnlyFields are the members of a todo a JSON Patch may not change.
+var readOnlyFields = []string{"id", "ownerId", "createdAt", "updatedAt", "completedAt", "version", "seriesId", "position", "archivedAt", "progress"}
+
+// applyJSONPatch applies patch to todo. Only the writable fields of a todo
+// may change, and the result must pass the same validation as PUT.
+func applyJSONPatch(todo models.Todo, patch JSONPatch) (models.Todo, error) {
+	encoded, err := json.Marshal(todo)
+	if err != nil {
+		return models.Todo{}, err
+	}
+	doc, err := decodeValue(encoded)
+	if err != nil {
+		return models.Todo{}, err
+	}
+
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		return models.Todo{}, err
+	}
+
+	var v models.ValidationError
+	fields, ok := patched.(map[string]any)
+	if !ok {
+		v.Add("", "must be a JSON object")
+		return models.Todo{}, v.Err()
+	}
+	original := doc.(map[string]any)
+	for _, name := range readOnlyFields {
+		if !jsonEqual(fields[name], original[name]) {
+			v.Add(name, "is read-only")
+		}
+		delete(fields, name)
+	}
+	if err := v.Err(); err != nil {
+		return models.Todo{}, err
+	}
+
+	// whatever is left must be a valid PUT body
+	encoded, err = json.Marshal(fields)
+	if err != nil {
+		return models.Todo{}, err
+	}
+	var in models.TodoInput
+	if err := json.Unmarshal(encoded, &in); err != nil {
+		fe, ok := fieldErrorFor(err)
+		if !ok {
+			return models.Todo{}, err
+		}
+		v.Errors = append(v.Errors, fe)
+		return models.Todo{}, v.Err()
+	}
+	if err := in.Validate(); err != nil {
+		return models.Todo{}, err
+	}
+
+	result := in.Todo()
+	result.Id = todo.Id
+	result.OwnerId = todo.OwnerId
+	result.CreatedAt = todo.CreatedAt
+	result.UpdatedAt = todo.UpdatedAt
+	result.CompletedAt = todo.CompletedAt
+	result.Version = todo.Version
+	result.SeriesId = todo.SeriesId
+	result.Position = todo.Position
+	result.ArchivedAt = todo.ArchivedAt
+	return result, nil
+}
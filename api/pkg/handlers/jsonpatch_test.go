@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "example.com/todos/pkg/handlers"
+)
+
+func decodeDoc(t *testing.T, s string) any {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatalf("failed to decode %s, %v", s, err)
+	}
+	return doc
+}
+
+func decodePatch(t *testing.T, s string) JSONPatch {
+	t.Helper()
+	var patch JSONPatch
+	if err := json.Unmarshal([]byte(s), &patch); err != nil {
+		t.Fatalf("failed to decode patch %s, %v", s, err)
+	}
+	if err := patch.Validate(); err != nil {
+		t.Fatalf("expected patch %s to be valid, got %v", s, err)
+	}
+	return patch
+}
+
+// TestJSONPatch_Apply runs the examples from RFC 6902 appendix A.
+func TestJSONPatch_Apply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"add object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"append array element", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`},
+		{"remove object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"copy value", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`},
+		{"test value", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{"add nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"add array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"escaped pointer", `{"a/b":0,"m~n":1}`, `[{"op":"replace","path":"/a~1b","value":2},{"op":"remove","path":"/m~0n"}]`, `{"a/b":2}`},
+		{"replace whole document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":"qux"}}]`, `{"baz":"qux"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decodeDoc(t, tt.doc)
+			original, _ := json.Marshal(doc)
+
+			got, err := decodePatch(t, tt.patch).Apply(doc)
+			if err != nil {
+				t.Fatalf("failed to apply patch, %v", err)
+			}
+
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(decodeDoc(t, tt.want))
+			if !bytes.Equal(gotJSON, wantJSON) {
+				t.Fatalf("expected %s, got %s", wantJSON, gotJSON)
+			}
+
+			after, _ := json.Marshal(doc)
+			if !bytes.Equal(original, after) {
+				t.Fatalf("patch modified its input, before %s after %s", original, after)
+			}
+		})
+	}
+}
+
+// TestJSONPatch_ApplyErrors verifies that failing operations report which
+// operation failed.
+func TestJSONPatch_ApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		index int
+	}{
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, 0},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, 0},
+		{"replace missing member", `{"foo":"bar"}`, `[{"op":"test","path":"/foo","value":"bar"},{"op":"replace","path":"/baz","value":1}]`, 1},
+		{"array index out of bounds", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"baz"}]`, 0},
+		{"array index with leading zero", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`, 0},
+		{"move into own child", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodePatch(t, tt.patch).Apply(decodeDoc(t, tt.doc))
+			var patchErr *PatchError
+			if !errors.As(err, &patchErr) {
+				t.Fatalf("expected a *PatchError, got %v", err)
+			}
+			if patchErr.Index != tt.index {
+				t.Fatalf("expected operation %d to fail, got %d", tt.index, patchErr.Index)
+			}
+		})
+	}
+
+	_, err := decodePatch(t, `[{"op":"test","path":"/baz","value":"qux"}]`).Apply(decodeDoc(t, `{"baz":"quux"}`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("expected ErrTestFailed, got %v", err)
+	}
+}
+
+// TestJSONPatch_Validate ensures malformed operations are rejected before
+// anything is applied.
+func TestJSONPatch_Validate(t *testing.T) {
+	for _, s := range []string{
+		`[{"op":"frobnicate","path":"/foo"}]`,
+		`[{"op":"add","path":"/foo"}]`,
+		`[{"op":"replace","path":"foo","value":1}]`,
+		`[{"op":"move","from":"bar","path":"/foo"}]`,
+	} {
+		var patch JSONPatch
+		if err := json.Unmarshal([]byte(s), &patch); err != nil {
+			t.Fatalf("failed to decode patch %s, %v", s, err)
+		}
+		if err := patch.Validate(); err == nil {
+			t.Errorf("expected patch %s to be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/models"
+)
+
+// TodoPage is the response body of GET /todos?envelope=true.
+type TodoPage struct {
+	Items      []models.Todo `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// GetTodos lists todos a page at a time. The next page is linked from the
+// Link header, and also returned in the body when envelope=true.
+//
+// Todos can be filtered with done, created_after, created_before,
+// title_contains, priority (a comma separated list), due_after, due_before
+// overdue and tag, which can be repeated and matches todos with all of the
+// tags, or any of them with tag_match=any. Archived todos are only listed,
+// on their own, with archived=true. They are ordered with sort, e.g.
+// sort=-priority,dueAt.
+func (h *RouteHandler) GetTodos(w http.ResponseWriter, r *http.Request) {
+	opts, envelope, err := parseListQuery(r)
+	if err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+	h.writeTodoPage(w, r, opts, envelope)
+}
+
+// writeTodoPage responds with the page of todos selected by opts.
+func (h *RouteHandler) writeTodoPage(w http.ResponseWriter, r *http.Request, opts db.ListOptions, envelope bool) {
+	page, err := h.db.List(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		next := *r.URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if envelope {
+		json.NewEncoder(w).Encode(TodoPage{Items: page.Todos, NextCursor: page.NextCursor})
+		return
+	}
+	json.NewEncoder(w).Encode(page.Todos)
+}
+
+// listParams are the query parameters GetTodos understands, anything else
+// is rejected so that a misspelled filter isn't silently ignored.
+var listParams = []string{
+	"limit", "cursor", "envelope", "sort",
+	"done", "created_after", "created_before", "title_contains",
+	"priority", "due_after", "due_before", "overdue", "tag", "tag_match", "archived",
+}
+
+// parseListQuery reads the paging, sorting and filtering query parameters.
+func parseListQuery(r *http.Request) (opts db.ListOptions, envelope bool, err error) {
+	var v models.ValidationError
+	query := r.URL.Query()
+
+	checkParams(&v, query, listParams)
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > db.MaxListLimit {
+			v.Add("limit", "must be a number between 1 and %d", db.MaxListLimit)
+		}
+		opts.Limit = limit
+	}
+
+	if s := query.Get("envelope"); s != "" {
+		if envelope, err = strconv.ParseBool(s); err != nil {
+			v.Add("envelope", "must be true or false")
+		}
+	}
+
+	if s := query.Get("sort"); s != "" {
+		sort, err := db.ParseSort(s)
+		if err != nil {
+			v.Add("sort", "%v", err)
+		}
+		opts.Sort = sort
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		if _, err := db.DecodeCursor(s, opts.Sort); err != nil {
+			v.Add("cursor", "is not a cursor returned by this API for the same sort")
+		}
+		opts.Cursor = s
+	}
+
+	if s := query.Get("done"); s != "" {
+		done, err := strconv.ParseBool(s)
+		if err != nil {
+			v.Add("done", "must be true or false")
+		}
+		opts.Filter.Done = &done
+	}
+
+	opts.Filter.CreatedAfter = parseTimeParam(&v, query, "created_after")
+	opts.Filter.CreatedBefore = parseTimeParam(&v, query, "created_before")
+	if after, before := opts.Filter.CreatedAfter, opts.Filter.CreatedBefore; !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		v.Add("created_before", "must be later than created_after")
+	}
+
+	if s := query.Get("title_contains"); utf8.RuneCountInString(s) > models.MaxTitleLength {
+		v.Add("title_contains", "must be at most %d characters", models.MaxTitleLength)
+	} else {
+		opts.Filter.TitleContains = s
+	}
+
+	if s := query.Get("priority"); s != "" {
+		for name := range strings.SplitSeq(s, ",") {
+			p := models.Priority(name)
+			if !p.Valid() {
+				v.Add("priority", "%q is not a priority", name)
+			}
+			opts.Filter.Priorities = append(opts.Filter.Priorities, p)
+		}
+	}
+
+	opts.Filter.DueAfter = parseTimeParam(&v, query, "due_after")
+	opts.Filter.DueBefore = parseTimeParam(&v, query, "due_before")
+	if after, before := opts.Filter.DueAfter, opts.Filter.DueBefore; !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		v.Add("due_before", "must be later than due_after")
+	}
+
+	if s := query.Get("overdue"); s != "" {
+		overdue, err := strconv.ParseBool(s)
+		if err != nil {
+			v.Add("overdue", "must be true or false")
+		}
+		opts.Filter.Overdue = &overdue
+	}
+
+	archived := false
+	if s := query.Get("archived"); s != "" {
+		if archived, err = strconv.ParseBool(s); err != nil {
+			v.Add("archived", "must be true or false")
+		}
+	}
+	opts.Filter.Archived = &archived
+
+	for i, tag := range query["tag"] {
+		name, err := models.NormalizeTag(tag)
+		if err != nil {
+			v.Add(fmt.Sprintf("tag[%d]", i), "%v", err)
+		}
+		opts.Filter.Tags = append(opts.Filter.Tags, name)
+	}
+
+	switch query.Get("tag_match") {
+	case "", "all":
+		opts.Filter.AllTags = true
+	case "any":
+	default:
+		v.Add("tag_match", "must be any or all")
+	}
+
+	return opts, envelope, v.Err()
+}
+
+// parseTimeParam reads a query parameter holding an RFC 3339 timestamp or a
+// date, which means midnight UTC.
+func parseTimeParam(v *models.ValidationError, query url.Values, name string) time.Time {
+	s := query.Get(name)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	v.Add(name, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return time.Time{}
+}
+
+// checkParams records an error for every parameter in query that isn't one
+// of known.
+func checkParams(v *models.ValidationError, query url.Values, known []string) {
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		if !slices.Contains(known, name) {
+			v.Add(name, "is not a known query parameter")
+		}
+	}
+}
+
+// writeQueryError responds to a request with invalid query parameters.
+func writeQueryError(w http.ResponseWriter, r *http.Request, err error) {
+	p := NewProblem(http.StatusBadRequest, "the query parameters are invalid")
+	var validationErr *models.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Errors = validationErr.Errors
+	}
+	WriteProblem(w, r, p)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// •	GET /lists → every list with its open and done todo counts, the inbox first
+// •	POST /lists {name} → 201 with the list
+// •	GET /lists/:id → the list
+// •	PUT /lists/:id {name} → 200 with the renamed list
+// •	DELETE /lists/:id?mode=move|cascade → 204, the todos are moved to the
+// inbox, and with mode=cascade to the trash along with their subtasks, from
+// where they are restored to the inbox. The inbox itself can't be deleted.
+// •	GET /lists/:id/todos → a page of the todos in the list, see GetTodos
+// •	POST /lists/:id/todos {title,...} → 201 with a todo created in the list
+func (h *RouteHandler) GetLists(w http.ResponseWriter, r *http.Request) {
+	lists, err := h.db.GetLists(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lists)
+}
+
+func (h *RouteHandler) CreateList(w http.ResponseWriter, r *http.Request) {
+	var in models.ListInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	list, err := h.db.CreateList(r.Context(), in.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *RouteHandler) GetList(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	list, err := h.db.GetList(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *RouteHandler) RenameList(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.ListInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	list, err := h.db.RenameList(r.Context(), params["id"], in.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *RouteHandler) DeleteList(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var v models.ValidationError
+	query := r.URL.Query()
+	checkParams(&v, query, []string{"mode"})
+	var cascade bool
+	switch query.Get("mode") {
+	case "", "move":
+	case "cascade":
+		cascade = true
+	default:
+		v.Add("mode", "must be move or cascade")
+	}
+	if err := v.Err(); err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	if err := h.db.DeleteList(r.Context(), params["id"], cascade); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetListTodos lists the todos in one list, taking the same query
+// parameters as GetTodos.
+func (h *RouteHandler) GetListTodos(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	opts, envelope, err := parseListQuery(r)
+	if err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	// an unknown list is a 404 rather than an empty page
+	if _, err := h.db.GetList(r.Context(), params["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	opts.Filter.ListId = params["id"]
+	h.writeTodoPage(w, r, opts, envelope)
+}
+
+// CreateListTodo creates a todo in the list, the body is the same as for
+// POST /todos, where listId may be left out.
+func (h *RouteHandler) CreateListTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.TodoInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+	if in.ListId != "" && in.ListId != params["id"] {
+		var v models.ValidationError
+		v.Add("listId", "must match the list in the path")
+		writeError(w, r, v.Err())
+		return
+	}
+
+	if _, err := h.db.GetList(r.Context(), params["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	todo := in.Todo()
+	todo.ListId = params["id"]
+	created, err := h.db.Create(r.Context(), todo)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeTodo(w, http.StatusCreated, created)
+}
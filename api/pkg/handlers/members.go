@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// •	GET /lists/:id/members → the owner of the list, with the role owner, and the users it is shared with
+// •	PUT /lists/:id/members/:userId {role} → 200 with the member, only the owner can change roles
+// •	DELETE /lists/:id/members/:userId → 204, the owner can remove anyone and members can leave
+// •	POST /lists/:id/invitations {role,expiresAt} → 201 with the invitation and its token, which is only ever shown here
+// •	POST /invitations/accept {token} → 200 with the caller as a member of the list, unknown, expired and used tokens are a 404
+// •	POST /lists/:id/transfer {userId} → 200 with the list, now owned by the member, the previous owner becomes an editor
+//
+// A list can be shared with viewers, who can read its todos, and editors,
+// who can also create, change and delete them. Only the owner can rename or
+// delete the list, share it and empty the trash of its todos. The inbox is
+// never shared.
+
+// CreatedInvitation is the response body of POST /lists/:id/invitations.
+type CreatedInvitation struct {
+	models.Invitation
+	// Token is what the invited user sends to POST /invitations/accept.
+	Token string `json:"token"`
+}
+
+func (h *RouteHandler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	members, err := h.db.ListMembers(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *RouteHandler) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.MemberInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	member, err := h.db.SetMemberRole(r.Context(), params["id"], params["userId"], in.Role)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *RouteHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if err := h.db.RemoveMember(r.Context(), params["id"], params["userId"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RouteHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.InvitationInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	inv, token, err := h.db.Invite(r.Context(), params["id"], in.Invitation())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreatedInvitation{Invitation: inv, Token: token})
+}
+
+func (h *RouteHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var in models.AcceptInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	member, err := h.db.AcceptInvitation(r.Context(), in.Token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *RouteHandler) TransferList(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.TransferInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	list, err := h.db.TransferList(r.Context(), params["id"], in.UserId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 
+	"example.com/todos/pkg/middleware"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -20,7 +23,29 @@ var HttpRequestCounter = prometheus.NewCounterVec(
 	[]string{"path", "method"}, // Labels for path and method
 )
 
+// AuthzDecisionCounter counts the authorization decisions by the permission
+// a route requires, and whether it was allowed or denied.
+var AuthzDecisionCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "authz_decisions_total",
+		Help: "Total number of authorization decisions.",
+	},
+	[]string{"permission", "decision"},
+)
+
+// AuthzDecided counts a decision of middleware.Authorize, and logs denials.
+func AuthzDecided(r *http.Request, perm middleware.Permission, allowed bool) {
+	decision := "allowed"
+	if !allowed {
+		decision = "denied"
+		p, _ := middleware.PrincipalFromContext(r.Context())
+		log.Printf("Denied %s %s to user %q with roles %v, the %s permission is required",
+			r.Method, r.URL.Path, p.UserId, p.Roles, perm)
+	}
+	AuthzDecisionCounter.WithLabelValues(string(perm), decision).Inc()
+}
+
 func init() {
 	// Register the counter with the default Prometheus registry
-	prometheus.MustRegister(HttpRequestCounter)
+	prometheus.MustRegister(HttpRequestCounter, AuthzDecisionCounter)
 }
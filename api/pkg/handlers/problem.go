@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/todos/pkg/middleware"
+	"example.com/todos/pkg/models"
+)
+
+// ProblemContentType is the media type of RFC 9457 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object. Every error response from
+// the API uses it so clients can handle failures uniformly.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// Errors lists the invalid fields of a rejected request body.
+	Errors []models.FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem returns a Problem for the given status. Problems without a more
+// specific type use "about:blank" and the standard status text as the title.
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WriteProblem writes p as the response, filling in the request path and id.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get("X-Request-ID")
+	}
+	if p.RequestID == "" {
+		p.RequestID = middleware.RequestIDFromContext(r.Context())
+		w.Header().Set("X-Request-ID", p.RequestID)
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// NotFound responds to requests that don't match any route.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusNotFound, "no route matches "+r.URL.Path))
+}
+
+// MethodNotAllowed responds to requests for a known path with an unsupported
+// method.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path))
+}
+
+// InternalError responds to requests that failed unexpectedly, e.g. with a
+// panic, without leaking any details to the client.
+func InternalError(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusInternalServerError, ""))
+}
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/todos/pkg/db"
+	. "example.com/todos/pkg/handlers"
+	"example.com/todos/pkg/middleware"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TestWriteProblem_WritesProblemJSON verifies that problems are written with
+// the problem+json media type and carry the status, title and request id.
+func TestWriteProblem_WritesProblemJSON(t *testing.T) {
+	h := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, NewProblem(http.StatusConflict, "title already taken"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", nil)
+	req.Header.Set("X-Request-Id", "req-abc-123")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Fatalf("expected Content-Type %q, got %q", ProblemContentType, ct)
+	}
+
+	var p Problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode problem, %v", err)
+	}
+	want := Problem{
+		Type:      "about:blank",
+		Title:     "Conflict",
+		Status:    http.StatusConflict,
+		Detail:    "title already taken",
+		Instance:  "/todos",
+		RequestID: "req-abc-123",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("expected problem %+v, got %+v", want, p)
+	}
+}
+
+// TestWriteProblem_SetsRequestIDWithoutMiddleware ensures that responses
+// written outside of the middleware chain, e.g. for unmatched routes, still
+// carry a request id that matches the X-Request-Id header.
+func TestWriteProblem_SetsRequestIDWithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/nope", nil)
+	rr := httptest.NewRecorder()
+
+	NotFound(rr, req)
+
+	var p Problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode problem, %v", err)
+	}
+	if p.Status != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, p.Status)
+	}
+	if p.RequestID == "" || p.RequestID != rr.Header().Get("X-Request-Id") {
+		t.Fatalf("expected request id %q to match X-Request-Id header %q", p.RequestID, rr.Header().Get("X-Request-Id"))
+	}
+}
+
+// TestProblemForError_HidesServerErrors ensures that unexpected errors are
+// not leaked to clients while client errors keep a useful detail.
+func TestProblemForError_HidesServerErrors(t *testing.T) {
+	p := ProblemForError(errors.New("pq: password authentication failed"))
+	if p.Status != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, p.Status)
+	}
+	if p.Detail != "" {
+		t.Fatalf("expected no detail for a server error, got %q", p.Detail)
+	}
+
+	p = ProblemForError(db.ErrNotFound)
+	if p.Status != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, p.Status)
+	}
+	if p.Detail == "" {
+		t.Fatalf("expected a detail for a client error")
+	}
+}
+
+// TestProblemForError_HidesDatabaseErrors ensures that the messages of
+// database errors, which name tables, constraints and values, are replaced
+// by a fixed detail.
+func TestProblemForError_HidesDatabaseErrors(t *testing.T) {
+	pgErr := &pgconn.PgError{
+		Code:           "23505",
+		Message:        `duplicate key value violates unique constraint "tags_owner_id_name_key"`,
+		ConstraintName: "tags_owner_id_name_key",
+	}
+	p := ProblemForError(fmt.Errorf("%w: %w", db.ErrConflict, pgErr))
+	if p.Status != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d", http.StatusConflict, p.Status)
+	}
+	if p.Detail != "the resource conflicts with one that already exists" {
+		t.Fatalf("expected the detail for a unique violation, got %q", p.Detail)
+	}
+
+	pgErr = &pgconn.PgError{Code: "22P02", Message: `invalid input syntax for type integer: "abc"`}
+	p = ProblemForError(fmt.Errorf("%w: %w", db.ErrInvalid, pgErr))
+	if p.Status != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, p.Status)
+	}
+	if p.Detail == "" || strings.Contains(p.Detail, "abc") {
+		t.Fatalf("expected a fixed detail for malformed input, got %q", p.Detail)
+	}
+}
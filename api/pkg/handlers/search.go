@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"example.com/todos/pkg/db"
+	"example.com/todos/pkg/models"
+)
+
+// MaxSearchQueryLength is the longest search query, in characters, accepted.
+const MaxSearchQueryLength = 256
+
+// SearchTodos runs a full-text search over the todos, best matches first.
+// The q parameter supports web search syntax: words, "quoted phrases", or,
+// and -excluded words.
+func (h *RouteHandler) SearchTodos(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseSearchQuery(r)
+	if err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	results, err := h.db.Search(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// parseSearchQuery reads the q and limit query parameters.
+func parseSearchQuery(r *http.Request) (opts db.SearchOptions, err error) {
+	var v models.ValidationError
+	query := r.URL.Query()
+
+	checkParams(&v, query, []string{"q", "limit"})
+
+	opts.Query = strings.TrimSpace(query.Get("q"))
+	switch {
+	case opts.Query == "":
+		v.Add("q", "must not be empty")
+	case utf8.RuneCountInString(opts.Query) > MaxSearchQueryLength:
+		v.Add("q", "must be at most %d characters", MaxSearchQueryLength)
+	}
+
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > db.MaxSearchLimit {
+			v.Add("limit", "must be a number between 1 and %d", db.MaxSearchLimit)
+		}
+		opts.Limit = limit
+	}
+
+	return opts, v.Err()
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultUpcoming is how many upcoming occurrences GetTodoSeries returns
+	// unless told otherwise.
+	DefaultUpcoming = 5
+	// MaxUpcoming is the most upcoming occurrences GetTodoSeries returns.
+	MaxUpcoming = 50
+)
+
+// TodoSeries is the response body of GET /todos/{id}/series.
+type TodoSeries struct {
+	Recurrence string        `json:"recurrence"`
+	Todos      []models.Todo `json:"todos"`
+	// Upcoming are the times the series recurs at after its last todo.
+	Upcoming []time.Time `json:"upcoming"`
+}
+
+// GetTodoSeries returns every todo in the recurring series of a todo,
+// ordered by due date, and the next upcoming occurrences, as many as the
+// upcoming parameter asks for.
+func (h *RouteHandler) GetTodoSeries(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var v models.ValidationError
+	query := r.URL.Query()
+	checkParams(&v, query, []string{"upcoming"})
+	upcoming := DefaultUpcoming
+	if s := query.Get("upcoming"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > MaxUpcoming {
+			v.Add("upcoming", "must be a number between 0 and %d", MaxUpcoming)
+		}
+		upcoming = n
+	}
+	if err := v.Err(); err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+
+	todos, err := h.db.Series(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the last todo is the one the series continues from
+	last := todos[len(todos)-1]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TodoSeries{
+		Recurrence: last.Recurrence,
+		Todos:      todos,
+		Upcoming:   last.UpcomingOccurrences(upcoming),
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"example.com/todos/pkg/models"
+
+	"github.com/gorilla/mux"
+)
+
+// •	GET /tags → every tag with the number of todos it labels
+// •	POST /tags {name} → 201 with the tag, 409 if the name is taken
+// •	GET /tags/:id → the tag
+// •	PUT /tags/:id {name} → 200 with the renamed tag
+// •	DELETE /tags/:id → 204, the tag is removed from every todo
+func (h *RouteHandler) GetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.db.ListTags(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+func (h *RouteHandler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	var in models.TagInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	tag, err := h.db.CreateTag(r.Context(), in.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tag)
+}
+
+func (h *RouteHandler) GetTag(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	tag, err := h.db.GetTag(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tag)
+}
+
+// RenameTag renames the tag, which renames it on every todo it labels.
+func (h *RouteHandler) RenameTag(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var in models.TagInput
+	if !decodeInput(w, r, &in) {
+		return
+	}
+
+	tag, err := h.db.RenameTag(r.Context(), params["id"], in.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tag)
+}
+
+func (h *RouteHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if err := h.db.DeleteTag(r.Context(), params["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// GetTrash lists the deleted todos that haven't been purged yet, taking the
+// same query parameters as GetTodos.
+func (h *RouteHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	opts, envelope, err := parseListQuery(r)
+	if err != nil {
+		writeQueryError(w, r, err)
+		return
+	}
+	opts.Filter.Trashed = true
+	// archived todos go to the trash like any other
+	if !r.URL.Query().Has("archived") {
+		opts.Filter.Archived = nil
+	}
+	h.writeTodoPage(w, r, opts, envelope)
+}
+
+// RestoreTodo takes a todo out of the trash along with the subtasks that were
+// deleted with it. A subtask can only be restored once its parent is.
+func (h *RouteHandler) RestoreTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	todo, err := h.db.Restore(r.Context(), params["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeTodo(w, http.StatusOK, todo)
+}
+
+// PurgeTodo permanently deletes a todo in the trash, it is a 404 for a todo
+// that hasn't been deleted first.
+func (h *RouteHandler) PurgeTodo(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	if err := h.db.Purge(r.Context(), params["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash permanently deletes every todo in the trash.
+func (h *RouteHandler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.db.PurgeTrash(r.Context(), 0); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,300 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// maxKeySetSize is the largest key set that is read, in bytes.
+const maxKeySetSize = 1 << 20
+
+// loadTimeout bounds a load of the key set, which doesn't use the context of
+// the request that needed it.
+const loadTimeout = 10 * time.Second
+
+// KeySet is a JSON Web Key Set (RFC 7517) loaded from a file or URL. Its keys
+// are cached for TTL, and loaded again early when a token names a key that
+// isn't in the set, so that the issuer can rotate its keys at any time. If
+// loading fails the keys loaded before are kept.
+//
+// Requests that need the keys at the same time share a single load, which
+// goes on if they are cancelled, so that one cancelled request can't leave
+// every other without keys until MinReload has passed.
+type KeySet struct {
+	// Source is the path or http(s) URL of the key set.
+	Source string
+	// TTL is how long the keys are used before they are loaded again, zero
+	// keeps them until a token names an unknown key.
+	TTL time.Duration
+	// MinReload is how long to wait between loads, so that tokens naming
+	// unknown keys can't make every request fetch the key set.
+	MinReload time.Duration
+	// Client fetches http(s) sources, a client with a 10s timeout if nil.
+	Client *http.Client
+
+	group    singleflight.Group
+	mu       sync.Mutex
+	keys     []publicKey
+	err      error
+	loaded   time.Time
+	attempt  time.Time
+	attempts int
+}
+
+// publicKey is a key of the set that can verify signatures.
+type publicKey struct {
+	kid, alg string
+	key      crypto.PublicKey
+}
+
+// jwk is the JSON form of a key, only the members of RSA, EC and OKP keys
+// are read.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Key returns the key with id kid that can verify signatures with alg. An
+// empty kid matches the only such key of the set. Keys that aren't in the set
+// fail with ErrInvalidToken, ErrKeysUnavailable means the set couldn't be
+// loaded.
+func (s *KeySet) Key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	now := time.Now()
+	s.mu.Lock()
+	stale := s.attempts == 0 || (s.TTL > 0 && now.Sub(s.loaded) >= s.TTL)
+	load := stale && s.mayLoad(now)
+	keys, err := s.keys, s.err
+	s.mu.Unlock()
+
+	if load {
+		keys, err = s.reload(ctx)
+	}
+	if keys == nil {
+		return nil, err
+	}
+
+	key, ok := find(keys, kid, alg)
+	if !ok && !stale && s.reloadable(now) {
+		// the issuer may have rotated its keys since they were loaded
+		if keys, err = s.reload(ctx); keys != nil {
+			key, ok = find(keys, kid, alg)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s key with id %q", ErrInvalidToken, alg, kid)
+	}
+	return key, nil
+}
+
+// mayLoad reports whether MinReload has passed since the last load, the
+// caller must hold s.mu.
+func (s *KeySet) mayLoad(now time.Time) bool {
+	return s.attempts == 0 || now.Sub(s.attempt) >= s.MinReload
+}
+
+// reloadable is mayLoad for callers that don't hold s.mu.
+func (s *KeySet) reloadable(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mayLoad(now)
+}
+
+// reload loads the key set, or waits for the load already in progress, and
+// returns the keys and the error of the latest load. Callers stop waiting
+// when ctx is done, the load itself has its own timeout.
+func (s *KeySet) reload(ctx context.Context) ([]publicKey, error) {
+	done := s.group.DoChan("", func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+		defer cancel()
+		s.load(ctx, time.Now())
+		return nil, nil
+	})
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys, s.err
+}
+
+// find returns the key with id kid, or the only key if kid is empty, that
+// verifies alg.
+func find(keys []publicKey, kid, alg string) (crypto.PublicKey, bool) {
+	var found []crypto.PublicKey
+	for _, k := range keys {
+		if (kid == "" || k.kid == kid) && (k.alg == "" || k.alg == alg) && verifies(k.key, alg) {
+			found = append(found, k.key)
+		}
+	}
+	if len(found) != 1 {
+		return nil, false
+	}
+	return found[0], true
+}
+
+// load reads the key set from its source and replaces the keys with it, or
+// keeps them and records the error if it fails. Only recording the outcome
+// holds s.mu, so that tokens can still be checked against the cached keys
+// meanwhile, and callers that need a load too join this one.
+func (s *KeySet) load(ctx context.Context, now time.Time) {
+	data, err := s.read(ctx)
+	var keys []publicKey
+	if err == nil {
+		keys, err = parseKeySet(data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempt = now
+	s.attempts++
+	if err != nil {
+		s.err = fmt.Errorf("%w: %w", ErrKeysUnavailable, err)
+		return
+	}
+	s.keys, s.err, s.loaded = keys, nil, now
+}
+
+func (s *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		f, err := os.Open(s.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, maxKeySetSize))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	client := s.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", s.Source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxKeySetSize))
+}
+
+// parseKeySet parses a JSON Web Key Set, returning the keys that can verify
+// signatures. Keys of other types, for encryption or that don't decode are
+// skipped, so that one odd key doesn't break the set, but a set without any
+// usable key is an error.
+func parseKeySet(data []byte) ([]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	var keys []publicKey
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil && key != nil {
+			keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("the key set has no RSA, P-256 or Ed25519 signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes the key, or returns nil for key types and curves that
+// aren't supported.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported exponent %v", e)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeFixed(k.X, 32)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeFixed(k.Y, 32)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeFixed(k.X, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("must not be empty")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeFixed(s string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("must be %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
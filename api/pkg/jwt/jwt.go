@@ -0,0 +1,241 @@
+// Package jwt verifies JSON Web Tokens (RFC 7519), like the access tokens of
+// an OpenID Connect provider, against the keys of a JSON Web Key Set.
+//
+// Only signed tokens in the compact form are supported, with the RS256,
+// ES256 and EdDSA (Ed25519) algorithms. Unsigned tokens, HMAC signatures and
+// encrypted tokens are rejected.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken means the token is malformed, its signature doesn't
+	// verify or its claims aren't accepted.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrKeysUnavailable means the key set couldn't be loaded, so no token
+	// can be verified for now.
+	ErrKeysUnavailable = errors.New("the signing keys are unavailable")
+)
+
+// The supported signature algorithms.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// minRSABits is the smallest RSA key accepted.
+const minRSABits = 2048
+
+// NumericDate is a claim holding seconds since the epoch.
+type NumericDate struct {
+	time.Time
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return err
+	}
+	whole, frac := math.Modf(secs)
+	d.Time = time.Unix(int64(whole), int64(frac*1e9))
+	return nil
+}
+
+// Audience is the aud claim, a single string or an array of them.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// Scope is a space separated scope claim (RFC 8693), or an array of scopes as
+// some providers issue them.
+type Scope []string
+
+func (s *Scope) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = strings.Fields(str)
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(s))
+}
+
+// Claims are the claims of a token that are checked or mapped to a principal.
+type Claims struct {
+	Issuer    string       `json:"iss"`
+	Subject   string       `json:"sub"`
+	Audience  Audience     `json:"aud"`
+	ExpiresAt *NumericDate `json:"exp"`
+	NotBefore *NumericDate `json:"nbf"`
+	IssuedAt  *NumericDate `json:"iat"`
+	Scope     Scope        `json:"scope"`
+	Scp       Scope        `json:"scp"`
+}
+
+// Scopes returns the scopes of the scope and scp claims.
+func (c Claims) Scopes() []string {
+	return slices.Concat(c.Scope, c.Scp)
+}
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// Verifier checks the signature and claims of tokens.
+type Verifier struct {
+	Keys *KeySet
+	// Issuer is the required iss claim.
+	Issuer string
+	// Audience must be one of the aud claim.
+	Audience string
+	// Leeway is the clock skew allowed checking exp, nbf and iat.
+	Leeway time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+// Verify returns the claims of token if it is signed by a key of the key set
+// and its claims are valid now, i.e. it was issued by the Issuer for the
+// Audience and hasn't expired. It fails with an error wrapping
+// ErrInvalidToken, or ErrKeysUnavailable.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: not a signed JWT", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+	if len(h.Crit) > 0 {
+		return Claims{}, fmt.Errorf("%w: unsupported critical header parameters %v", ErrInvalidToken, h.Crit)
+	}
+	if h.Alg != RS256 && h.Alg != ES256 && h.Alg != EdDSA {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %w", ErrInvalidToken, err)
+	}
+
+	key, err := v.Keys.Key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return Claims{}, err
+	}
+	if !verify(key, h.Alg, parts[0]+"."+parts[1], sig) {
+		return Claims{}, fmt.Errorf("%w: the signature doesn't verify", ErrInvalidToken)
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %w", ErrInvalidToken, err)
+	}
+	if err := v.check(c); err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return c, nil
+}
+
+// check validates the registered claims of a token.
+func (v *Verifier) check(c Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	switch {
+	case c.Issuer != v.Issuer:
+		return fmt.Errorf("issuer %q is not accepted", c.Issuer)
+	case !slices.Contains(c.Audience, v.Audience):
+		return fmt.Errorf("the token is not for audience %q", v.Audience)
+	case c.ExpiresAt == nil:
+		return errors.New("the token has no expiry")
+	case !now.Before(c.ExpiresAt.Add(v.Leeway)):
+		return errors.New("the token has expired")
+	case c.NotBefore != nil && now.Before(c.NotBefore.Add(-v.Leeway)):
+		return errors.New("the token is not valid yet")
+	case c.IssuedAt != nil && now.Before(c.IssuedAt.Add(-v.Leeway)):
+		return errors.New("the token was issued in the future")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifies reports whether key is of the type alg signs with.
+func verifies(key crypto.PublicKey, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == RS256 && k.N.BitLen() >= minRSABits
+	case *ecdsa.PublicKey:
+		return alg == ES256 && k.Curve == elliptic.P256()
+	case ed25519.PublicKey:
+		return alg == EdDSA
+	default:
+		return false
+	}
+}
+
+// verify reports whether sig is a valid alg signature of signed by key.
+func verify(key crypto.PublicKey, alg, signed string, sig []byte) bool {
+	if !verifies(key, alg) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(signed))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes the signature as r and s of 32 bytes each, not ASN.1
+		if len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, hash[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, []byte(signed), sig)
+	default:
+		return false
+	}
+}
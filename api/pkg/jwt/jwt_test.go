@@ -0,0 +1,368 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "example.com/todos/pkg/jwt"
+)
+
+// signer is a locally generated key that signs tokens.
+type signer struct {
+	kid, alg string
+	key      crypto.Signer
+}
+
+func newSigner(t *testing.T, kid, alg string) signer {
+	t.Helper()
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch alg {
+	case RS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key, %v", alg, err)
+	}
+	return signer{kid: kid, alg: alg, key: key}
+}
+
+// jwk returns the public key as a JSON Web Key.
+func (s signer) jwk() map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	k := map[string]string{"kid": s.kid, "use": "sig", "alg": s.alg}
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		k["kty"], k["n"], k["e"] = "RSA", enc(pub.N.Bytes()), enc(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		b, _ := pub.Bytes()
+		k["kty"], k["crv"], k["x"], k["y"] = "EC", "P-256", enc(b[1:33]), enc(b[33:])
+	case ed25519.PublicKey:
+		k["kty"], k["crv"], k["x"] = "OKP", "Ed25519", enc(pub)
+	}
+	return k
+}
+
+// sign returns a token with the claims signed by the key.
+func (s signer) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode token, %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"}) + "." + enc(claims)
+
+	var sig []byte
+	var err error
+	switch k := s.key.(type) {
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, k, hash[:]); err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token, %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func keySet(signers ...signer) []byte {
+	keys := []map[string]string{
+		// keys that can't verify signatures are skipped
+		{"kty": "oct", "k": "c2VjcmV0"},
+		{"kty": "EC", "crv": "P-384", "x": "AA", "y": "AA"},
+	}
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	return data
+}
+
+// jwksServer serves a key set that can be replaced, counting the requests.
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []byte
+	requests int
+}
+
+func newJWKSServer(t *testing.T, signers ...signer) *jwksServer {
+	s := &jwksServer{keys: keySet(signers...)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Write(s.keys)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) rotate(signers ...signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keySet(signers...)
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+const (
+	issuer   = "https://id.example.com/"
+	audience = "todos"
+)
+
+var now = time.Date(2025, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+func claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"iss":   issuer,
+		"sub":   "auth0|alice",
+		"aud":   []string{"other", audience},
+		"iat":   now.Add(-time.Minute).Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	signers := []signer{newSigner(t, "rsa", RS256), newSigner(t, "ec", ES256), newSigner(t, "ed", EdDSA)}
+	server := newJWKSServer(t, signers...)
+	v := &Verifier{
+		Keys:     &KeySet{Source: server.URL, TTL: time.Hour},
+		Issuer:   issuer,
+		Audience: audience,
+		Leeway:   time.Minute,
+		Now:      func() time.Time { return now },
+	}
+
+	for _, s := range signers {
+		c, err := v.Verify(context.Background(), s.sign(t, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: failed to verify token, %v", s.alg, err)
+		}
+		if c.Subject != "auth0|alice" || c.Issuer != issuer || !c.ExpiresAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("%s: got wrong claims %+v", s.alg, c)
+		}
+		if got := c.Scopes(); !slices.Equal(got, []string{"read", "write"}) {
+			t.Errorf("%s: got scopes %v want [read write]", s.alg, got)
+		}
+	}
+
+	ed := signers[2]
+	valid := []struct {
+		name   string
+		claims map[string]any
+	}{
+		{"audience string", claims(map[string]any{"aud": audience})},
+		{"expired within leeway", claims(map[string]any{"exp": now.Add(-30 * time.Second).Unix()})},
+		{"not before within leeway", claims(map[string]any{"nbf": now.Add(30 * time.Second).Unix()})},
+		{"scp array", claims(map[string]any{"scope": nil, "scp": []string{"read"}})},
+	}
+	for _, tt := range valid {
+		if _, err := v.Verify(context.Background(), ed.sign(t, tt.claims)); err != nil {
+			t.Errorf("%s: failed to verify token, %v", tt.name, err)
+		}
+	}
+
+	token := ed.sign(t, claims(nil))
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"`+issuer+`","sub":"admin"}`)) + "." + parts[2]
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	hmac := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"ed"}`)) + "." + parts[1] + "." + parts[2]
+	wrongKey := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"ed"}`)) + "." + parts[1] + "." + parts[2]
+
+	invalid := []struct {
+		name, token string
+	}{
+		{"not a JWT", "todo_abc"},
+		{"tampered", tampered},
+		{"alg none", unsigned},
+		{"HMAC", hmac},
+		{"alg of another key type", wrongKey},
+		{"unknown key", newSigner(t, "other", EdDSA).sign(t, claims(nil))},
+		{"other issuer", ed.sign(t, claims(map[string]any{"iss": "https://evil.example.com/"}))},
+		{"other audience", ed.sign(t, claims(map[string]any{"aud": "billing"}))},
+		{"no audience", ed.sign(t, claims(map[string]any{"aud": nil}))},
+		{"expired", ed.sign(t, claims(map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}))},
+		{"no expiry", ed.sign(t, claims(map[string]any{"exp": nil}))},
+		{"not valid yet", ed.sign(t, claims(map[string]any{"nbf": now.Add(2 * time.Minute).Unix()}))},
+		{"issued in the future", ed.sign(t, claims(map[string]any{"iat": now.Add(2 * time.Minute).Unix()}))},
+	}
+	for _, tt := range invalid {
+		if _, err := v.Verify(context.Background(), tt.token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got: %v", tt.name, err)
+		}
+	}
+}
+
+func TestKeySet_CachesAndRotates(t *testing.T) {
+	old, current := newSigner(t, "2025-01", EdDSA), newSigner(t, "2025-03", ES256)
+	server := newJWKSServer(t, old)
+	v := &Verifier{
+		Keys:     &KeySet{Source: server.URL, TTL: time.Hour, MinReload: 0},
+		Issuer:   issuer,
+		Audience: audience,
+		Now:      func() time.Time { return now },
+	}
+	verify := func(s signer) error {
+		_, err := v.Verify(context.Background(), s.sign(t, claims(nil)))
+		return err
+	}
+
+	for range 3 {
+		if err := verify(old); err != nil {
+			t.Fatalf("failed to verify token, %v", err)
+		}
+	}
+	if got := server.count(); got != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d requests", got)
+	}
+
+	// a token signed with a new key loads the key set again
+	server.rotate(old, current)
+	if err := verify(current); err != nil {
+		t.Fatalf("failed to verify token signed with a rotated key, %v", err)
+	}
+	if got := server.count(); got != 2 {
+		t.Fatalf("expected the key set to be fetched again, got %d requests", got)
+	}
+
+	// once the old key is retired, its tokens are rejected after a reload
+	server.rotate(current)
+	if err := verify(old); err != nil {
+		t.Fatalf("expected the cached old key to still verify, %v", err)
+	}
+	v.Keys = &KeySet{Source: server.URL, TTL: time.Hour}
+	if err := verify(old); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for a retired key, got: %v", err)
+	}
+
+	// unknown keys don't reload the set more often than MinReload
+	v.Keys = &KeySet{Source: server.URL, TTL: time.Hour, MinReload: time.Hour}
+	before := server.count()
+	for range 3 {
+		if err := verify(old); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("expected ErrInvalidToken for a retired key, got: %v", err)
+		}
+	}
+	if got := server.count() - before; got != 1 {
+		t.Fatalf("expected a single fetch, got %d", got)
+	}
+}
+
+func TestKeySet_SharesLoads(t *testing.T) {
+	key := newSigner(t, "2025-03", EdDSA)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		<-release
+		w.Write(keySet(key))
+	}))
+	t.Cleanup(server.Close)
+	v := &Verifier{
+		Keys:     &KeySet{Source: server.URL, TTL: time.Hour, MinReload: time.Hour},
+		Issuer:   issuer,
+		Audience: audience,
+		Now:      func() time.Time { return now },
+	}
+	token := key.sign(t, claims(nil))
+
+	// a request cancelled while the keys load gives up waiting, the load goes
+	// on for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := v.Verify(ctx, token); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled request to stop waiting, got: %v", err)
+	}
+
+	errs := make(chan error)
+	for range 5 {
+		go func() {
+			_, err := v.Verify(context.Background(), token)
+			errs <- err
+		}()
+	}
+	close(release)
+	for range 5 {
+		if err := <-errs; err != nil {
+			t.Fatalf("failed to verify token, %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("expected a single fetch of the key set, got %d", requests)
+	}
+}
+
+func TestKeySet_LoadsFiles(t *testing.T) {
+	s := newSigner(t, "rsa", RS256)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keySet(s), 0o600); err != nil {
+		t.Fatalf("failed to write key set, %v", err)
+	}
+	v := &Verifier{Keys: &KeySet{Source: path}, Issuer: issuer, Audience: audience, Now: func() time.Time { return now }}
+	if _, err := v.Verify(context.Background(), s.sign(t, claims(nil))); err != nil {
+		t.Fatalf("failed to verify token, %v", err)
+	}
+
+	for _, source := range []string{filepath.Join(t.TempDir(), "missing.json"), newJWKSServer(t).URL + "/"} {
+		v := &Verifier{Keys: &KeySet{Source: source}, Issuer: issuer, Audience: audience, Now: func() time.Time { return now }}
+		if _, err := v.Verify(context.Background(), s.sign(t, claims(nil))); !errors.Is(err, ErrKeysUnavailable) {
+			t.Errorf("%s: expected ErrKeysUnavailable, got: %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrUnauthenticated means the request has no credentials, or ones that
+	// aren't valid.
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	// ErrForbidden means the caller was identified but isn't allowed to make
+	// the request.
+	ErrForbidden = errors.New("the credentials do not allow this request")
+)
+
+// Scopes limit what a principal may do, each one includes the ones before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// scopeRanks orders the scopes, a higher rank includes the lower ones.
+var scopeRanks = []string{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// HasScope reports whether the principal was granted scope, or a scope that
+// includes it.
+func (p Principal) HasScope(scope string) bool {
+	want := slices.Index(scopeRanks, scope)
+	if want < 0 {
+		return false
+	}
+	for _, granted := range p.Scopes {
+		if slices.Index(scopeRanks, granted) >= want {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator returns the principal a bearer token belongs to. Tokens that
+// aren't valid fail with an error wrapping ErrUnauthenticated.
+type Authenticator func(ctx context.Context, token string) (Principal, error)
+
+// AuthMiddleware identifies the caller by the Authorization: Bearer header of
+// the request and puts the principal in the context. Requests that only read
+// need the read scope, all others the write scope. Requests for which public
+// returns true don't need credentials. Failures are handed to onFailure with
+// an error wrapping ErrUnauthenticated or ErrForbidden, or the error of
+// authenticate, e.g. if the keys couldn't be looked up.
+func AuthMiddleware(authenticate Authenticator, public func(r *http.Request) bool, onFailure func(w http.ResponseWriter, r *http.Request, err error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if public(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			onFailure(w, r, ErrUnauthenticated)
+			return
+		}
+		p, err := authenticate(r.Context(), token)
+		if err != nil {
+			onFailure(w, r, err)
+			return
+		}
+
+		scope := ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			scope = ScopeRead
+		}
+		if !p.HasScope(scope) {
+			onFailure(w, r, fmt.Errorf("%w: the %s scope is required", ErrForbidden, scope))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// RequireScope only lets requests through whose principal has scope, e.g.
+// for admin routes. Others are handed to onFailure with an error wrapping
+// ErrForbidden, or ErrUnauthenticated without a principal.
+func RequireScope(scope string, onFailure func(w http.ResponseWriter, r *http.Request, err error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		switch {
+		case !ok:
+			onFailure(w, r, ErrUnauthenticated)
+		case !p.HasScope(scope):
+			onFailure(w, r, fmt.Errorf("%w: the %s scope is required", ErrForbidden, scope))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// Permission is what a route requires of the roles of the principal.
+type Permission string
+
+const (
+	PermRead  Permission = "read"
+	PermWrite Permission = "write"
+	PermPurge Permission = "purge"
+	PermAdmin Permission = "admin"
+)
+
+// Roles of users, each one is granted the permissions of the ones before it.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Policy grants permissions to roles.
+type Policy map[string][]Permission
+
+// DefaultPolicy lets viewers read, editors write and admins purge the trash
+// and manage users and keys.
+var DefaultPolicy = Policy{
+	RoleViewer: {PermRead},
+	RoleEditor: {PermRead, PermWrite},
+	RoleAdmin:  {PermRead, PermWrite, PermPurge, PermAdmin},
+}
+
+// Allows reports whether one of the roles of p is granted perm.
+func (policy Policy) Allows(p Principal, perm Permission) bool {
+	return slices.ContainsFunc(p.Roles, func(role string) bool {
+		return slices.Contains(policy[role], perm)
+	})
+}
+
+// Authorize only lets requests through whose principal policy allows perm.
+// Every decision is passed to onDecision, e.g. to count them. Denied requests
+// are handed to onFailure with an error wrapping ErrForbidden, or
+// ErrUnauthenticated without a principal.
+func Authorize(policy Policy, perm Permission, onDecision func(r *http.Request, perm Permission, allowed bool), onFailure func(w http.ResponseWriter, r *http.Request, err error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		allowed := ok && policy.Allows(p, perm)
+		onDecision(r, perm, allowed)
+		switch {
+		case !ok:
+			onFailure(w, r, ErrUnauthenticated)
+		case !allowed:
+			onFailure(w, r, fmt.Errorf("%w: the %s permission is required", ErrForbidden, perm))
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+type sharedWritesKeyType struct{}
+
+var sharedWritesKey = sharedWritesKeyType{}
+
+// SharedWrites is for routes that change todos, which may be in lists shared
+// with the principal, where its role in the list decides rather than its
+// global role. Principals whose roles aren't granted PermWrite get through
+// to next with a context in which SharedWritesOnly reports true, so that
+// their own todos stay out of reach.
+func SharedWrites(policy Policy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := PrincipalFromContext(r.Context()); ok && !policy.Allows(p, PermWrite) {
+			r = r.WithContext(context.WithValue(r.Context(), sharedWritesKey, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SharedWritesOnly reports whether the caller may only change the todos of
+// the lists shared with it as an editor, see SharedWrites.
+func SharedWritesOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(sharedWritesKey).(bool)
+	return only
+}
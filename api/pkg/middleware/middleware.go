@@ -2,7 +2,9 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"net/http"
+	"runtime/debug"
 	"time"
 
 	"github.com/google/uuid"
@@ -74,6 +76,48 @@ func RequestIDFromContext(ctx context.Context) string {
 	return uuid.New().String()
 }
 
+type principalKeyType struct{}
+
+var principalKey = principalKeyType{}
+
+// Principal is the authenticated caller of a request, the user it acts for
+// and what it may do. The roles are those of the user, the scopes those of
+// the credentials, which may allow less.
+type Principal struct {
+	UserId string
+	Roles  []string
+	Scopes []string
+	// System is set for internal callers, like the trash purger, that act
+	// for every user rather than one. Credentials never grant it.
+	System bool
+}
+
+// SystemPrincipal is the principal of internal callers, see Principal.System.
+var SystemPrincipal = Principal{System: true}
+
+// WithPrincipal returns a copy of ctx that carries p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the principal of the request, false if the
+// caller wasn't identified.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// DefaultPrincipalMiddleware makes p the principal of every request that
+// doesn't have one yet, so a single user service works without credentials.
+func DefaultPrincipalMiddleware(p Principal, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFromContext(r.Context()); !ok {
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func MetricsMiddleware(increment func(*http.Request), next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		increment(r)
@@ -81,3 +125,26 @@ func MetricsMiddleware(increment func(*http.Request), next http.Handler) http.Ha
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RecoveryMiddleware recovers from panics in next, logs them with a stack
+// trace and calls onPanic to write the response.
+func RecoveryMiddleware(onPanic func(w http.ResponseWriter, r *http.Request, recovered any), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// http.ErrAbortHandler is how handlers deliberately abort a
+			// response, let the server handle it as usual
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			log.Printf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+			onPanic(w, r, recovered)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
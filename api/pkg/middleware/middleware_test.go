@@ -1,6 +1,8 @@
 package middleware_test
 
 import (
+	"context"
+	"errors"
 	"maps"
 	"net/http"
 	"net/http/httptest"
@@ -170,6 +172,84 @@ func TestLoggingMiddleware_CallsNextHandler(t *testing.T) {
 	}
 }
 
+// TestRecoveryMiddleware_RecoversFromPanics verifies that a panicking handler
+// doesn't crash the server and that onPanic gets to write the response.
+func TestRecoveryMiddleware_RecoversFromPanics(t *testing.T) {
+	var recovered any
+	onPanic := func(w http.ResponseWriter, r *http.Request, rec any) {
+		recovered = rec
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	finalHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	h := RecoveryMiddleware(onPanic, finalHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if recovered != "boom" {
+		t.Fatalf("expected onPanic to receive the panic value, got %#v", recovered)
+	}
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code 500, got %d", rr.Code)
+	}
+}
+
+// TestRecoveryMiddleware_PassesThrough ensures that requests that don't
+// panic are left alone.
+func TestRecoveryMiddleware_PassesThrough(t *testing.T) {
+	onPanic := func(w http.ResponseWriter, r *http.Request, rec any) {
+		t.Errorf("did not expect onPanic to be called")
+	}
+
+	finalHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	h := RecoveryMiddleware(onPanic, finalHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status code 202, got %d", rr.Code)
+	}
+}
+
+// TestDefaultPrincipalMiddleware_KeepsExistingPrincipal verifies that the
+// default principal is only used for requests without one.
+func TestDefaultPrincipalMiddleware_KeepsExistingPrincipal(t *testing.T) {
+	var seen []string
+	finalHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			t.Fatalf("expected a principal in the context")
+		}
+		seen = append(seen, p.UserId)
+	})
+
+	h := DefaultPrincipalMiddleware(Principal{UserId: "1"}, finalHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req = req.WithContext(WithPrincipal(req.Context(), Principal{UserId: "2"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(seen) != 2 || seen[0] != "1" || seen[1] != "2" {
+		t.Fatalf("expected principals [1 2], got %v", seen)
+	}
+	if _, ok := PrincipalFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Fatalf("expected no principal without the middleware")
+	}
+}
+
 // fakeLogger implements Logger and records structured log entries
 // for verification in tests.
 type fakeLogger struct {
@@ -207,3 +287,160 @@ func (l *fakeLogger) Entries() []logEntry {
 	copy(out, l.entries)
 	return out
 }
+
+// TestAuthMiddleware_ChecksTokenAndScope verifies that requests need a bearer
+// token whose principal has the scope for the method, unless they are public.
+func TestAuthMiddleware_ChecksTokenAndScope(t *testing.T) {
+	authenticate := func(ctx context.Context, token string) (Principal, error) {
+		switch token {
+		case "reader":
+			return Principal{UserId: "1", Scopes: []string{ScopeRead}}, nil
+		case "admin":
+			return Principal{UserId: "2", Scopes: []string{ScopeAdmin}}, nil
+		}
+		return Principal{}, ErrUnauthenticated
+	}
+	public := func(r *http.Request) bool { return r.URL.Path == "/" }
+	var failure error
+	onFailure := func(w http.ResponseWriter, r *http.Request, err error) {
+		failure = err
+		w.WriteHeader(http.StatusTeapot)
+	}
+	var seen Principal
+	h := AuthMiddleware(authenticate, public, onFailure, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = PrincipalFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		method, path, authorization string
+		want                        error
+		user                        string
+	}{
+		{http.MethodGet, "/", "", nil, ""},
+		{http.MethodGet, "/todos", "", ErrUnauthenticated, ""},
+		{http.MethodGet, "/todos", "Basic reader", ErrUnauthenticated, ""},
+		{http.MethodGet, "/todos", "Bearer unknown", ErrUnauthenticated, ""},
+		{http.MethodGet, "/todos", "Bearer reader", nil, "1"},
+		{http.MethodGet, "/todos", "bearer  reader", nil, "1"},
+		{http.MethodPost, "/todos", "Bearer reader", ErrForbidden, ""},
+		{http.MethodPost, "/todos", "Bearer admin", nil, "2"},
+	}
+	for _, tt := range tests {
+		failure, seen = nil, Principal{}
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if !errors.Is(failure, tt.want) || (tt.want == nil && failure != nil) {
+			t.Errorf("%s %s with %q: got error %v want %v", tt.method, tt.path, tt.authorization, failure, tt.want)
+		}
+		if seen.UserId != tt.user {
+			t.Errorf("%s %s with %q: got user %q want %q", tt.method, tt.path, tt.authorization, seen.UserId, tt.user)
+		}
+	}
+}
+
+// TestRequireScope_RejectsLowerScopes verifies that higher scopes include the
+// lower ones and that requests without a principal are unauthenticated.
+func TestRequireScope_RejectsLowerScopes(t *testing.T) {
+	var failure error
+	h := RequireScope(ScopeWrite, func(w http.ResponseWriter, r *http.Request, err error) { failure = err }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		scopes []string
+		want   error
+	}{
+		{nil, ErrUnauthenticated},
+		{[]string{ScopeRead}, ErrForbidden},
+		{[]string{ScopeWrite}, nil},
+		{[]string{ScopeRead, ScopeAdmin}, nil},
+		{[]string{"root"}, ErrForbidden},
+	}
+	for _, tt := range tests {
+		failure = nil
+		req := httptest.NewRequest(http.MethodGet, "/admin/keys", nil)
+		if tt.scopes != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), Principal{UserId: "1", Scopes: tt.scopes}))
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if !errors.Is(failure, tt.want) || (tt.want == nil && failure != nil) {
+			t.Errorf("scopes %v: got error %v want %v", tt.scopes, failure, tt.want)
+		}
+	}
+}
+
+// TestAuthorize_EvaluatesPolicy verifies that routes only serve principals
+// with a role granted their permission, and that every decision is reported.
+func TestAuthorize_EvaluatesPolicy(t *testing.T) {
+	var failure error
+	decisions := map[bool]int{}
+	h := Authorize(DefaultPolicy, PermWrite,
+		func(r *http.Request, perm Permission, allowed bool) {
+			if perm != PermWrite {
+				t.Errorf("got decision for permission %q want %q", perm, PermWrite)
+			}
+			decisions[allowed]++
+		},
+		func(w http.ResponseWriter, r *http.Request, err error) { failure = err },
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		roles []string
+		want  error
+	}{
+		{nil, ErrUnauthenticated},
+		{[]string{}, ErrForbidden},
+		{[]string{RoleViewer}, ErrForbidden},
+		{[]string{"owner"}, ErrForbidden},
+		{[]string{RoleEditor}, nil},
+		{[]string{RoleViewer, RoleAdmin}, nil},
+	}
+	for _, tt := range tests {
+		failure = nil
+		req := httptest.NewRequest(http.MethodPost, "/todos", nil)
+		if tt.roles != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), Principal{UserId: "1", Roles: tt.roles}))
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if !errors.Is(failure, tt.want) || (tt.want == nil && failure != nil) {
+			t.Errorf("roles %v: got error %v want %v", tt.roles, failure, tt.want)
+		}
+	}
+	if decisions[true] != 2 || decisions[false] != 4 {
+		t.Errorf("got decisions %v want 2 allowed and 4 denied", decisions)
+	}
+
+	if DefaultPolicy.Allows(Principal{Roles: []string{RoleEditor}}, PermPurge) {
+		t.Errorf("editors should not be allowed to purge")
+	}
+	if !DefaultPolicy.Allows(Principal{Roles: []string{RoleAdmin}}, PermPurge) {
+		t.Errorf("admins should be allowed to purge")
+	}
+}
+
+// TestSharedWrites_MarksPrincipalsThatCannotWrite verifies that principals
+// whose roles don't allow writes are only let through to the shared lists.
+func TestSharedWrites_MarksPrincipalsThatCannotWrite(t *testing.T) {
+	var only bool
+	h := SharedWrites(DefaultPolicy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		only = SharedWritesOnly(r.Context())
+	}))
+
+	tests := []struct {
+		roles []string
+		want  bool
+	}{
+		{[]string{RoleViewer}, true},
+		{[]string{RoleEditor}, false},
+		{[]string{RoleViewer, RoleAdmin}, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPatch, "/todos/1", nil)
+		req = req.WithContext(WithPrincipal(req.Context(), Principal{UserId: "1", Roles: tt.roles}))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if only != tt.want {
+			t.Errorf("roles %v: got shared writes only %v want %v", tt.roles, only, tt.want)
+		}
+	}
+}
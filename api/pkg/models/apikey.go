@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxKeyNameLength is the longest API key name, in characters.
+const MaxKeyNameLength = 100
+
+// KeyScopes are the scopes an API key can be granted, each one includes the
+// ones before it.
+var KeyScopes = []string{"read", "write", "admin"}
+
+// APIKey lets a client act for a user. Only a hash of the secret is stored,
+// the secret itself is shown once, when the key is minted.
+type APIKey struct {
+	Id     string `json:"id"`
+	UserId string `json:"userId"`
+	Name   string `json:"name"`
+	// Prefix is the start of the secret, to tell keys apart.
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// APIKeyInput is the body accepted by POST /admin/keys.
+type APIKeyInput struct {
+	UserId    string     `json:"userId"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *APIKeyInput) UnmarshalJSON(data []byte) error {
+	*in = APIKeyInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate trims the name, sorts the scopes and checks them, returning a
+// *ValidationError.
+func (in *APIKeyInput) Validate() error {
+	var v ValidationError
+	if in.UserId == "" {
+		v.Add("userId", "must not be empty")
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "":
+		v.Add("name", "must not be empty")
+	case utf8.RuneCountInString(in.Name) > MaxKeyNameLength:
+		v.Add("name", "must be at most %d characters", MaxKeyNameLength)
+	}
+	if len(in.Scopes) == 0 {
+		v.Add("scopes", "must not be empty")
+	}
+	for i, scope := range in.Scopes {
+		if !slices.Contains(KeyScopes, scope) {
+			v.Add(fmt.Sprintf("scopes[%d]", i), "must be one of %s", strings.Join(KeyScopes, ", "))
+		}
+	}
+	slices.Sort(in.Scopes)
+	in.Scopes = slices.Compact(in.Scopes)
+	return v.Err()
+}
+
+// Key returns the API key described by the input.
+func (in APIKeyInput) Key() APIKey {
+	return APIKey{UserId: in.UserId, Name: in.Name, Scopes: in.Scopes, ExpiresAt: in.ExpiresAt}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxListNameLength is the longest list name, in characters.
+const MaxListNameLength = 100
+
+// List groups todos, e.g. by project. Every todo belongs to exactly one list,
+// the inbox unless another one is chosen. Each user has an inbox of their own.
+type List struct {
+	Id        string    `json:"id"`
+	OwnerId   string    `json:"ownerId"`
+	Name      string    `json:"name"`
+	Inbox     bool      `json:"inbox"`
+	OpenCount int       `json:"openCount"`
+	DoneCount int       `json:"doneCount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ListInput is the body accepted by POST /lists and PUT /lists/{id}.
+type ListInput struct {
+	Name string `json:"name"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *ListInput) UnmarshalJSON(data []byte) error {
+	*in = ListInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate trims the name and checks it, returning a *ValidationError.
+func (in *ListInput) Validate() error {
+	var v ValidationError
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "":
+		v.Add("name", "must not be empty")
+	case utf8.RuneCountInString(in.Name) > MaxListNameLength:
+		v.Add("name", "must be at most %d characters", MaxListNameLength)
+	}
+	return v.Err()
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// MemberRoles are the roles a list can be shared with, viewers can read its
+// todos and editors can also change them.
+var MemberRoles = []string{"viewer", "editor"}
+
+// OwnerRole is the role of the owner in the members of a list.
+const OwnerRole = "owner"
+
+// Member is a user a list is shared with, or its owner.
+type Member struct {
+	ListId    string    `json:"listId"`
+	UserId    string    `json:"userId"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Invitation shares a list with whoever accepts it first. Only a hash of its
+// token is stored, the token itself is shown once, when the invitation is
+// created.
+type Invitation struct {
+	Id         string     `json:"id"`
+	ListId     string     `json:"listId"`
+	Role       string     `json:"role"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	AcceptedBy *string    `json:"acceptedBy"`
+	AcceptedAt *time.Time `json:"acceptedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// InvitationInput is the body accepted by POST /lists/{id}/invitations.
+type InvitationInput struct {
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *InvitationInput) UnmarshalJSON(data []byte) error {
+	*in = InvitationInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate checks the role, returning a *ValidationError.
+func (in *InvitationInput) Validate() error {
+	var v ValidationError
+	validateMemberRole(&v, in.Role)
+	return v.Err()
+}
+
+// Invitation returns the invitation described by the input, one without an
+// expiry gets the default one when it is created.
+func (in InvitationInput) Invitation() Invitation {
+	var expiresAt time.Time
+	if in.ExpiresAt != nil {
+		expiresAt = *in.ExpiresAt
+	}
+	return Invitation{Role: in.Role, ExpiresAt: expiresAt}
+}
+
+// AcceptInput is the body accepted by POST /invitations/accept.
+type AcceptInput struct {
+	Token string `json:"token"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *AcceptInput) UnmarshalJSON(data []byte) error {
+	*in = AcceptInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate trims the token and checks it, returning a *ValidationError.
+func (in *AcceptInput) Validate() error {
+	var v ValidationError
+	in.Token = strings.TrimSpace(in.Token)
+	if in.Token == "" {
+		v.Add("token", "must not be empty")
+	}
+	return v.Err()
+}
+
+// MemberInput is the body accepted by PUT /lists/{id}/members/{userId}.
+type MemberInput struct {
+	Role string `json:"role"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *MemberInput) UnmarshalJSON(data []byte) error {
+	*in = MemberInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate checks the role, returning a *ValidationError.
+func (in *MemberInput) Validate() error {
+	var v ValidationError
+	validateMemberRole(&v, in.Role)
+	return v.Err()
+}
+
+// TransferInput is the body accepted by POST /lists/{id}/transfer.
+type TransferInput struct {
+	UserId string `json:"userId"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *TransferInput) UnmarshalJSON(data []byte) error {
+	*in = TransferInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate checks that a new owner was given, returning a *ValidationError.
+func (in *TransferInput) Validate() error {
+	var v ValidationError
+	if in.UserId == "" {
+		v.Add("userId", "must not be empty")
+	}
+	return v.Err()
+}
+
+func validateMemberRole(v *ValidationError, role string) {
+	if !slices.Contains(MemberRoles, role) {
+		v.Add("role", "must be one of %s", strings.Join(MemberRoles, ", "))
+	}
+}
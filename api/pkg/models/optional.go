@@ -0,0 +1,101 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Optional is a field of a JSON Merge Patch (RFC 7396) document. It tells
+// apart a field that was left out (Set is false), one explicitly set to null
+// (Null is true) and one set to a value.
+type Optional[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// Some returns an Optional set to v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{Set: true, Value: v}
+}
+
+// UnmarshalJSON is only called by encoding/json for fields present in the
+// document, which is what marks the Optional as set.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		o.Null = true
+		var zero T
+		o.Value = zero
+		return nil
+	}
+	o.Null = false
+	return json.Unmarshal(data, &o.Value)
+}
+
+// MarshalJSON encodes the value, or null. Unset fields should be tagged
+// omitzero so that they are left out.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	if o.Null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.Value)
+}
+
+// IsZero reports whether the field was left out, for use with omitzero.
+func (o Optional[T]) IsZero() bool {
+	return !o.Set
+}
+
+// unmarshalFields decodes the JSON object in data into the fields of the
+// struct pointed to by dst, matching keys against the json tags. Unlike
+// encoding/json it reports which field a type error came from even when the
+// field has its own UnmarshalJSON, and it always rejects unknown fields.
+func unmarshalFields(data []byte, dst any) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return &json.UnmarshalTypeError{Value: "null", Type: reflect.TypeOf(dst).Elem()}
+	}
+
+	v := reflect.ValueOf(dst).Elem()
+	fields := map[string]reflect.Value{}
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	// go through the keys in order so the first bad field is always reported
+	keys := slices.Sorted(maps.Keys(raw))
+	for _, key := range keys {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("json: unknown field %q", key)
+		}
+		if err := json.Unmarshal(raw[key], field.Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) && typeErr.Field == "" {
+				typeErr.Field = key
+			}
+			var timeErr *time.ParseError
+			if errors.As(err, &timeErr) {
+				v := &ValidationError{}
+				v.Add(key, "must be an RFC 3339 timestamp")
+				return v
+			}
+			return err
+		}
+	}
+	return nil
+}
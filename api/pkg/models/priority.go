@@ -0,0 +1,51 @@
+package models
+
+import (
+	"slices"
+	"strings"
+)
+
+// Priority is how urgent a todo is.
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// Priorities lists every priority from least to most urgent.
+var Priorities = []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityUrgent}
+
+// Valid reports whether p is one of Priorities.
+func (p Priority) Valid() bool {
+	return slices.Contains(Priorities, p)
+}
+
+// Rank orders priorities, from -1 for low to 2 for urgent with normal as 0.
+// It is how priorities are stored and sorted.
+func (p Priority) Rank() int {
+	if !p.Valid() {
+		return 0
+	}
+	return slices.Index(Priorities, p) - 1
+}
+
+// PriorityFromRank returns the priority with the given Rank.
+func PriorityFromRank(rank int) Priority {
+	if rank < -1 || rank >= len(Priorities)-1 {
+		return PriorityNormal
+	}
+	return Priorities[rank+1]
+}
+
+func validatePriority(v *ValidationError, p Priority) {
+	if !p.Valid() {
+		names := make([]string, len(Priorities))
+		for i, p := range Priorities {
+			names[i] = string(p)
+		}
+		v.Add("priority", "must be one of %s", strings.Join(names, ", "))
+	}
+}
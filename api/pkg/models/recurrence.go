@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+
+	"example.com/todos/pkg/rrule"
+)
+
+// normalizeRecurrence checks the RRULE in s and returns it in canonical
+// form, an empty rule means the todo doesn't repeat.
+func normalizeRecurrence(v *ValidationError, s string) string {
+	if s == "" {
+		return ""
+	}
+	rule, err := rrule.Parse(s)
+	if err != nil {
+		v.Add("recurrence", "must be a valid RRULE: %v", err)
+		return s
+	}
+	return rule.String()
+}
+
+// recurrenceStart is the start of the todo's recurrence, its due date or
+// else fallback.
+func (t Todo) recurrenceStart(fallback time.Time) time.Time {
+	if t.DueAt != nil {
+		return *t.DueAt
+	}
+	return fallback
+}
+
+// NextOccurrence returns the todo that follows this one in its series, due
+// at the first occurrence of its recurrence after both its due date and now,
+// or false if it doesn't repeat or the recurrence has ended. Occurrences
+// skipped because they are already past count towards the rule's COUNT.
+func (t Todo) NextOccurrence(now time.Time) (next Todo, ok bool) {
+	if t.Recurrence == "" {
+		return Todo{}, false
+	}
+	rule, err := rrule.Parse(t.Recurrence)
+	if err != nil {
+		return Todo{}, false
+	}
+
+	start := t.recurrenceStart(now)
+	after := start
+	if now.After(after) {
+		after = now
+	}
+	var skipped int
+	for occurrence := range rule.Occurrences(start) {
+		if !occurrence.After(after) {
+			skipped++
+			continue
+		}
+		// the next todo starts the rule over, so it only gets the occurrences
+		// left after this todo and the skipped ones
+		if rule.Count > 0 {
+			rule.Count -= max(skipped, 1)
+			if rule.Count < 1 {
+				return Todo{}, false
+			}
+		}
+		return Todo{
+			ListId:      t.ListId,
+			ParentId:    t.ParentId,
+			Title:       t.Title,
+			Description: t.Description,
+			Priority:    t.Priority,
+			DueAt:       &occurrence,
+			Tags:        t.Tags,
+			Recurrence:  rule.String(),
+			SeriesId:    t.SeriesId,
+		}, true
+	}
+	return Todo{}, false
+}
+
+// UpcomingOccurrences returns up to n of the times the todo recurs at after
+// its due date, or after it was created if it has none.
+func (t Todo) UpcomingOccurrences(n int) []time.Time {
+	upcoming := []time.Time{}
+	rule, err := rrule.Parse(t.Recurrence)
+	if t.Recurrence == "" || err != nil {
+		return upcoming
+	}
+	start := t.recurrenceStart(t.CreatedAt)
+	for occurrence := range rule.Occurrences(start) {
+		if len(upcoming) == n {
+			break
+		}
+		if occurrence.After(start) {
+			upcoming = append(upcoming, occurrence)
+		}
+	}
+	return upcoming
+}
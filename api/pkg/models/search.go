@@ -0,0 +1,12 @@
+package models
+
+// SearchResult is a todo matching a full-text search, with how well it
+// matches and its title with the matching words wrapped in <mark> tags.
+// DescriptionSnippet holds the best matching fragments of the description.
+// Both snippets are HTML, any markup in the todo itself is escaped.
+type SearchResult struct {
+	Todo
+	Rank               float32 `json:"rank"`
+	Snippet            string  `json:"snippet"`
+	DescriptionSnippet string  `json:"descriptionSnippet,omitempty"`
+}
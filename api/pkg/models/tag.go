@@ -0,0 +1,79 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTagLength is the longest tag name, in characters.
+	MaxTagLength = 50
+	// MaxTagsPerTodo is the most tags a single todo can have.
+	MaxTagsPerTodo = 20
+)
+
+// Tag labels todos. Names are unique per user and always lower case.
+type Tag struct {
+	Id        string    `json:"id"`
+	OwnerId   string    `json:"ownerId"`
+	Name      string    `json:"name"`
+	TodoCount int       `json:"todoCount"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TagInput is the body accepted by POST /tags and PUT /tags/{id}.
+type TagInput struct {
+	Name string `json:"name"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *TagInput) UnmarshalJSON(data []byte) error {
+	*in = TagInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate normalizes the name and checks it, returning a *ValidationError.
+func (in *TagInput) Validate() error {
+	var v ValidationError
+	name, err := NormalizeTag(in.Name)
+	if err != nil {
+		v.Add("name", "%v", err)
+	}
+	in.Name = name
+	return v.Err()
+}
+
+// NormalizeTag trims and lower cases a tag name and checks that it is
+// valid: not empty, not too long and without whitespace or commas.
+func NormalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case name == "":
+		return name, fmt.Errorf("must not be empty")
+	case utf8.RuneCountInString(name) > MaxTagLength:
+		return name, fmt.Errorf("must be at most %d characters", MaxTagLength)
+	case strings.ContainsFunc(name, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }):
+		return name, fmt.Errorf("must not contain whitespace or commas")
+	}
+	return name, nil
+}
+
+// normalizeTags normalizes every tag in place, reporting invalid ones under
+// field, and returns them sorted without duplicates.
+func normalizeTags(v *ValidationError, field string, tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for i, tag := range tags {
+		name, err := NormalizeTag(tag)
+		if err != nil {
+			v.Add(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+			continue
+		}
+		normalized = append(normalized, name)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
@@ -1,10 +1,275 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"strings"
+	"time"
+)
 
 type Todo struct {
-	Id        string    `json:"id"`
-	Title     string    `json:"title"`
-	Done      bool      `json:"done"`
-	CreatedAt time.Time `json:"createdAt"`
+	Id     string `json:"id"`
+	ListId string `json:"listId"`
+	// OwnerId is the user the todo belongs to, the owner of its list.
+	OwnerId string `json:"ownerId"`
+	// ParentId is the todo this one is a subtask of, nil at the top level.
+	ParentId *string `json:"parentId"`
+	Title    string  `json:"title"`
+	// Description is free text in Markdown.
+	Description string     `json:"description"`
+	Done        bool       `json:"done"`
+	Priority    Priority   `json:"priority"`
+	DueAt       *time.Time `json:"dueAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	// UpdatedAt and CompletedAt are maintained by the database.
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	// Version goes up with every write to the todo, it is sent as the ETag.
+	Version int `json:"version"`
+	// Tags are the names of the todo's tags, sorted.
+	Tags []string `json:"tags"`
+	// Recurrence is an RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=SA, empty if
+	// the todo doesn't repeat. Completing the todo creates the next one.
+	Recurrence string `json:"recurrence"`
+	// SeriesId is the id of the first todo of a recurring series, nil until
+	// the todo has been repeated at least once.
+	SeriesId *string `json:"seriesId"`
+	// Position orders todos arranged by hand, keys sort by their bytes.
+	Position string `json:"position"`
+	// ArchivedAt is when the completed todo was archived, nil otherwise.
+	ArchivedAt *time.Time `json:"archivedAt"`
+	// DeletedAt is when the todo was moved to the trash, nil otherwise.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Progress is the percentage of the todo's subtasks that are done, nil
+	// if it has none.
+	Progress *int `json:"progress,omitempty"`
+	// Children are the todo's subtasks, only filled in when asked for.
+	Children []Todo `json:"children,omitempty"`
+}
+
+// WithChildren returns the todo with its subtasks nested under it, taken from
+// descendants, which may hold the todo's whole subtree in any order.
+func (t Todo) WithChildren(descendants []Todo) Todo {
+	t.Children = []Todo{}
+	for _, child := range descendants {
+		if child.ParentId != nil && *child.ParentId == t.Id {
+			t.Children = append(t.Children, child.WithChildren(descendants))
+		}
+	}
+	return t
+}
+
+// IsOverdue reports whether the todo is still open past its due date.
+func (t Todo) IsOverdue(now time.Time) bool {
+	return !t.Done && t.DueAt != nil && t.DueAt.Before(now)
+}
+
+// TodoInput is the body accepted by POST /todos and by PUT /todos/{id},
+// which replaces every writable field of the todo. Without a listId new
+// todos go to their parent's list or the inbox, and replaced ones stay in
+// their list. Without a parentId the todo is at the top level.
+type TodoInput struct {
+	ListId      string     `json:"listId"`
+	ParentId    *string    `json:"parentId"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Done        bool       `json:"done"`
+	Priority    Priority   `json:"priority"`
+	DueAt       *time.Time `json:"dueAt"`
+	Tags        []string   `json:"tags"`
+	Recurrence  string     `json:"recurrence"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *TodoInput) UnmarshalJSON(data []byte) error {
+	*in = TodoInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate trims the input and checks it, returning a *ValidationError
+// listing every invalid field. A missing priority means normal.
+func (in *TodoInput) Validate() error {
+	var v ValidationError
+	in.Title = strings.TrimSpace(in.Title)
+	validateTitle(&v, in.Title)
+	if in.ParentId != nil && *in.ParentId == "" {
+		v.Add("parentId", "must not be empty")
+	}
+	validateDescription(&v, in.Description)
+	if in.Priority == "" {
+		in.Priority = PriorityNormal
+	}
+	validatePriority(&v, in.Priority)
+	in.Tags = normalizeTags(&v, "tags", in.Tags)
+	validateTagCount(&v, in.Tags)
+	in.Recurrence = normalizeRecurrence(&v, in.Recurrence)
+	return v.Err()
+}
+
+func validateTagCount(v *ValidationError, tags []string) {
+	if len(tags) > MaxTagsPerTodo {
+		v.Add("tags", "must have at most %d tags", MaxTagsPerTodo)
+	}
+}
+
+// Todo returns the todo described by the input.
+func (in TodoInput) Todo() Todo {
+	return Todo{
+		ListId:      in.ListId,
+		ParentId:    in.ParentId,
+		Title:       in.Title,
+		Description: in.Description,
+		Done:        in.Done,
+		Priority:    in.Priority,
+		DueAt:       in.DueAt,
+		Tags:        in.Tags,
+		Recurrence:  in.Recurrence,
+	}
+}
+
+// TodoPatch is a JSON Merge Patch (RFC 7396) of a todo, accepted by
+// PATCH /todos/{id}. Only the fields that are set are changed.
+// Setting description, priority, dueAt, tags or recurrence to null resets
+// them to their defaults, and setting parentId to null moves the todo to the top level.
+// listId, title and done can't be null.
+//
+// Tags can be replaced as a whole with tags, or changed one at a time with
+// addTags and removeTags, which are applied after tags.
+type TodoPatch struct {
+	ListId      Optional[string]    `json:"listId,omitzero"`
+	ParentId    Optional[string]    `json:"parentId,omitzero"`
+	Title       Optional[string]    `json:"title,omitzero"`
+	Description Optional[string]    `json:"description,omitzero"`
+	Done        Optional[bool]      `json:"done,omitzero"`
+	Priority    Optional[Priority]  `json:"priority,omitzero"`
+	DueAt       Optional[time.Time] `json:"dueAt,omitzero"`
+	Tags        Optional[[]string]  `json:"tags,omitzero"`
+	Recurrence  Optional[string]    `json:"recurrence,omitzero"`
+	AddTags     []string            `json:"addTags,omitempty"`
+	RemoveTags  []string            `json:"removeTags,omitempty"`
+}
+
+// UnmarshalJSON decodes a merge patch, rejecting unknown fields.
+func (p *TodoPatch) UnmarshalJSON(data []byte) error {
+	*p = TodoPatch{}
+	return unmarshalFields(data, p)
+}
+
+// Validate trims the patch and checks it, returning a *ValidationError
+// listing every invalid field.
+func (p *TodoPatch) Validate() error {
+	var v ValidationError
+	if p.ListId.Null || (p.ListId.Set && p.ListId.Value == "") {
+		v.Add("listId", "must not be empty")
+	}
+	if p.ParentId.Set && !p.ParentId.Null && p.ParentId.Value == "" {
+		v.Add("parentId", "must not be empty")
+	}
+	if p.Title.Set {
+		p.Title.Value = strings.TrimSpace(p.Title.Value)
+		if p.Title.Null {
+			v.Add("title", "must not be null")
+		} else {
+			validateTitle(&v, p.Title.Value)
+		}
+	}
+	validateDescription(&v, p.Description.Value)
+	if p.Done.Null {
+		v.Add("done", "must not be null")
+	}
+	if p.Priority.Null {
+		p.Priority = Some(PriorityNormal)
+	}
+	if p.Priority.Set {
+		validatePriority(&v, p.Priority.Value)
+	}
+	if p.Tags.Set {
+		p.Tags.Value = normalizeTags(&v, "tags", p.Tags.Value)
+		validateTagCount(&v, p.Tags.Value)
+	}
+	p.Recurrence.Value = normalizeRecurrence(&v, p.Recurrence.Value)
+	p.AddTags = normalizeTags(&v, "addTags", p.AddTags)
+	p.RemoveTags = normalizeTags(&v, "removeTags", p.RemoveTags)
+	for _, tag := range p.AddTags {
+		if slices.Contains(p.RemoveTags, tag) {
+			v.Add("removeTags", "must not contain %q, which is also added", tag)
+		}
+	}
+	return v.Err()
+}
+
+// IsEmpty reports whether the patch leaves the todo unchanged.
+func (p TodoPatch) IsEmpty() bool {
+	return !p.ListId.Set && !p.ParentId.Set && !p.Title.Set && !p.Description.Set && !p.Done.Set && !p.Priority.Set && !p.DueAt.Set &&
+		!p.Tags.Set && !p.Recurrence.Set && len(p.AddTags) == 0 && len(p.RemoveTags) == 0
+}
+
+// Apply returns todo with the patch applied.
+func (p TodoPatch) Apply(todo Todo) Todo {
+	if p.ListId.Set {
+		todo.ListId = p.ListId.Value
+	}
+	if p.ParentId.Set {
+		todo.ParentId = nil
+		if !p.ParentId.Null {
+			parentId := p.ParentId.Value
+			todo.ParentId = &parentId
+		}
+	}
+	if p.Title.Set {
+		todo.Title = p.Title.Value
+	}
+	if p.Description.Set {
+		todo.Description = p.Description.Value
+	}
+	if p.Done.Set {
+		todo.Done = p.Done.Value
+	}
+	if p.Priority.Set {
+		todo.Priority = p.Priority.Value
+	}
+	if p.DueAt.Set {
+		todo.DueAt = nil
+		if !p.DueAt.Null {
+			dueAt := p.DueAt.Value
+			todo.DueAt = &dueAt
+		}
+	}
+	if p.Tags.Set {
+		todo.Tags = p.Tags.Value
+	}
+	if p.Recurrence.Set {
+		todo.Recurrence = p.Recurrence.Value
+	}
+	if len(p.AddTags) > 0 || len(p.RemoveTags) > 0 {
+		tags := slices.Concat(todo.Tags, p.AddTags)
+		tags = slices.DeleteFunc(tags, func(tag string) bool { return slices.Contains(p.RemoveTags, tag) })
+		slices.Sort(tags)
+		todo.Tags = slices.Compact(tags)
+	}
+	return todo
+}
+
+// TodoMove is the body accepted by POST /todos/{id}/move, which places the
+// todo right before or right after another one.
+type TodoMove struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// UnmarshalJSON decodes the move, rejecting unknown fields.
+func (m *TodoMove) UnmarshalJSON(data []byte) error {
+	*m = TodoMove{}
+	return unmarshalFields(data, m)
+}
+
+// Validate checks that exactly one of before and after is given.
+func (m *TodoMove) Validate() error {
+	var v ValidationError
+	switch {
+	case m.Before == "" && m.After == "":
+		v.Add("before", "either before or after must be given")
+	case m.Before != "" && m.After != "":
+		v.Add("after", "must not be given along with before")
+	}
+	return v.Err()
 }
@@ -0,0 +1,368 @@
+package models_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "example.com/todos/pkg/models"
+)
+
+func TestTodoInput_Validate(t *testing.T) {
+	in := TodoInput{Title: "\t buy milk \n"}
+	if err := in.Validate(); err != nil {
+		t.Fatalf("expected valid input, got %v", err)
+	}
+	if in.Title != "buy milk" {
+		t.Errorf("expected title to be trimmed, got %q", in.Title)
+	}
+
+	for _, title := range []string{"", "   ", strings.Repeat("é", MaxTitleLength+1)} {
+		in := TodoInput{Title: title}
+		var v *ValidationError
+		if err := in.Validate(); !errors.As(err, &v) {
+			t.Fatalf("expected a validation error for title %q, got %v", title, err)
+		}
+		if len(v.Errors) != 1 || v.Errors[0].Field != "title" {
+			t.Errorf("expected a single title error, got %+v", v.Errors)
+		}
+	}
+
+	in = TodoInput{Title: strings.Repeat("é", MaxTitleLength)}
+	if err := in.Validate(); err != nil {
+		t.Errorf("expected a title of %d characters to be valid, got %v", MaxTitleLength, err)
+	}
+}
+
+func TestTodoPatch_Validate(t *testing.T) {
+	var p TodoPatch
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected an empty patch to be valid, got %v", err)
+	}
+	if !p.IsEmpty() {
+		t.Fatalf("expected an empty patch to be empty")
+	}
+
+	p = TodoPatch{Title: Some("  renamed ")}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected valid patch, got %v", err)
+	}
+	if p.Title.Value != "renamed" {
+		t.Errorf("expected title to be trimmed, got %q", p.Title.Value)
+	}
+
+	p = TodoPatch{Title: Some(" ")}
+	if err := p.Validate(); err == nil {
+		t.Fatalf("expected a blank title to be rejected")
+	}
+
+	p = TodoPatch{Title: Optional[string]{Set: true, Null: true}, Done: Optional[bool]{Set: true, Null: true}}
+	var v *ValidationError
+	if err := p.Validate(); !errors.As(err, &v) || len(v.Errors) != 2 {
+		t.Fatalf("expected null title and done to be rejected, got %v", err)
+	}
+}
+
+func TestTodoPatch_MergePatchSemantics(t *testing.T) {
+	tests := []struct {
+		body string
+		want Todo
+	}{
+		{`{}`, Todo{Id: "1", Title: "original", Done: false}},
+		{`{"done":true}`, Todo{Id: "1", Title: "original", Done: true}},
+		{`{"title":"renamed"}`, Todo{Id: "1", Title: "renamed", Done: false}},
+		{`{"title":"renamed","done":true}`, Todo{Id: "1", Title: "renamed", Done: true}},
+	}
+
+	for _, tt := range tests {
+		var p TodoPatch
+		if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+			t.Fatalf("failed to decode patch %s, %v", tt.body, err)
+		}
+		got := p.Apply(Todo{Id: "1", Title: "original"})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("applying %s: got %+v want %+v", tt.body, got, tt.want)
+		}
+	}
+
+	var p TodoPatch
+	if err := json.Unmarshal([]byte(`{"title":null}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if !p.Title.Set || !p.Title.Null || p.Done.Set {
+		t.Fatalf("expected title to be set to null and done to be left out, got %+v", p)
+	}
+
+	encoded, err := json.Marshal(TodoPatch{Done: Some(true)})
+	if err != nil {
+		t.Fatalf("failed to encode patch, %v", err)
+	}
+	if string(encoded) != `{"done":true}` {
+		t.Fatalf("expected unset fields to be left out, got %s", encoded)
+	}
+}
+
+func TestTodoInput_Details(t *testing.T) {
+	var in TodoInput
+	if err := json.Unmarshal([]byte(`{"title":"a","description":"**bold**","priority":"high","dueAt":"2024-05-01T12:00:00Z"}`), &in); err != nil {
+		t.Fatalf("failed to decode input, %v", err)
+	}
+	if err := in.Validate(); err != nil {
+		t.Fatalf("expected valid input, got %v", err)
+	}
+	todo := in.Todo()
+	if todo.Description != "**bold**" || todo.Priority != PriorityHigh || todo.DueAt == nil || todo.DueAt.Hour() != 12 {
+		t.Fatalf("input decoded to wrong todo: %+v", todo)
+	}
+
+	in = TodoInput{Title: "a"}
+	if err := in.Validate(); err != nil || in.Priority != PriorityNormal {
+		t.Fatalf("expected a missing priority to default to normal, got %q, %v", in.Priority, err)
+	}
+
+	in = TodoInput{Title: "a", Priority: "whenever", Description: strings.Repeat("a", MaxDescriptionLength+1)}
+	var v *ValidationError
+	if err := in.Validate(); !errors.As(err, &v) || len(v.Errors) != 2 {
+		t.Fatalf("expected description and priority errors, got %v", err)
+	}
+
+	err := json.Unmarshal([]byte(`{"title":"a","dueAt":"tomorrow"}`), &in)
+	if !errors.As(err, &v) || len(v.Errors) != 1 || v.Errors[0].Field != "dueAt" {
+		t.Fatalf("expected a dueAt error, got %v", err)
+	}
+}
+
+func TestTodoPatch_Details(t *testing.T) {
+	due := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	todo := Todo{Title: "a", Description: "notes", Priority: PriorityUrgent, DueAt: &due}
+
+	var p TodoPatch
+	if err := json.Unmarshal([]byte(`{"description":null,"priority":null,"dueAt":null}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected nulls to be valid, got %v", err)
+	}
+	got := p.Apply(todo)
+	if got.Description != "" || got.Priority != PriorityNormal || got.DueAt != nil {
+		t.Fatalf("expected null to reset fields to their defaults, got %+v", got)
+	}
+
+	p = TodoPatch{}
+	if err := json.Unmarshal([]byte(`{"dueAt":"2024-06-01T00:00:00Z","priority":"low"}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected valid patch, got %v", err)
+	}
+	got = p.Apply(todo)
+	if got.DueAt == nil || got.DueAt.Month() != time.June || got.Priority != PriorityLow || got.Description != "notes" {
+		t.Fatalf("patch applied wrongly, got %+v", got)
+	}
+	if todo.DueAt.Month() != time.May {
+		t.Fatalf("patch modified the original due date")
+	}
+
+	p = TodoPatch{Priority: Some(Priority("soon"))}
+	if err := p.Validate(); err == nil {
+		t.Fatalf("expected an unknown priority to be rejected")
+	}
+}
+
+func TestPriority_Rank(t *testing.T) {
+	for i, p := range Priorities {
+		if p.Rank() != i-1 {
+			t.Errorf("%s has rank %d, want %d", p, p.Rank(), i-1)
+		}
+		if PriorityFromRank(p.Rank()) != p {
+			t.Errorf("rank of %s did not round trip", p)
+		}
+	}
+	if PriorityNormal.Rank() != 0 {
+		t.Errorf("normal must be stored as the column default 0")
+	}
+}
+
+func TestTodoPatch_Tags(t *testing.T) {
+	todo := Todo{Title: "a", Tags: []string{"backend", "urgent"}}
+
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`{"addTags":["Frontend","backend"]}`, []string{"backend", "frontend", "urgent"}},
+		{`{"removeTags":["urgent","missing"]}`, []string{"backend"}},
+		{`{"tags":["ops"],"addTags":["db"]}`, []string{"db", "ops"}},
+		{`{"tags":null}`, nil},
+		{`{"tags":[" B ","a","b"]}`, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		var p TodoPatch
+		if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+			t.Fatalf("failed to decode patch %s, %v", tt.body, err)
+		}
+		if err := p.Validate(); err != nil {
+			t.Fatalf("expected %s to be valid, got %v", tt.body, err)
+		}
+		if got := p.Apply(todo).Tags; !slices.Equal(got, tt.want) {
+			t.Errorf("applying %s: got %v want %v", tt.body, got, tt.want)
+		}
+	}
+	if !slices.Equal(todo.Tags, []string{"backend", "urgent"}) {
+		t.Fatalf("patch modified the original tags: %v", todo.Tags)
+	}
+
+	for _, body := range []string{`{"addTags":["a"],"removeTags":["A"]}`, `{"addTags":[""]}`, `{"tags":["two words"]}`} {
+		var p TodoPatch
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatalf("failed to decode patch %s, %v", body, err)
+		}
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected %s to be rejected", body)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	if name, err := NormalizeTag("  Backend "); err != nil || name != "backend" {
+		t.Fatalf("expected backend, got %q, %v", name, err)
+	}
+	for _, bad := range []string{"", "  ", "a b", "a,b", strings.Repeat("a", MaxTagLength+1)} {
+		if _, err := NormalizeTag(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+
+	in := TodoInput{Title: "a", Tags: make([]string, MaxTagsPerTodo+1)}
+	for i := range in.Tags {
+		in.Tags[i] = fmt.Sprintf("tag%d", i)
+	}
+	var v *ValidationError
+	if err := in.Validate(); !errors.As(err, &v) || v.Errors[0].Field != "tags" {
+		t.Fatalf("expected too many tags to be rejected, got %v", err)
+	}
+}
+
+func TestTodoPatch_ParentId(t *testing.T) {
+	parentId := "1"
+	todo := Todo{Title: "a", ParentId: &parentId}
+
+	var p TodoPatch
+	if err := json.Unmarshal([]byte(`{"parentId":"2"}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if got := p.Apply(todo).ParentId; got == nil || *got != "2" {
+		t.Fatalf("patch did not move the todo, got %v", got)
+	}
+	if *todo.ParentId != "1" {
+		t.Fatalf("patch modified the original parent: %v", *todo.ParentId)
+	}
+
+	if err := json.Unmarshal([]byte(`{"parentId":null}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if got := p.Apply(todo).ParentId; got != nil {
+		t.Fatalf("null parentId must move the todo to the top level, got %v", *got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"parentId":""}`), &p); err != nil {
+		t.Fatalf("failed to decode patch, %v", err)
+	}
+	if err := p.Validate(); err == nil {
+		t.Fatalf("expected an empty parentId to be rejected")
+	}
+}
+
+func TestTodo_WithChildren(t *testing.T) {
+	id := func(s string) *string { return &s }
+	root := Todo{Id: "1"}
+	descendants := []Todo{
+		{Id: "2", ParentId: id("1")},
+		{Id: "3", ParentId: id("2")},
+		{Id: "4", ParentId: id("1")},
+		{Id: "5", ParentId: id("9")},
+	}
+
+	got := root.WithChildren(descendants)
+	if len(got.Children) != 2 || got.Children[0].Id != "2" || got.Children[1].Id != "4" {
+		t.Fatalf("wrong children: %+v", got.Children)
+	}
+	if len(got.Children[0].Children) != 1 || got.Children[0].Children[0].Id != "3" {
+		t.Fatalf("wrong grandchildren: %+v", got.Children[0].Children)
+	}
+	if got.Children[1].Children == nil {
+		t.Fatalf("todos without subtasks must have an empty list of children, not null")
+	}
+}
+
+func TestTodoInput_Recurrence(t *testing.T) {
+	in := TodoInput{Title: "water plants", Recurrence: "rrule:freq=weekly;byday=sa;interval=1"}
+	if err := in.Validate(); err != nil {
+		t.Fatalf("expected valid input, got %v", err)
+	}
+	if in.Recurrence != "FREQ=WEEKLY;BYDAY=SA" {
+		t.Errorf("expected the rule in canonical form, got %q", in.Recurrence)
+	}
+
+	in = TodoInput{Title: "water plants", Recurrence: "FREQ=HOURLY"}
+	var v *ValidationError
+	if err := in.Validate(); !errors.As(err, &v) || v.Errors[0].Field != "recurrence" {
+		t.Fatalf("expected a validation error for recurrence, got %v", err)
+	}
+}
+
+func TestTodo_NextOccurrence(t *testing.T) {
+	saturday := time.Date(2025, time.March, 8, 10, 0, 0, 0, time.UTC)
+	seriesId := "1"
+	todo := Todo{
+		Id: "3", ListId: "2", Title: "water plants", Done: true, Priority: PriorityHigh,
+		DueAt: &saturday, Tags: []string{"home"}, Recurrence: "FREQ=WEEKLY;BYDAY=SA", SeriesId: &seriesId,
+	}
+
+	tests := []struct {
+		name       string
+		recurrence string
+		now        time.Time
+		due        time.Time
+		wantRule   string
+	}{
+		{"on time", "FREQ=WEEKLY;BYDAY=SA", saturday.Add(-time.Hour), saturday.AddDate(0, 0, 7), "FREQ=WEEKLY;BYDAY=SA"},
+		{"late", "FREQ=WEEKLY;BYDAY=SA", saturday.AddDate(0, 0, 10), saturday.AddDate(0, 0, 14), "FREQ=WEEKLY;BYDAY=SA"},
+		{"counted", "FREQ=WEEKLY;COUNT=3", saturday, saturday.AddDate(0, 0, 7), "FREQ=WEEKLY;COUNT=2"},
+		{"counted and late", "FREQ=WEEKLY;COUNT=4", saturday.AddDate(0, 0, 8), saturday.AddDate(0, 0, 14), "FREQ=WEEKLY;COUNT=2"},
+	}
+	for _, tt := range tests {
+		todo.Recurrence = tt.recurrence
+		next, ok := todo.NextOccurrence(tt.now)
+		if !ok {
+			t.Fatalf("%s: expected a next occurrence", tt.name)
+		}
+		if next.DueAt == nil || !next.DueAt.Equal(tt.due) || next.Recurrence != tt.wantRule {
+			t.Errorf("%s: got due %v and %q, want %v and %q", tt.name, next.DueAt, next.Recurrence, tt.due, tt.wantRule)
+		}
+		if next.Id != "" || next.Done || next.Title != todo.Title || next.ListId != "2" || next.Priority != PriorityHigh ||
+			!slices.Equal(next.Tags, todo.Tags) || next.SeriesId == nil || *next.SeriesId != "1" {
+			t.Errorf("%s: next occurrence doesn't continue the todo: %+v", tt.name, next)
+		}
+	}
+
+	for _, recurrence := range []string{"", "FREQ=WEEKLY;COUNT=1", "FREQ=DAILY;UNTIL=20250309"} {
+		todo.Recurrence = recurrence
+		if next, ok := todo.NextOccurrence(saturday.AddDate(0, 0, 1)); ok {
+			t.Errorf("expected %q to have ended, got %v", recurrence, next.DueAt)
+		}
+	}
+
+	// without a due date the series continues from the time it was completed
+	todo.DueAt = nil
+	todo.Recurrence = "FREQ=DAILY"
+	next, ok := todo.NextOccurrence(saturday)
+	if !ok || !next.DueAt.Equal(saturday.AddDate(0, 0, 1)) {
+		t.Fatalf("expected the next day, got %v", next.DueAt)
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxUserNameLength is the longest user name, in characters.
+const MaxUserNameLength = 100
+
+// MaxSubjectLength is the longest token subject, in characters.
+const MaxSubjectLength = 255
+
+// UserRoles are the roles a user can have, viewers can read, editors write
+// and admins purge and manage users and keys.
+var UserRoles = []string{"viewer", "editor", "admin"}
+
+// DefaultUserRole is the role of users created without one.
+const DefaultUserRole = "editor"
+
+// User owns todos, lists and tags, and only ever sees their own.
+type User struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Subject is the sub claim of the JWTs that act for the user, if any.
+	Subject   string    `json:"subject,omitempty"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserInput is the body accepted by POST /admin/users.
+type UserInput struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+// UnmarshalJSON decodes the input, rejecting unknown fields.
+func (in *UserInput) UnmarshalJSON(data []byte) error {
+	*in = UserInput{}
+	return unmarshalFields(data, in)
+}
+
+// Validate trims the name and checks it, the subject and the role, which
+// defaults to DefaultUserRole, returning a *ValidationError.
+func (in *UserInput) Validate() error {
+	var v ValidationError
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "":
+		v.Add("name", "must not be empty")
+	case utf8.RuneCountInString(in.Name) > MaxUserNameLength:
+		v.Add("name", "must be at most %d characters", MaxUserNameLength)
+	}
+	if utf8.RuneCountInString(in.Subject) > MaxSubjectLength {
+		v.Add("subject", "must be at most %d characters", MaxSubjectLength)
+	}
+	if in.Role == "" {
+		in.Role = DefaultUserRole
+	} else if !slices.Contains(UserRoles, in.Role) {
+		v.Add("role", "must be one of %s", strings.Join(UserRoles, ", "))
+	}
+	return v.Err()
+}
+
+// User returns the user to create from a validated input.
+func (in UserInput) User() User {
+	return User{Name: in.Name, Subject: in.Subject, Role: in.Role}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTitleLength is the longest title, in characters, a todo can have.
+const MaxTitleLength = 200
+
+// MaxDescriptionLength is the longest description, in characters, a todo
+// can have.
+const MaxDescriptionLength = 10000
+
+// FieldError describes why a single field of an input was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found with an input.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Add records a problem with field.
+func (e *ValidationError) Add(field, format string, args ...any) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if any problems were recorded and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func validateTitle(v *ValidationError, title string) {
+	switch {
+	case title == "":
+		v.Add("title", "must not be empty")
+	case utf8.RuneCountInString(title) > MaxTitleLength:
+		v.Add("title", "must be at most %d characters", MaxTitleLength)
+	}
+}
+
+func validateDescription(v *ValidationError, description string) {
+	if utf8.RuneCountInString(description) > MaxDescriptionLength {
+		v.Add("description", "must be at most %d characters", MaxDescriptionLength)
+	}
+}
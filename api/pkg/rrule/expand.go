@@ -0,0 +1,194 @@
+package rrule
+
+import (
+	"iter"
+	"slices"
+	"time"
+)
+
+// horizon is how many years Occurrences looks ahead for a match, long enough
+// for the whole 400 year cycle of the Gregorian calendar, so a rule that
+// matches nothing in that time never will.
+const horizon = 400
+
+// Occurrences returns the times the rule recurs at, in order, starting from
+// dtstart. The times have the same clock time and location as dtstart, and
+// dtstart itself is only included if it matches the rule.
+func (r Rule) Occurrences(dtstart time.Time) iter.Seq[time.Time] {
+	return func(yield func(time.Time) bool) {
+		var count int
+		for period := 0; ; period++ {
+			candidates, start := r.period(dtstart, period)
+			if start.Year() > dtstart.Year()+horizon {
+				return
+			}
+			for _, t := range candidates {
+				if t.Before(dtstart) {
+					continue
+				}
+				if !r.Until.IsZero() && t.After(r.Until) {
+					return
+				}
+				if !yield(t) {
+					return
+				}
+				count++
+				if r.Count > 0 && count >= r.Count {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Next returns the first occurrence strictly after t, or false if the rule
+// has ended by then.
+func (r Rule) Next(dtstart, t time.Time) (time.Time, bool) {
+	for occurrence := range r.Occurrences(dtstart) {
+		if occurrence.After(t) {
+			return occurrence, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// period returns the candidate occurrences in the nth period of the rule's
+// frequency counting from the one dtstart is in, sorted, along with the
+// start of the period.
+func (r Rule) period(dtstart time.Time, n int) ([]time.Time, time.Time) {
+	year, month, day := dtstart.Date()
+	hour, minute, sec := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, sec, dtstart.Nanosecond(), dtstart.Location())
+	}
+
+	step := n * r.Interval
+	var days []time.Time
+	var start time.Time
+	switch r.Freq {
+	case Daily:
+		start = at(year, month, day+step)
+		days = []time.Time{start}
+	case Weekly:
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		start = at(year, month, day-offset+7*step)
+		for i := range 7 {
+			days = append(days, at(year, month, day-offset+7*step+i))
+		}
+	case Monthly:
+		start = at(year, month+time.Month(step), 1)
+		days = r.monthDays(start, dtstart.Day(), at)
+	case Yearly:
+		start = at(year+step, time.January, 1)
+		months := r.ByMonth
+		switch {
+		case len(months) > 0:
+		case len(r.ByDay) > 0:
+			// numbered weekdays count through the whole year
+			days = r.expandDays(start.Year(), time.January, daysIn(start.Year(), 0), at)
+		case len(r.ByMonthDay) > 0:
+			months = []time.Month{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+		default:
+			months = []time.Month{month}
+		}
+		for _, m := range months {
+			days = append(days, r.monthDays(at(start.Year(), m, 1), dtstart.Day(), at)...)
+		}
+	}
+
+	days = slices.DeleteFunc(days, func(t time.Time) bool { return !r.matches(t, dtstart) })
+	slices.SortFunc(days, time.Time.Compare)
+	return slices.CompactFunc(days, time.Time.Equal), start
+}
+
+// monthDays returns the days in the month starting at first that BYMONTHDAY
+// and BYDAY select, or the day numbered like dtstart's if neither is given.
+func (r Rule) monthDays(first time.Time, day int, at func(int, time.Month, int) time.Time) []time.Time {
+	year, month := first.Year(), first.Month()
+	n := daysIn(year, month)
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if day > n {
+			return nil
+		}
+		return []time.Time{at(year, month, day)}
+	}
+	return r.expandDays(year, month, n, at)
+}
+
+// expandDays returns the days of the n long stretch starting on the first of
+// month that BYMONTHDAY and BYDAY select.
+func (r Rule) expandDays(year int, month time.Month, n int, at func(int, time.Month, int) time.Time) []time.Time {
+	var days []time.Time
+	if len(r.ByDay) == 0 {
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d += n + 1
+			}
+			if d >= 1 && d <= n {
+				days = append(days, at(year, month, d))
+			}
+		}
+		return days
+	}
+
+	for _, wd := range r.ByDay {
+		var matching []time.Time
+		for i := 1; i <= n; i++ {
+			if t := at(year, month, i); t.Weekday() == wd.Weekday {
+				matching = append(matching, t)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			days = append(days, matching...)
+		case wd.N > 0 && wd.N <= len(matching):
+			days = append(days, matching[wd.N-1])
+		case wd.N < 0 && -wd.N <= len(matching):
+			days = append(days, matching[len(matching)+wd.N])
+		}
+	}
+	if len(r.ByMonthDay) > 0 {
+		// both are given, so a day has to match both
+		days = slices.DeleteFunc(days, func(t time.Time) bool { return !matchesMonthDay(t, r.ByMonthDay) })
+	}
+	return days
+}
+
+// matches reports whether t passes the rule parts that only limit which
+// days of a period occur, as opposed to the ones that expand a period.
+func (r Rule) matches(t, dtstart time.Time) bool {
+	if len(r.ByMonth) > 0 && !slices.Contains(r.ByMonth, t.Month()) {
+		return false
+	}
+	switch r.Freq {
+	case Daily:
+		if len(r.ByMonthDay) > 0 && !matchesMonthDay(t, r.ByMonthDay) {
+			return false
+		}
+		if len(r.ByDay) > 0 && !slices.ContainsFunc(r.ByDay, func(d WeekdayNum) bool { return d.Weekday == t.Weekday() }) {
+			return false
+		}
+	case Weekly:
+		if len(r.ByDay) == 0 {
+			return t.Weekday() == dtstart.Weekday()
+		}
+		return slices.ContainsFunc(r.ByDay, func(d WeekdayNum) bool { return d.Weekday == t.Weekday() })
+	}
+	return true
+}
+
+func matchesMonthDay(t time.Time, monthDays []int) bool {
+	n := daysIn(t.Year(), t.Month())
+	return slices.ContainsFunc(monthDays, func(d int) bool {
+		return d == t.Day() || d+n+1 == t.Day()
+	})
+}
+
+// daysIn returns the number of days in the month, or in the year if month
+// is 0.
+func daysIn(year int, month time.Month) int {
+	if month == 0 {
+		return time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	}
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,239 @@
+// Package rrule parses and expands RFC 5545 recurrence rules, e.g.
+// FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH.
+//
+// It supports FREQ of DAILY, WEEKLY, MONTHLY and YEARLY with INTERVAL,
+// COUNT, UNTIL, BYMONTH, BYMONTHDAY, BYDAY and WKST. Time based rule parts
+// such as BYHOUR and the rarely used BYSETPOS, BYWEEKNO and BYYEARDAY are
+// rejected rather than ignored.
+package rrule
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency int
+
+const (
+	Daily Frequency = iota + 1
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = []string{Daily: "DAILY", Weekly: "WEEKLY", Monthly: "MONTHLY", Yearly: "YEARLY"}
+
+func (f Frequency) String() string {
+	if f < Daily || f > Yearly {
+		return "Frequency(" + strconv.Itoa(int(f)) + ")"
+	}
+	return frequencies[f]
+}
+
+var weekdays = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WeekdayNum is an element of BYDAY: a weekday, optionally limited to the
+// Nth one of the month or year, counting from the end if N is negative.
+type WeekdayNum struct {
+	Weekday time.Weekday
+	// N is zero for every such weekday.
+	N int
+}
+
+func (d WeekdayNum) String() string {
+	if d.N == 0 {
+		return weekdays[d.Weekday]
+	}
+	return strconv.Itoa(d.N) + weekdays[d.Weekday]
+}
+
+// Rule is a parsed RRULE. The zero Until and Count mean the rule repeats
+// forever.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByMonth    []time.Month
+	ByMonthDay []int
+	ByDay      []WeekdayNum
+	WeekStart  time.Weekday
+}
+
+// untilLayouts are the forms UNTIL may take, a date means the end of that
+// day in UTC.
+var untilLayouts = []string{"20060102T150405Z", "20060102T150405", "20060102"}
+
+// Parse parses the value of an RRULE property, with or without the "RRULE:"
+// prefix. Rule part names and values are case insensitive.
+func Parse(s string) (Rule, error) {
+	r := Rule{Interval: 1, WeekStart: time.Monday}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "RRULE:")
+	if s == "" {
+		return Rule{}, fmt.Errorf("empty rule")
+	}
+
+	seen := map[string]bool{}
+	for part := range strings.SplitSeq(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Rule{}, fmt.Errorf("%q is not a NAME=VALUE rule part", part)
+		}
+		if seen[name] {
+			return Rule{}, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = Frequency(slices.Index(frequencies, value))
+			if r.Freq < Daily {
+				err = fmt.Errorf("must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			r.Interval, err = parseInt(value, 1, 1000)
+		case "COUNT":
+			r.Count, err = parseInt(value, 1, 10000)
+		case "UNTIL":
+			r.Until, err = parseUntil(value)
+		case "BYMONTH":
+			err = parseList(value, func(s string) error {
+				month, err := parseInt(s, 1, 12)
+				r.ByMonth = append(r.ByMonth, time.Month(month))
+				return err
+			})
+		case "BYMONTHDAY":
+			err = parseList(value, func(s string) error {
+				day, err := parseInt(s, -31, 31)
+				if err == nil && day == 0 {
+					err = fmt.Errorf("must not be 0")
+				}
+				r.ByMonthDay = append(r.ByMonthDay, day)
+				return err
+			})
+		case "BYDAY":
+			err = parseList(value, func(s string) error {
+				day, err := parseWeekdayNum(s)
+				r.ByDay = append(r.ByDay, day)
+				return err
+			})
+		case "WKST":
+			var day WeekdayNum
+			day, err = parseWeekdayNum(value)
+			if err == nil && day.N != 0 {
+				err = fmt.Errorf("must be a weekday")
+			}
+			r.WeekStart = day.Weekday
+		default:
+			err = fmt.Errorf("is not supported")
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	switch {
+	case r.Freq == 0:
+		return Rule{}, fmt.Errorf("FREQ is required")
+	case r.Count > 0 && !r.Until.IsZero():
+		return Rule{}, fmt.Errorf("COUNT and UNTIL must not both be given")
+	case r.Freq == Weekly && len(r.ByMonthDay) > 0:
+		return Rule{}, fmt.Errorf("BYMONTHDAY must not be given with FREQ=WEEKLY")
+	}
+	if r.Freq != Monthly && r.Freq != Yearly {
+		for _, day := range r.ByDay {
+			if day.N != 0 {
+				return Rule{}, fmt.Errorf("BYDAY: %s can only be used with FREQ=MONTHLY or YEARLY", day)
+			}
+		}
+	}
+	return r, nil
+}
+
+func parseInt(s string, lo, hi int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil || n < lo || n > hi {
+		return 0, fmt.Errorf("%q must be a number from %d to %d", s, lo, hi)
+	}
+	return n, nil
+}
+
+func parseList(value string, parse func(s string) error) error {
+	for item := range strings.SplitSeq(value, ",") {
+		if err := parse(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range untilLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			if layout == "20060102" {
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q must be a date or a UTC date-time", value)
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	if len(s) < 2 {
+		return WeekdayNum{}, fmt.Errorf("%q is not a weekday", s)
+	}
+	i := slices.Index(weekdays, s[len(s)-2:])
+	if i < 0 {
+		return WeekdayNum{}, fmt.Errorf("%q is not a weekday", s)
+	}
+	day := WeekdayNum{Weekday: time.Weekday(i)}
+	if n := s[:len(s)-2]; n != "" {
+		var err error
+		if day.N, err = parseInt(n, -53, 53); err != nil || day.N == 0 {
+			return WeekdayNum{}, fmt.Errorf("%q must be numbered from 1 to 53, or -1 to -53", s)
+		}
+	}
+	return day, nil
+}
+
+// String returns the rule in its canonical form, without the "RRULE:"
+// prefix and leaving out the parts that have their default value.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilLayouts[0]))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+join(r.ByMonth, func(m time.Month) string { return strconv.Itoa(int(m)) }))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+join(r.ByMonthDay, strconv.Itoa))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+join(r.ByDay, WeekdayNum.String))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdays[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+func join[T any](values []T, format func(T) string) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = format(v)
+	}
+	return strings.Join(s, ",")
+}
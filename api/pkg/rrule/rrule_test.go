@@ -0,0 +1,128 @@
+package rrule_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	. "example.com/todos/pkg/rrule"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;byday=mo,th", "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{"FREQ=WEEKLY;INTERVAL=1;WKST=MO", "FREQ=WEEKLY"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", "FREQ=MONTHLY;COUNT=3;BYDAY=-1FR"},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29;UNTIL=20300101", "FREQ=YEARLY;UNTIL=20300101T235959Z;BYMONTH=2;BYMONTHDAY=29"},
+		{"FREQ=WEEKLY;INTERVAL=2;WKST=SU", "FREQ=WEEKLY;INTERVAL=2;WKST=SU"},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.in)
+		if err != nil {
+			t.Fatalf("failed to parse %q, %v", tt.in, err)
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("parsing %q: got %q want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20300101",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ",
+	} {
+		if _, err := Parse(in); err == nil {
+			t.Errorf("expected %q to be rejected", in)
+		}
+	}
+}
+
+func TestRule_Occurrences(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		rule    string
+		dtstart string
+		want    []string
+	}{
+		{"FREQ=DAILY;INTERVAL=3", "2025-01-30 09:00", []string{"2025-01-30 09:00", "2025-02-02 09:00", "2025-02-05 09:00"}},
+		// dtstart is a Wednesday, so the first Monday is skipped
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", "2025-03-05 18:30", []string{"2025-03-07 18:30", "2025-03-17 18:30", "2025-03-21 18:30"}},
+		{"FREQ=WEEKLY", "2025-03-05 08:00", []string{"2025-03-05 08:00", "2025-03-12 08:00", "2025-03-19 08:00"}},
+		// months without a 31st are skipped
+		{"FREQ=MONTHLY", "2025-01-31 12:00", []string{"2025-01-31 12:00", "2025-03-31 12:00", "2025-05-31 12:00"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2025-01-15 12:00", []string{"2025-01-31 12:00", "2025-02-28 12:00", "2025-03-31 12:00"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "2025-01-01 10:00", []string{"2025-01-31 10:00", "2025-02-28 10:00", "2025-03-28 10:00"}},
+		{"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "2025-01-01 10:00", []string{"2025-06-13 10:00", "2026-02-13 10:00", "2026-03-13 10:00"}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29", "2025-01-01 00:00", []string{"2028-02-29 00:00", "2032-02-29 00:00", "2036-02-29 00:00"}},
+		{"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", "2025-01-01 15:00", []string{"2025-11-27 15:00", "2026-11-26 15:00", "2027-11-25 15:00"}},
+		{"FREQ=YEARLY;BYDAY=1MO", "2025-01-01 09:00", []string{"2025-01-06 09:00", "2026-01-05 09:00", "2027-01-04 09:00"}},
+		{"FREQ=DAILY;BYDAY=SA,SU", "2025-03-05 07:00", []string{"2025-03-08 07:00", "2025-03-09 07:00", "2025-03-15 07:00"}},
+		{"FREQ=DAILY;COUNT=2", "2025-03-05 07:00", []string{"2025-03-05 07:00", "2025-03-06 07:00"}},
+		{"FREQ=WEEKLY;UNTIL=20250319T080000Z", "2025-03-05 08:00", []string{"2025-03-05 08:00", "2025-03-12 08:00", "2025-03-19 08:00"}},
+		{"FREQ=WEEKLY;UNTIL=20250319T075959Z", "2025-03-05 08:00", []string{"2025-03-05 08:00", "2025-03-12 08:00"}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "2025-01-01 00:00", nil},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Fatalf("failed to parse %q, %v", tt.rule, err)
+		}
+		var got []string
+		for occurrence := range r.Occurrences(date(tt.dtstart)) {
+			got = append(got, occurrence.Format("2006-01-02 15:04"))
+			if len(got) == 3 {
+				break
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s from %s: got %v want %v", tt.rule, tt.dtstart, got, tt.want)
+		}
+	}
+}
+
+func TestRule_Next(t *testing.T) {
+	r, err := Parse("FREQ=WEEKLY;BYDAY=MO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database, %v", err)
+	}
+
+	// the clock time stays the same across the change to summer time
+	dtstart := time.Date(2025, time.March, 24, 9, 0, 0, 0, berlin)
+	next, ok := r.Next(dtstart, dtstart)
+	if want := time.Date(2025, time.March, 31, 9, 0, 0, 0, berlin); !ok || !next.Equal(want) {
+		t.Fatalf("got %v want %v", next, want)
+	}
+	if next.Sub(dtstart) != 7*24*time.Hour-time.Hour {
+		t.Fatalf("expected the week to be an hour short, got %v", next.Sub(dtstart))
+	}
+
+	r, _ = Parse("FREQ=DAILY;COUNT=2")
+	if _, ok := r.Next(dtstart, dtstart.Add(24*time.Hour)); ok {
+		t.Fatalf("expected the rule to have ended")
+	}
+}